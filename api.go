@@ -9,16 +9,12 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
-	"path"
 	"runtime"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/gin-gonic/gin"
 )
 
@@ -29,7 +25,7 @@ func init() {
 }
 
 func getStatus(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"uptime": time.Since(startTime).Seconds(), "registered": startTime.String(), "status": "standard", "num_cores": runtime.NumCPU(), "success": true})
+	c.JSON(http.StatusOK, gin.H{"uptime": time.Since(startTime).Seconds(), "registered": startTime.String(), "status": "standard", "num_cores": runtime.NumCPU(), "drift": getDriftScores(), "success": true})
 }
 
 // UserPositionJSON stores the a users time, location and bayes after calculatePosterior()
@@ -103,58 +99,30 @@ func getLastFingerprint(group string, user string) string {
 	user = strings.ToLower(user)
 	sentAs := ""
 
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
 	var v2 Fingerprint
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("fingerprints-track"))
-		if b == nil {
-			return nil
-		}
-		c := b.Cursor()
-		for k, v := c.Last(); k != nil; k, v = c.Prev() {
-			v3 := loadFingerprint(v)
-			if v3.Username == user {
-				v2 = v3
-				timestampString := string(k)
-				timestampUnixNano, _ := strconv.ParseInt(timestampString, 10, 64)
-				UTCfromUnixNano := time.Unix(0, timestampUnixNano)
-				v2.Timestamp = UTCfromUnixNano.UnixNano()
-				sentAs = "sent as /track\n"
-				break
-			}
-		}
-		return fmt.Errorf("User " + user + " not found")
+	storage.ForEachFingerprint(group, "fingerprints-track", true, func(k string, v3 Fingerprint) bool {
+		if v3.Username == user {
+			v2 = v3
+			v2.Timestamp = keyTimestamp(k)
+			sentAs = "sent as /track\n"
+			return false
+		}
+		return true
 	})
 
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("fingerprints-learn"))
-		if b == nil {
-			return nil
-		}
-		c := b.Cursor()
-		for k, v := c.Last(); k != nil; k, v = c.Prev() {
-			timestampString := string(k)
-			timestampUnixNano, _ := strconv.ParseInt(timestampString, 10, 64)
-			UTCfromUnixNano := time.Unix(0, timestampUnixNano).UnixNano()
-			if UTCfromUnixNano < v2.Timestamp {
-				break
-			}
-			v3 := loadFingerprint(v)
-			if v2.Username == user {
-				v2 = v3
-				v2.Timestamp = UTCfromUnixNano
-				sentAs = "sent as /learn\n"
-				break
-			}
+	storage.ForEachFingerprint(group, "fingerprints-learn", true, func(k string, v3 Fingerprint) bool {
+		UTCfromUnixNano := keyTimestamp(k)
+		if UTCfromUnixNano < v2.Timestamp {
+			return false
+		}
+		if v2.Username == user {
+			v2 = v3
+			v2.Timestamp = UTCfromUnixNano
+			sentAs = "sent as /learn\n"
+			return false
 		}
-		return fmt.Errorf("User " + user + " not found")
+		return true
 	})
-	db.Close()
 
 	bJson, _ := json.MarshalIndent(v2, "", " ")
 	return sentAs + string(bJson)
@@ -164,37 +132,17 @@ func getHistoricalUserPositions(group string, user string, n int) []UserPosition
 	group = strings.ToLower(group)
 	user = strings.ToLower(user)
 
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	var fingerprints []Fingerprint
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("fingerprints-track"))
-		if b == nil {
-			return nil
-		}
-		c := b.Cursor()
-		numFound := 0
-		for k, v := c.Last(); k != nil; k, v = c.Prev() {
-			v2 := loadFingerprint(v)
-			if v2.Username == user {
-				timestampString := string(k)
-				timestampUnixNano, _ := strconv.ParseInt(timestampString, 10, 64)
-				UTCfromUnixNano := time.Unix(0, timestampUnixNano)
-				v2.Timestamp = UTCfromUnixNano.UnixNano()
-				fingerprints = append(fingerprints, v2)
-				numFound++
-				if numFound >= n {
-					return nil
-				}
+	storage.ForEachFingerprint(group, "fingerprints-track", true, func(k string, v2 Fingerprint) bool {
+		if v2.Username == user {
+			v2.Timestamp = keyTimestamp(k)
+			fingerprints = append(fingerprints, v2)
+			if len(fingerprints) >= n {
+				return false
 			}
 		}
-		return fmt.Errorf("User " + user + " not found")
+		return true
 	})
-	db.Close()
 
 	Debug.Printf("Got history of %d fingerprints\n", len(fingerprints))
 	userJSONs := make([]UserPositionJSON, len(fingerprints))
@@ -220,39 +168,19 @@ func getHistoricalUserPositions(group string, user string, n int) []UserPosition
 
 func getCurrentPositionOfAllUsers(group string) map[string]UserPositionJSON {
 	group = strings.ToLower(group)
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	userPositions := make(map[string]UserPositionJSON)
 	userFingerprints := make(map[string]Fingerprint)
 	numUsersFound := 0
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("fingerprints-track"))
-		if b == nil {
-			return fmt.Errorf("Database not found")
-		}
-		c := b.Cursor()
-		for k, v := c.Last(); k != nil; k, v = c.Prev() {
-			v2 := loadFingerprint(v)
-			if _, ok := userPositions[v2.Username]; !ok {
-				timestampString := string(k)
-				timestampUnixNano, _ := strconv.ParseInt(timestampString, 10, 64)
-				UTCfromUnixNano := time.Unix(0, timestampUnixNano)
-				foo := UserPositionJSON{Time: UTCfromUnixNano.String()}
-				userPositions[v2.Username] = foo
-				userFingerprints[v2.Username] = v2
-				numUsersFound++
-			}
-			if numUsersFound > 40 {
-				return nil
-			}
-		}
-		return nil
+	err := storage.ForEachFingerprint(group, "fingerprints-track", true, func(k string, v2 Fingerprint) bool {
+		if _, ok := userPositions[v2.Username]; !ok {
+			UTCfromUnixNano := time.Unix(0, keyTimestamp(k))
+			foo := UserPositionJSON{Time: UTCfromUnixNano.String()}
+			userPositions[v2.Username] = foo
+			userFingerprints[v2.Username] = v2
+			numUsersFound++
+		}
+		return numUsersFound <= 40
 	})
-	db.Close()
 	if err != nil {
 		return userPositions
 	}
@@ -283,39 +211,25 @@ func getCurrentPositionOfUser(group string, user string) UserPositionJSON {
 	if ok {
 		return val
 	}
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
 	var userFingerprint Fingerprint
 	var userJSON UserPositionJSON
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("fingerprints-track"))
-		if b == nil {
-			return nil
-		}
-		c := b.Cursor()
-		i := 0
-		for k, v := c.Last(); k != nil; k, v = c.Prev() {
-			v2 := loadFingerprint(v)
-			i++
-			if i > 10000 {
-				return fmt.Errorf("Too deep!")
-			}
-			if v2.Username == user {
-				timestampString := string(k)
-				timestampUnixNano, _ := strconv.ParseInt(timestampString, 10, 64)
-				UTCfromUnixNano := time.Unix(0, timestampUnixNano)
-				userJSON.Time = UTCfromUnixNano.String()
-				userFingerprint = v2
-				return nil
-			}
-		}
-		return fmt.Errorf("User " + user + " not found")
+	found := false
+	i := 0
+	storage.ForEachFingerprint(group, "fingerprints-track", true, func(k string, v2 Fingerprint) bool {
+		i++
+		if i > 10000 {
+			return false
+		}
+		if v2.Username == user {
+			UTCfromUnixNano := time.Unix(0, keyTimestamp(k))
+			userJSON.Time = UTCfromUnixNano.String()
+			userFingerprint = v2
+			found = true
+			return false
+		}
+		return true
 	})
-	db.Close()
-	if err != nil {
+	if !found {
 		return userJSON
 	}
 	location, bayes := calculatePosterior(userFingerprint, *NewFullParameters())
@@ -345,26 +259,30 @@ func calculate(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
 			return
 		}
-		group = strings.ToLower(group)
-		optimizePriorsThreaded(group)
-		if RuntimeArgs.Svm {
-			dumpFingerprintsSVM(group)
-			err := calculateSVM(group)
-			if err != nil {
-				Warning.Println("Encountered error when calculating SVM")
-				Warning.Println(err)
-			}
-		}
-		if RuntimeArgs.RandomForests {
-			rfLearn(group)
-		}
-		go resetCache("userPositionCache")
+		recalculateGroup(strings.ToLower(group))
 		c.JSON(http.StatusOK, gin.H{"message": "Parameters optimized.", "success": true})
 	} else {
 		c.JSON(http.StatusOK, gin.H{"success": false, "message": "Error parsing request"})
 	}
 }
 
+// recalculateGroup regenerates the priors and any enabled classifiers for a group.
+func recalculateGroup(group string) {
+	optimizePriorsThreaded(group)
+	if RuntimeArgs.Svm {
+		dumpFingerprintsSVM(group)
+		err := calculateSVM(group)
+		if err != nil {
+			Warning.Println("Encountered error when calculating SVM")
+			Warning.Println(err)
+		}
+	}
+	if RuntimeArgs.RandomForests {
+		rfLearn(group)
+	}
+	go resetCache("userPositionCache")
+}
+
 func userLocations(c *gin.Context) {
 	c.Writer.Header().Set("Content-Type", "application/json")
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
@@ -443,62 +361,20 @@ func getUserLocations(c *gin.Context) {
 	}
 }
 
+// migrateDatabase merges the group from into the group to, see mergeGroups.
+// POST /database/merge also renames locations and users.
 func migrateDatabase(c *gin.Context) {
 	fromDB := strings.ToLower(c.DefaultQuery("from", "noneasdf"))
 	toDB := strings.ToLower(c.DefaultQuery("to", "noneasdf"))
 	Debug.Printf("Migrating %s to %s.\n", fromDB, toDB)
-	if !exists(path.Join(RuntimeArgs.SourcePath, fromDB+".db")) {
-		c.JSON(http.StatusOK, gin.H{"success": false, "message": "Can't migrate from " + fromDB + ", it does not exist."})
+	report, err := mergeGroups(MergeRequest{From: fromDB, To: toDB, DryRun: c.DefaultQuery("dryrun", "false") == "true"})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"success": false, "message": "Can't migrate " + fromDB + " to " + toDB + ": " + err.Error()})
 		return
 	}
-	if !exists(path.Join(RuntimeArgs.SourcePath, toDB)) {
-		CopyFile(path.Join(RuntimeArgs.SourcePath, fromDB+".db"), path.Join(RuntimeArgs.SourcePath, toDB+".db"))
-	} else {
-		db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, fromDB+".db"), 0664, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer db.Close()
-
-		db2, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, toDB+".db"), 0664, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer db2.Close()
-
-		db2.Update(func(tx *bolt.Tx) error {
-			bucket, err := tx.CreateBucketIfNotExists([]byte("fingerprints"))
-			if err != nil {
-				return fmt.Errorf("create bucket: %s", err)
-			}
-
-			db.View(func(tx *bolt.Tx) error {
-				b := tx.Bucket([]byte("fingerprints"))
-				c := b.Cursor()
-				for k, v := c.First(); k != nil; k, v = c.Next() {
-					bucket.Put(k, v)
-				}
-				return nil
-			})
-			return nil
-		})
-
-		db2.Update(func(tx *bolt.Tx) error {
-			bucket, err := tx.CreateBucketIfNotExists([]byte("fingerprints-track"))
-			if err != nil {
-				return fmt.Errorf("create bucket: %s", err)
-			}
-
-			db.View(func(tx *bolt.Tx) error {
-				b := tx.Bucket([]byte("fingerprints-track"))
-				c := b.Cursor()
-				for k, v := c.First(); k != nil; k, v = c.Next() {
-					bucket.Put(k, v)
-				}
-				return nil
-			})
-			return nil
-		})
+	if report.DryRun {
+		c.JSON(http.StatusOK, gin.H{"success": true, "message": mergeMessage(report), "report": report})
+		return
 	}
 	c.JSON(http.StatusOK, gin.H{"success": true, "message": "Successfully migrated " + fromDB + " to " + toDB})
 }
@@ -512,8 +388,12 @@ func deleteDatabase(c *gin.Context) {
 	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 
 	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
-	if exists(path.Join(RuntimeArgs.SourcePath, group+".db")) {
-		os.Remove(path.Join(RuntimeArgs.SourcePath, group+".db"))
+	if storage.GroupExists(group) {
+		_, err := trashGroup(group)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"success": false, "message": err.Error()})
+			return
+		}
 		c.JSON(http.StatusOK, gin.H{"success": true, "message": "Successfully deleted " + group})
 	} else {
 		c.JSON(http.StatusOK, gin.H{"success": false, "message": "Group does not exist"})
@@ -611,74 +491,16 @@ func editName(c *gin.Context) {
 	location := c.DefaultQuery("location", "none")
 	newname := c.DefaultQuery("newname", "none")
 	if group != "noneasdf" {
-		toUpdate := make(map[string]string)
 		numChanges := 0
-
-		db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		db.View(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte("fingerprints"))
-			if b != nil {
-				c := b.Cursor()
-				for k, v := c.Last(); k != nil; k, v = c.Prev() {
-					v2 := loadFingerprint(v)
-					if v2.Location == location {
-						v2.Location = newname
-						toUpdate[string(k)] = string(dumpFingerprint(v2))
-					}
-				}
-			}
-			return nil
-		})
-
-		db.Update(func(tx *bolt.Tx) error {
-			bucket, err := tx.CreateBucketIfNotExists([]byte("fingerprints"))
-			if err != nil {
-				return fmt.Errorf("create bucket: %s", err)
-			}
-
-			for k, v := range toUpdate {
-				bucket.Put([]byte(k), []byte(v))
-			}
-			return nil
-		})
-
-		numChanges += len(toUpdate)
-
-		toUpdate = make(map[string]string)
-
-		db.View(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte("fingerprints-track"))
-			if b != nil {
-				c := b.Cursor()
-				for k, v := c.Last(); k != nil; k, v = c.Prev() {
-					v2 := loadFingerprint(v)
-					if v2.Location == location {
-						v2.Location = newname
-						toUpdate[string(k)] = string(dumpFingerprint(v2))
-					}
+		for _, bucket := range []string{"fingerprints", "fingerprints-track"} {
+			numChanges += updateFingerprintsWhere(group, bucket, func(fingerprint *Fingerprint) bool {
+				if fingerprint.Location == location {
+					fingerprint.Location = newname
+					return true
 				}
-			}
-			return nil
-		})
-
-		db.Update(func(tx *bolt.Tx) error {
-			bucket, err := tx.CreateBucketIfNotExists([]byte("fingerprints-track"))
-			if err != nil {
-				return fmt.Errorf("create bucket: %s", err)
-			}
-
-			for k, v := range toUpdate {
-				bucket.Put([]byte(k), []byte(v))
-			}
-			return nil
-		})
-
-		db.Close()
-		numChanges += len(toUpdate)
+				return false
+			})
+		}
 		optimizePriorsThreaded(strings.ToLower(group))
 
 		c.JSON(http.StatusOK, gin.H{"message": "Changed name of " + strconv.Itoa(numChanges) + " things", "success": true})
@@ -699,74 +521,16 @@ func editUserName(c *gin.Context) {
 	user := strings.ToLower(c.DefaultQuery("user", "none"))
 	newname := strings.ToLower(c.DefaultQuery("newname", "none"))
 	if group != "noneasdf" {
-		toUpdate := make(map[string]string)
 		numChanges := 0
-
-		db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		db.View(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte("fingerprints"))
-			if b != nil {
-				c := b.Cursor()
-				for k, v := c.Last(); k != nil; k, v = c.Prev() {
-					v2 := loadFingerprint(v)
-					if v2.Username == user {
-						v2.Username = newname
-						toUpdate[string(k)] = string(dumpFingerprint(v2))
-					}
+		for _, bucket := range []string{"fingerprints", "fingerprints-track"} {
+			numChanges += updateFingerprintsWhere(group, bucket, func(fingerprint *Fingerprint) bool {
+				if fingerprint.Username == user {
+					fingerprint.Username = newname
+					return true
 				}
-			}
-			return nil
-		})
-
-		db.Update(func(tx *bolt.Tx) error {
-			bucket, err := tx.CreateBucketIfNotExists([]byte("fingerprints"))
-			if err != nil {
-				return fmt.Errorf("create bucket: %s", err)
-			}
-
-			for k, v := range toUpdate {
-				bucket.Put([]byte(k), []byte(v))
-			}
-			return nil
-		})
-
-		numChanges += len(toUpdate)
-
-		toUpdate = make(map[string]string)
-
-		db.View(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte("fingerprints-track"))
-			if b != nil {
-				c := b.Cursor()
-				for k, v := c.Last(); k != nil; k, v = c.Prev() {
-					v2 := loadFingerprint(v)
-					if v2.Username == user {
-						v2.Username = newname
-						toUpdate[string(k)] = string(dumpFingerprint(v2))
-					}
-				}
-			}
-			return nil
-		})
-
-		db.Update(func(tx *bolt.Tx) error {
-			bucket, err := tx.CreateBucketIfNotExists([]byte("fingerprints-track"))
-			if err != nil {
-				return fmt.Errorf("create bucket: %s", err)
-			}
-
-			for k, v := range toUpdate {
-				bucket.Put([]byte(k), []byte(v))
-			}
-			return nil
-		})
-
-		db.Close()
-		numChanges += len(toUpdate)
+				return false
+			})
+		}
 
 		// reset the cache (cache.go)
 		go resetCache("usersCache")
@@ -789,29 +553,14 @@ func deleteLocation(c *gin.Context) {
 	group := strings.ToLower(c.DefaultQuery("group", "noneasdf"))
 	location := strings.ToLower(c.DefaultQuery("location", "none"))
 	if group != "noneasdf" {
-		numChanges := 0
-
-		db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte("fingerprints"))
-			if b != nil {
-				c := b.Cursor()
-				for k, v := c.Last(); k != nil; k, v = c.Prev() {
-					v2 := loadFingerprint(v)
-					if v2.Location == location {
-						b.Delete(k)
-						numChanges++
-					}
-				}
-			}
-			return nil
+		entry, err := trashFingerprintsWhere(group, "fingerprints", "location "+location, func(fingerprint Fingerprint) bool {
+			return fingerprint.Location == location
 		})
-
-		db.Close()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"message": "Could not delete: " + err.Error(), "success": false})
+			return
+		}
+		numChanges := entry.Fingerprints
 		optimizePriorsThreaded(strings.ToLower(group))
 
 		c.JSON(http.StatusOK, gin.H{"message": "Deleted " + strconv.Itoa(numChanges) + " locations", "success": true})
@@ -832,30 +581,14 @@ func deleteLocations(c *gin.Context) {
 	locationsQuery := strings.ToLower(c.DefaultQuery("names", "none"))
 	if group != "noneasdf" && locationsQuery != "none" {
 		locations := strings.Split(strings.ToLower(locationsQuery), ",")
-		db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
+		entry, err := trashFingerprintsWhere(group, "fingerprints", "locations "+strings.Join(locations, ", "), func(fingerprint Fingerprint) bool {
+			return stringInSlice(fingerprint.Location, locations)
+		})
 		if err != nil {
-			log.Fatal(err)
+			c.JSON(http.StatusOK, gin.H{"message": "Could not delete: " + err.Error(), "success": false})
+			return
 		}
-
-		numChanges := 0
-		db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte("fingerprints"))
-			if b != nil {
-				c := b.Cursor()
-				for k, v := c.Last(); k != nil; k, v = c.Prev() {
-					v2 := loadFingerprint(v)
-					for _, location := range locations {
-						if v2.Location == location {
-							b.Delete(k)
-							numChanges++
-							break
-						}
-					}
-				}
-			}
-			return nil
-		})
-		db.Close()
+		numChanges := entry.Fingerprints
 		optimizePriorsThreaded(strings.ToLower(group))
 		c.JSON(http.StatusOK, gin.H{"message": "Deleted " + strconv.Itoa(numChanges) + " locations", "success": true})
 	} else {
@@ -874,29 +607,14 @@ func deleteUser(c *gin.Context) {
 	group := strings.ToLower(c.DefaultQuery("group", "noneasdf"))
 	user := strings.ToLower(c.DefaultQuery("user", "noneasdf"))
 	if group != "noneasdf" && user != "noneasdf" {
-		numChanges := 0
-
-		db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-
-		db.Update(func(tx *bolt.Tx) error {
-			b := tx.Bucket([]byte("fingerprints-track"))
-			if b != nil {
-				c := b.Cursor()
-				for k, v := c.Last(); k != nil; k, v = c.Prev() {
-					v2 := loadFingerprint(v)
-					if v2.Username == user {
-						b.Delete(k)
-						numChanges++
-					}
-				}
-			}
-			return nil
+		entry, err := trashFingerprintsWhere(group, "fingerprints-track", "user "+user, func(fingerprint Fingerprint) bool {
+			return fingerprint.Username == user
 		})
-
-		db.Close()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"message": "Could not delete: " + err.Error(), "success": false})
+			return
+		}
+		numChanges := entry.Fingerprints
 
 		// reset the cache (cache.go)
 		go resetCache("usersCache")
@@ -924,26 +642,12 @@ func whereAmI(c *gin.Context) {
 	var jsonData whereAmIJson
 	if c.BindJSON(&jsonData) == nil {
 		defer timeTrack(time.Now(), "getUniqueMacs")
-		db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, jsonData.Group+".db"), 0600, nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer db.Close()
 		locations := []string{}
-		db.View(func(tx *bolt.Tx) error {
-			// Assume bucket exists and has keys
-			b := tx.Bucket([]byte("fingerprints-track"))
-			c := b.Cursor()
-			for k, v := c.Last(); k != nil; k, v = c.Prev() {
-				v2 := loadFingerprint(v)
-				if v2.Username == jsonData.User {
-					locations = append(locations, v2.Location)
-				}
-				if len(locations) > 2 {
-					break
-				}
+		storage.ForEachFingerprint(jsonData.Group, "fingerprints-track", true, func(k string, v2 Fingerprint) bool {
+			if v2.Username == jsonData.User {
+				locations = append(locations, v2.Location)
 			}
-			return nil
+			return len(locations) <= 2
 		})
 		// jsonLocations, _ := json.Marshal(locations)
 		message := "Found user"
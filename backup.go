@@ -7,11 +7,8 @@
 package main
 
 import (
-	"log"
 	"os"
 	"path"
-
-	"github.com/boltdb/bolt"
 )
 
 func dumpFingerprints(group string) error {
@@ -21,50 +18,31 @@ func dumpFingerprints(group string) error {
 		return err
 	}
 
-	// Debug.Println("Opening db")
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0664, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
 	// Debug.Println("Opening file for learning fingerprints")
 	// Debug.Println(path.Join(RuntimeArgs.SourcePath, "dump-"+group, "learning"))
-	f, err := os.OpenFile(path.Join(RuntimeArgs.SourcePath, "dump-"+group, "learning"), os.O_WRONLY|os.O_CREATE, 0664)
+	err = dumpBucket(group, "fingerprints", path.Join(RuntimeArgs.SourcePath, "dump-"+group, "learning"))
 	if err != nil {
 		return err
 	}
-	// Debug.Println("Writing fingerprints to file")
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("fingerprints"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if _, err = f.WriteString(string(decompressByte(v)) + "\n"); err != nil {
-				panic(err)
-			}
-		}
-		return nil
-	})
-	f.Close()
 
 	// Debug.Println("Opening file for tracking fingerprints")
-	f, err = os.OpenFile(path.Join(RuntimeArgs.SourcePath, "dump-"+group, "tracking"), os.O_WRONLY|os.O_CREATE, 0664)
+	return dumpBucket(group, "fingerprints-track", path.Join(RuntimeArgs.SourcePath, "dump-"+group, "tracking"))
+}
+
+// dumpBucket writes each fingerprint in a bucket to a file as a line of JSON.
+func dumpBucket(group string, bucket string, file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE, 0664)
 	if err != nil {
 		return err
 	}
+	defer f.Close()
 	// Debug.Println("Writing fingerprints to file")
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("fingerprints-track"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if _, err = f.WriteString(string(decompressByte(v)) + "\n"); err != nil {
-				panic(err)
-			}
+	storage.ForEachFingerprint(group, bucket, false, func(k string, v Fingerprint) bool {
+		dumped, _ := v.MarshalJSON()
+		if _, err = f.WriteString(string(dumped) + "\n"); err != nil {
+			return false
 		}
-		return nil
+		return true
 	})
-	f.Close()
-	// Debug.Println("Returning")
-
-	return nil
+	return err
 }
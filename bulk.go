@@ -0,0 +1,270 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// bulk.go handles streaming imports of newline-delimited fingerprints.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// bulkBatchSize is the number of fingerprints written per database transaction
+const bulkBatchSize = 500
+
+// bulkMaxLineSize is the longest line of a bulk import, in bytes
+const bulkMaxLineSize = 1 << 20
+
+// bulkMaxSize is the most (uncompressed) bytes a bulk import can contain
+const bulkMaxSize = 256 << 20
+
+// BulkLineError describes a line of a bulk import that could not be inserted
+type BulkLineError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// BulkResult summarizes a bulk import
+type BulkResult struct {
+	Lines      int             `json:"lines"`
+	Inserted   int             `json:"inserted"`
+	Duplicates int             `json:"duplicates"`
+	Groups     []string        `json:"groups"`
+	Errors     []BulkLineError `json:"errors"`
+}
+
+// bulkLearnPOST usage: curl -X POST --data-binary @scans.ndjson "http://localhost:8003/learn/bulk?group=X"
+func bulkLearnPOST(c *gin.Context) {
+	bulkFingerprintPOST(c, "fingerprints")
+}
+
+// bulkTrackPOST usage: curl -X POST --data-binary @scans.ndjson.gz "http://localhost:8003/track/bulk?group=X"
+// Both also take UJIIndoorLoc-style CSV with format=csv, see dataset.go.
+func bulkTrackPOST(c *gin.Context) {
+	bulkFingerprintPOST(c, "fingerprints-track")
+}
+
+func bulkFingerprintPOST(c *gin.Context, database string) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "POST")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "")))
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, bulkMaxSize)
+	var result BulkResult
+	var err error
+	if c.DefaultQuery("format", "ndjson") == "csv" {
+		result, err = importUJICSV(c.Request.Body, group, database)
+	} else {
+		result, err = bulkImport(c.Request.Body, group, database)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false, "result": result})
+		return
+	}
+	message := "Inserted " + strconv.Itoa(result.Inserted) + " of " + strconv.Itoa(result.Lines) + " fingerprints"
+	if result.Duplicates > 0 {
+		message += ", " + strconv.Itoa(result.Duplicates) + " were already inserted"
+	}
+	if len(result.Errors) > 0 {
+		message += ", " + strconv.Itoa(len(result.Errors)) + " lines had errors"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "success": len(result.Errors) == 0, "result": result})
+}
+
+// bulkImport reads newline-delimited Fingerprint JSON (optionally gzipped) from r
+// and inserts it into database. The group is used for any line that does not specify one.
+// Learned groups are recalculated once at the end, or when the import stops early.
+func bulkImport(r io.Reader, group string, database string) (BulkResult, error) {
+	inserter := newBulkInserter(database)
+	defer inserter.recalculate()
+	err := scanBulkLines(r, func(lineNum int, line []byte) error {
+		fingerprint, lineErr := parseBulkLine(line, group, database)
+		return inserter.add(lineNum, fingerprint, lineErr)
+	})
+	if err != nil {
+		return inserter.result, err
+	}
+	return inserter.finish()
+}
+
+// scanBulkLines calls fn with the number and contents of each non-empty line
+// read from r, optionally gzipped, until fn returns an error. It fails on a
+// line longer than bulkMaxLineSize or input larger than bulkMaxSize.
+func scanBulkLines(r io.Reader, fn func(lineNum int, line []byte) error) error {
+	reader, err := bulkReader(r)
+	if err != nil {
+		return err
+	}
+	scanner := bufio.NewScanner(&bulkLimitReader{r: reader, remaining: bulkMaxSize})
+	scanner.Buffer(make([]byte, 64*1024), bulkMaxLineSize)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) > 0 {
+			if err = fn(lineNum, line); err != nil {
+				return err
+			}
+		}
+	}
+	if err = scanner.Err(); err == bufio.ErrTooLong {
+		return fmt.Errorf("line %d is longer than %d bytes", lineNum+1, bulkMaxLineSize)
+	}
+	return err
+}
+
+// bulkInserter inserts the fingerprints of a bulk import in batches per group
+type bulkInserter struct {
+	database string
+	result   BulkResult
+	batches  map[string][]Fingerprint
+	flushed  map[string]bool // groups with committed batches
+}
+
+func newBulkInserter(database string) *bulkInserter {
+	return &bulkInserter{
+		database: database,
+		result:   BulkResult{Groups: []string{}, Errors: []BulkLineError{}},
+		batches:  make(map[string][]Fingerprint),
+		flushed:  make(map[string]bool),
+	}
+}
+
+// add counts a line of the import, and inserts its fingerprint unless it could not be parsed.
+func (b *bulkInserter) add(lineNum int, fingerprint Fingerprint, lineErr error) error {
+	b.result.Lines++
+	if lineErr != nil {
+		b.result.Errors = append(b.result.Errors, BulkLineError{Line: lineNum, Message: lineErr.Error()})
+		return nil
+	}
+	if !stringInSlice(fingerprint.Group, b.result.Groups) {
+		b.result.Groups = append(b.result.Groups, fingerprint.Group)
+	}
+	b.batches[fingerprint.Group] = append(b.batches[fingerprint.Group], fingerprint)
+	if len(b.batches[fingerprint.Group]) >= bulkBatchSize {
+		return b.flush(fingerprint.Group)
+	}
+	return nil
+}
+
+func (b *bulkInserter) flush(group string) error {
+	if len(b.batches[group]) == 0 {
+		return nil
+	}
+	inserted, err := putFingerprintsIntoDatabase(group, b.database, b.batches[group])
+	if err == nil {
+		b.result.Inserted += inserted
+		b.result.Duplicates += len(b.batches[group]) - inserted
+		b.flushed[group] = true
+	}
+	b.batches[group] = b.batches[group][:0]
+	return err
+}
+
+// finish inserts what is left.
+func (b *bulkInserter) finish() (BulkResult, error) {
+	for g := range b.batches {
+		if err := b.flush(g); err != nil {
+			return b.result, err
+		}
+	}
+	return b.result, nil
+}
+
+// recalculate recalculates the learned groups that batches were committed to.
+// It is deferred by every import, so that a group is not left with stale
+// priors when the import fails after some of its batches were committed.
+func (b *bulkInserter) recalculate() {
+	for _, g := range b.result.Groups {
+		if b.database == "fingerprints" && b.flushed[g] {
+			Debug.Println("Bulk import finished, calculating priors for " + g)
+			setLearningCache(g, false)
+			recalculateGroup(g)
+		}
+	}
+	go resetCache("userCache")
+	go resetCache("userPositionCache")
+}
+
+// bulkLimitReader fails once more than remaining bytes are read, so that an
+// oversized (or highly compressed) import is rejected instead of truncated.
+type bulkLimitReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (l *bulkLimitReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		return 0, fmt.Errorf("bulk import is larger than %d bytes", bulkMaxSize)
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.r.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// bulkReader transparently decompresses gzipped input.
+func bulkReader(r io.Reader) (*bufio.Reader, error) {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(2)
+	if len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, err
+		}
+		return bufio.NewReader(gz), nil
+	}
+	return buffered, nil
+}
+
+// parseBulkLine decodes and validates a single line of a bulk import.
+func parseBulkLine(line []byte, group string, database string) (Fingerprint, error) {
+	var fingerprint Fingerprint
+	if err := fingerprint.UnmarshalJSON(line); err != nil {
+		return fingerprint, fmt.Errorf("could not parse JSON: %s", err.Error())
+	}
+	return fingerprint, validateBulkFingerprint(&fingerprint, group, database)
+}
+
+// validateBulkFingerprint cleans a fingerprint of a bulk import and checks that it can be inserted into database.
+func validateBulkFingerprint(fingerprint *Fingerprint, group string, database string) error {
+	cleanFingerprint(fingerprint)
+	if len(fingerprint.Group) == 0 {
+		fingerprint.Group = group
+	}
+	if len(fingerprint.Group) == 0 {
+		return fmt.Errorf("need to define group name")
+	}
+	if len(group) > 0 && fingerprint.Group != group {
+		return fmt.Errorf("group %s does not match %s", fingerprint.Group, group)
+	}
+	if len(fingerprint.WifiFingerprint) == 0 {
+		return fmt.Errorf("no fingerprints found")
+	}
+	if database == "fingerprints" && len(fingerprint.Location) == 0 {
+		return fmt.Errorf("need to define location to learn")
+	}
+	if database == "fingerprints-track" && len(fingerprint.Username) == 0 {
+		return fmt.Errorf("need to define username to track")
+	}
+	if fingerprint.Timestamp < 0 {
+		return fmt.Errorf("invalid timestamp %d", fingerprint.Timestamp)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var bulkTest = `{"username": "zack", "location": "kitchen", "timestamp": 1439596533831000000, "wifi-fingerprint": [{"rssi": -45, "mac": "80:37:73:ba:f7:d8"}, {"rssi": -58, "mac": "80:37:73:ba:f7:dc"}]}
+{"username": "zack", "location": "bedroom", "timestamp": 1439596533832000000, "wifi-fingerprint": [{"rssi": -75, "mac": "80:37:73:ba:f7:d8"}, {"rssi": -48, "mac": "80:37:73:ba:f7:dc"}]}
+
+{"username": "zack", "location": "bedroom", "wifi-fingerprint": []}
+not json
+`
+
+func TestBulkImport(t *testing.T) {
+	os.Remove(path.Join(RuntimeArgs.SourcePath, "bulktest.db"))
+	defer os.Remove(path.Join(RuntimeArgs.SourcePath, "bulktest.db"))
+
+	result, err := bulkImport(strings.NewReader(bulkTest), "bulktest", "fingerprints")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, result.Lines)
+	assert.Equal(t, 2, result.Inserted)
+	assert.Equal(t, []string{"bulktest"}, result.Groups)
+	assert.Equal(t, 2, len(result.Errors))
+	assert.Equal(t, 4, result.Errors[0].Line)
+	assert.Equal(t, 5, result.Errors[1].Line)
+	locations := getUniqueLocations("bulktest")
+	sort.Strings(locations)
+	assert.Equal(t, []string{"bedroom", "kitchen"}, locations)
+}
+
+func TestBulkTrackPOSTGzip(t *testing.T) {
+	os.Remove(path.Join(RuntimeArgs.SourcePath, "bulktest.db"))
+	defer os.Remove(path.Join(RuntimeArgs.SourcePath, "bulktest.db"))
+
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	gz.Write([]byte(strings.Split(bulkTest, "\n")[0] + "\n"))
+	gz.Close()
+
+	router := gin.New()
+	router.POST("/foo", bulkTrackPOST)
+
+	req, _ := http.NewRequest("POST", "/foo?group=bulktest", &body)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Equal(t, true, strings.Contains(resp.Body.String(), `"message":"Inserted 1 of 1 fingerprints","result":`))
+	assert.Equal(t, []string{"zack"}, getUsers("bulktest"))
+}
+
+func TestBulkImportLimits(t *testing.T) {
+	os.Remove(path.Join(RuntimeArgs.SourcePath, "bulktest.db"))
+	defer os.Remove(path.Join(RuntimeArgs.SourcePath, "bulktest.db"))
+
+	long := strings.Split(bulkTest, "\n")[0] + "\n" + strings.Repeat(" ", bulkMaxLineSize) + "\n"
+	_, err := bulkImport(strings.NewReader(long), "bulktest", "fingerprints")
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line 2")
+
+	_, err = bulkImport(&bulkLimitReader{r: strings.NewReader(bulkTest), remaining: 10}, "bulktest", "fingerprints")
+	assert.NotNil(t, err)
+	// the batches committed before the import failed are still calculated
+	var lines bytes.Buffer
+	for i := 0; i < bulkBatchSize; i++ {
+		location := []string{"kitchen", "bedroom"}[i%2]
+		lines.WriteString(`{"location": "` + location + `", "timestamp": ` + strconv.Itoa(1439596533831000000+i) + `, "wifi-fingerprint": [{"rssi": -` + strconv.Itoa(40+i%2*30) + `, "mac": "80:37:73:ba:f7:d8"}]}` + "\n")
+	}
+	lines.WriteString(strings.Repeat(" ", bulkMaxLineSize) + "\n")
+	resetCache("psCache")
+	result, err := bulkImport(&lines, "bulktest", "fingerprints")
+	assert.NotNil(t, err)
+	assert.Equal(t, bulkBatchSize, result.Inserted)
+	ps, err := openParameters("bulktest")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(ps.UniqueLocs))
+}
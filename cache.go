@@ -27,6 +27,24 @@ var userPositionCache = struct {
 	m map[string]UserPositionJSON
 }{m: make(map[string]UserPositionJSON)}
 
+var userPriorsCache = struct {
+	sync.RWMutex
+	m map[string]UserPriors
+}{m: make(map[string]UserPriors)}
+
+var locationGraphCache = struct {
+	sync.RWMutex
+	m map[string]LocationGraph
+}{m: make(map[string]LocationGraph)}
+
+// lastLocationCache is where each user of each group was last tracked, keyed by
+// group/user, to penalise jumps (see graph.go). It is cleared with the other
+// caches, which only forgets jumps across the clearing.
+var lastLocationCache = struct {
+	sync.RWMutex
+	m map[string]lastLocation
+}{m: make(map[string]lastLocation)}
+
 var isLearning = struct {
 	sync.RWMutex
 	m map[string]bool
@@ -50,6 +68,10 @@ func clearCache() {
 		go resetCache("isLearning")
 		go resetCache("psCache")
 		go resetCache("userPositionCache")
+		go resetCache("userPriorsCache")
+		go resetCache("locationGraphCache")
+		go resetCache("lastLocationCache")
+		go purgeSessions(time.Now())
 		time.Sleep(time.Second * 600)
 	}
 }
@@ -67,6 +89,18 @@ func resetCache(cache string) {
 		psCache.Lock()
 		psCache.m = make(map[string]FullParameters)
 		psCache.Unlock()
+	} else if cache == "userPriorsCache" {
+		userPriorsCache.Lock()
+		userPriorsCache.m = make(map[string]UserPriors)
+		userPriorsCache.Unlock()
+	} else if cache == "locationGraphCache" {
+		locationGraphCache.Lock()
+		locationGraphCache.m = make(map[string]LocationGraph)
+		locationGraphCache.Unlock()
+	} else if cache == "lastLocationCache" {
+		lastLocationCache.Lock()
+		lastLocationCache.m = make(map[string]lastLocation)
+		lastLocationCache.Unlock()
 	} else if cache == "isLearning" {
 		isLearning.Lock()
 		isLearning.m = make(map[string]bool)
@@ -143,3 +177,54 @@ func setUserPositionCache(group string, p UserPositionJSON) {
 	userPositionCache.Unlock()
 	return
 }
+
+func getUserPriorsCache(group string) (UserPriors, bool) {
+	userPriorsCache.RLock()
+	cached, ok := userPriorsCache.m[group]
+	userPriorsCache.RUnlock()
+	return cached, ok
+}
+
+func setUserPriorsCache(group string, priors UserPriors) {
+	userPriorsCache.Lock()
+	userPriorsCache.m[group] = priors
+	userPriorsCache.Unlock()
+}
+
+func deleteUserPriorsCache(group string) {
+	userPriorsCache.Lock()
+	delete(userPriorsCache.m, group)
+	userPriorsCache.Unlock()
+}
+
+func getLocationGraphCache(group string) (LocationGraph, bool) {
+	locationGraphCache.RLock()
+	cached, ok := locationGraphCache.m[group]
+	locationGraphCache.RUnlock()
+	return cached, ok
+}
+
+func setLocationGraphCache(group string, graph LocationGraph) {
+	locationGraphCache.Lock()
+	locationGraphCache.m[group] = graph
+	locationGraphCache.Unlock()
+}
+
+func deleteLocationGraphCache(group string) {
+	locationGraphCache.Lock()
+	delete(locationGraphCache.m, group)
+	locationGraphCache.Unlock()
+}
+
+func getLastLocationCache(group string, user string) (lastLocation, bool) {
+	lastLocationCache.RLock()
+	cached, ok := lastLocationCache.m[group+"/"+user]
+	lastLocationCache.RUnlock()
+	return cached, ok
+}
+
+func setLastLocationCache(group string, user string, last lastLocation) {
+	lastLocationCache.Lock()
+	lastLocationCache.m[group+"/"+user] = last
+	lastLocationCache.Unlock()
+}
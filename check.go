@@ -0,0 +1,237 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// check.go contains checking the stored data of groups for problems, and repairing them.
+
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// knownResources are the resources a group can have, registered by the files
+// that store them. A key ending in "/" stands for every key with that prefix.
+var knownResources = make(map[string]bool)
+
+// registerResources records the keys of the resources a file stores for a
+// group, so that checkGroup does not report them as orphaned. It is called
+// from init.
+func registerResources(keys ...string) {
+	for _, key := range keys {
+		knownResources[key] = true
+	}
+}
+
+func isKnownResource(key string) bool {
+	if knownResources[key] {
+		return true
+	}
+	for known := range knownResources {
+		if strings.HasSuffix(known, "/") && strings.HasPrefix(key, known) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckReport lists the problems found in a group
+type CheckReport struct {
+	Group             string              `json:"group"`
+	Records           map[string]int      `json:"records"`
+	BadRecords        map[string][]string `json:"bad_records"`
+	OrphanedIDs       map[string][]string `json:"orphaned_ids"`
+	MissingBuckets    []string            `json:"missing_buckets"`
+	OrphanedResources []string            `json:"orphaned_resources"`
+	Errors            []string            `json:"errors"`
+	Repaired          bool                `json:"repaired"`
+}
+
+// ok returns whether nothing was found that needs to be repaired.
+func (r CheckReport) ok() bool {
+	for _, keys := range r.BadRecords {
+		if len(keys) > 0 {
+			return false
+		}
+	}
+	for _, ids := range r.OrphanedIDs {
+		if len(ids) > 0 {
+			return false
+		}
+	}
+	return len(r.OrphanedResources) == 0 && len(r.Errors) == 0
+}
+
+func (r CheckReport) String() string {
+	problems := []string{}
+	for bucket, keys := range r.BadRecords {
+		if len(keys) > 0 {
+			problems = append(problems, strconv.Itoa(len(keys))+" bad records in "+bucket)
+		}
+	}
+	for bucket, ids := range r.OrphanedIDs {
+		if len(ids) > 0 {
+			problems = append(problems, strconv.Itoa(len(ids))+" orphaned IDs in "+bucket)
+		}
+	}
+	if len(r.MissingBuckets) > 0 {
+		problems = append(problems, "missing "+strings.Join(r.MissingBuckets, ", "))
+	}
+	if len(r.OrphanedResources) > 0 {
+		problems = append(problems, "orphaned "+strings.Join(r.OrphanedResources, ", "))
+	}
+	problems = append(problems, r.Errors...)
+	sort.Strings(problems)
+	if len(problems) == 0 {
+		return r.Group + ": ok"
+	}
+	if r.Repaired {
+		return r.Group + ": repaired " + strings.Join(problems, "; ")
+	}
+	return r.Group + ": " + strings.Join(problems, "; ")
+}
+
+// checkGroup verifies that every stored fingerprint of group decodes and that
+// its resources are consistent. If repair is set, bad records, orphaned IDs
+// and orphaned resources are dropped and the parameters are regenerated.
+func checkGroup(group string, repair bool) CheckReport {
+	report := CheckReport{
+		Group:             group,
+		Records:           make(map[string]int),
+		BadRecords:        make(map[string][]string),
+		OrphanedIDs:       make(map[string][]string),
+		MissingBuckets:    []string{},
+		OrphanedResources: []string{},
+		Errors:            []string{},
+	}
+	resources, err := storage.ListResources(group)
+	if err != nil {
+		report.Errors = append(report.Errors, err.Error())
+		return report
+	}
+	if len(resources) == 0 {
+		report.MissingBuckets = append(report.MissingBuckets, "resources")
+	}
+	codec := newFingerprintCodec(resources["macDictionary"])
+
+	for _, bucket := range []string{"fingerprints", "fingerprints-track"} {
+		keys := make(map[string]bool)
+		report.BadRecords[bucket] = []string{}
+		err := storage.ForEachRecord(group, bucket, func(k string, v []byte) bool {
+			keys[k] = true
+			report.Records[bucket]++
+			fingerprint, err := codec.decode(v)
+			if err != nil || len(fingerprint.WifiFingerprint) == 0 {
+				report.BadRecords[bucket] = append(report.BadRecords[bucket], k)
+			}
+			return true
+		})
+		if err != nil {
+			report.MissingBuckets = append(report.MissingBuckets, bucket)
+			continue
+		}
+
+		report.OrphanedIDs[bucket] = []string{}
+		storage.ForEachRecord(group, bucket+"-ids", func(id string, k []byte) bool {
+			if !keys[string(k)] {
+				report.OrphanedIDs[bucket] = append(report.OrphanedIDs[bucket], id)
+			}
+			return true
+		})
+	}
+
+	for key, v := range resources {
+		if !isKnownResource(key) {
+			report.OrphanedResources = append(report.OrphanedResources, key)
+		} else if key == "fullParameters" {
+			jsonByte, err := parametersSchema.decode(v)
+			var ps FullParameters
+			if err == nil {
+				err = ps.UnmarshalJSON(jsonByte)
+			}
+			if err != nil {
+				report.Errors = append(report.Errors, "fullParameters do not decode")
+			}
+		}
+	}
+	if report.Records["fingerprints"] == 0 {
+		// calculated from learned fingerprints that are no longer there
+		for _, key := range []string{"fullParameters", "svmData", "macsFromID", "locationsFromID"} {
+			if _, ok := resources[key]; ok {
+				report.OrphanedResources = append(report.OrphanedResources, key)
+			}
+		}
+	}
+	sort.Strings(report.OrphanedResources)
+
+	if repair && !report.ok() {
+		for bucket, keys := range report.BadRecords {
+			if err := storage.DeleteFingerprints(group, bucket, keys); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+		for bucket, ids := range report.OrphanedIDs {
+			if err := storage.DeleteFingerprints(group, bucket+"-ids", ids); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+		if len(report.OrphanedResources) > 0 {
+			if err := storage.DeleteResources(group, report.OrphanedResources); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+			}
+		}
+		if report.Records["fingerprints"] > len(report.BadRecords["fingerprints"]) {
+			go resetCache("psCache")
+			recalculateGroup(group)
+		}
+		go resetCache("userCache")
+		report.Repaired = true
+	}
+	return report
+}
+
+// checkAllGroups checks every group, used by the -check flag.
+func checkAllGroups(repair bool) ([]CheckReport, error) {
+	groups, err := storage.ListGroups()
+	if err != nil {
+		return nil, err
+	}
+	reports := []CheckReport{}
+	for _, group := range groups {
+		reports = append(reports, checkGroup(group, repair))
+	}
+	return reports, nil
+}
+
+// getCheck usage: curl "http://localhost:8003/check?group=X&repair=true"
+func getCheck(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	repair := c.DefaultQuery("repair", "false") == "true"
+	if group == "noneasdf" {
+		reports, err := checkAllGroups(repair)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "Checked " + strconv.Itoa(len(reports)) + " groups", "success": true, "reports": reports})
+		return
+	}
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "Group does not exist", "success": false})
+		return
+	}
+	report := checkGroup(group, repair)
+	c.JSON(http.StatusOK, gin.H{"message": report.String(), "success": true, "report": report})
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckGroup(t *testing.T) {
+	defer useMemoryStore()()
+
+	storage.PutFingerprints("checktest", "fingerprints", []Fingerprint{
+		{Group: "checktest", Location: "kitchen", Timestamp: 1, WifiFingerprint: []Router{{Mac: "aa", Rssi: -40}}},
+		{Group: "checktest", Location: "den", Timestamp: 2, ID: "den1", WifiFingerprint: []Router{{Mac: "aa", Rssi: -70}}},
+	})
+	storage.PutRecords("checktest", "fingerprints", map[string][]byte{"3": []byte("not a fingerprint")})
+	storage.DeleteFingerprints("checktest", "fingerprints", []string{"2"})
+	storage.PutResources("checktest", map[string][]byte{"oldResource": []byte("?")})
+
+	report := checkGroup("checktest", false)
+	assert.False(t, report.ok())
+	assert.Equal(t, 2, report.Records["fingerprints"])
+	assert.Equal(t, []string{"3"}, report.BadRecords["fingerprints"])
+	assert.Equal(t, []string{"den1"}, report.OrphanedIDs["fingerprints"])
+	assert.Equal(t, []string{"fingerprints-track"}, report.MissingBuckets)
+	assert.Equal(t, []string{"oldResource"}, report.OrphanedResources)
+
+	report = checkGroup("checktest", true)
+	assert.True(t, report.Repaired)
+	report = checkGroup("checktest", false)
+	assert.Equal(t, 1, report.Records["fingerprints"])
+	assert.Equal(t, 0, len(report.BadRecords["fingerprints"]))
+	assert.Equal(t, 0, len(report.OrphanedIDs["fingerprints"]))
+	assert.Equal(t, 0, len(report.OrphanedResources))
+
+	assert.True(t, isKnownResource("userPriors"))
+	assert.True(t, isKnownResource("parameters/1"))
+	assert.False(t, isKnownResource("parameters"))
+}
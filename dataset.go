@@ -0,0 +1,226 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// dataset.go converts fingerprints from and to UJIIndoorLoc-style wide CSV,
+// which has a column of signal strengths per access point (100 when it was not
+// seen) followed by label columns such as BUILDINGID, FLOOR, SPACEID, USERID and TIMESTAMP.
+
+package main
+
+import (
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ujiMissing is the signal strength of access points that were not seen
+const ujiMissing = 100
+
+// ujiLabels are the columns that are not access points. LOCATION is not part of
+// UJIIndoorLoc, and is used instead of BUILDINGID, FLOOR and SPACEID when present.
+var ujiLabels = []string{"LOCATION", "BUILDINGID", "FLOOR", "SPACEID", "USERID", "TIMESTAMP",
+	"LONGITUDE", "LATITUDE", "RELATIVEPOSITION", "PHONEID"}
+
+// ujiLocationFormat matches the locations made from BUILDINGID, FLOOR and SPACEID
+var ujiLocationFormat = regexp.MustCompile(`^building (\S+) floor (\S+)(?: space (\S+))?$`)
+
+// ujiLocation names the location of a building, floor and space, leaving out
+// what is not known. UJIIndoorLoc uses space 0 when it was not recorded.
+func ujiLocation(building string, floor string, space string) string {
+	parts := []string{}
+	if len(building) > 0 {
+		parts = append(parts, "building "+building)
+	}
+	if len(floor) > 0 {
+		parts = append(parts, "floor "+floor)
+	}
+	if len(space) > 0 && space != "0" {
+		parts = append(parts, "space "+space)
+	}
+	return strings.Join(parts, " ")
+}
+
+// ujiFingerprint converts a row of the CSV to a fingerprint.
+func ujiFingerprint(header []string, row []string) (Fingerprint, error) {
+	fingerprint := Fingerprint{WifiFingerprint: []Router{}}
+	labels := make(map[string]string)
+	for i, name := range header {
+		value := strings.TrimSpace(row[i])
+		if stringInSlice(name, ujiLabels) {
+			labels[name] = value
+			continue
+		}
+		rssi, err := strconv.Atoi(value)
+		if err != nil {
+			return fingerprint, fmt.Errorf("%s is not a signal strength for %s", value, name)
+		}
+		if rssi != ujiMissing {
+			fingerprint.WifiFingerprint = append(fingerprint.WifiFingerprint, Router{Mac: strings.ToLower(name), Rssi: rssi})
+		}
+	}
+	fingerprint.Location = labels["LOCATION"]
+	if len(fingerprint.Location) == 0 {
+		fingerprint.Location = ujiLocation(labels["BUILDINGID"], labels["FLOOR"], labels["SPACEID"])
+	}
+	fingerprint.Username = labels["USERID"]
+	if len(labels["TIMESTAMP"]) > 0 {
+		seconds, err := strconv.ParseInt(labels["TIMESTAMP"], 10, 64)
+		if err != nil {
+			return fingerprint, fmt.Errorf("invalid timestamp %s", labels["TIMESTAMP"])
+		}
+		fingerprint.Timestamp = seconds * 1000000000
+	}
+	return fingerprint, nil
+}
+
+// importUJICSV reads UJIIndoorLoc-style CSV (optionally gzipped) from r and
+// inserts it into database of group like a bulk import.
+func importUJICSV(r io.Reader, group string, database string) (BulkResult, error) {
+	inserter := newBulkInserter(database)
+	defer inserter.recalculate()
+	reader, err := bulkReader(r)
+	if err != nil {
+		return inserter.result, err
+	}
+	rows := csv.NewReader(reader)
+	header, err := rows.Read()
+	if err != nil {
+		return inserter.result, fmt.Errorf("could not read the header: %s", err.Error())
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+		if stringInSlice(strings.ToUpper(header[i]), ujiLabels) {
+			header[i] = strings.ToUpper(header[i])
+		}
+	}
+
+	for lineNum := 2; ; lineNum++ {
+		row, readErr := rows.Read()
+		if readErr == io.EOF {
+			break
+		}
+		var fingerprint Fingerprint
+		lineErr := readErr
+		if _, ok := readErr.(*csv.ParseError); !ok && readErr != nil {
+			return inserter.result, readErr
+		} else if readErr == nil {
+			fingerprint, lineErr = ujiFingerprint(header, row)
+			if lineErr == nil {
+				lineErr = validateBulkFingerprint(&fingerprint, group, database)
+			}
+		}
+		if err = inserter.add(lineNum, fingerprint, lineErr); err != nil {
+			return inserter.result, err
+		}
+	}
+	return inserter.finish()
+}
+
+// exportUJICSV writes the fingerprints of a bucket of group to w as
+// UJIIndoorLoc-style CSV, with a column per MAC.
+func exportUJICSV(w io.Writer, group string, bucket string) (int, error) {
+	fingerprints := []Fingerprint{}
+	macs := []string{}
+	seen := make(map[string]bool)
+	err := storage.ForEachFingerprint(group, bucket, false, func(k string, v Fingerprint) bool {
+		for _, router := range v.WifiFingerprint {
+			if !seen[router.Mac] {
+				seen[router.Mac] = true
+				macs = append(macs, router.Mac)
+			}
+		}
+		fingerprints = append(fingerprints, v)
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(macs)
+
+	rows := csv.NewWriter(w)
+	rows.Write(append(append([]string{}, macs...), "LOCATION", "BUILDINGID", "FLOOR", "SPACEID", "USERID", "TIMESTAMP"))
+	for _, fingerprint := range fingerprints {
+		rssis := make(map[string]int)
+		for _, router := range fingerprint.WifiFingerprint {
+			rssis[router.Mac] = router.Rssi
+		}
+		row := make([]string, len(macs), len(macs)+6)
+		for i, mac := range macs {
+			row[i] = strconv.Itoa(ujiMissing)
+			if rssi, ok := rssis[mac]; ok {
+				row[i] = strconv.Itoa(rssi)
+			}
+		}
+		building, floor, space := "", "", ""
+		if match := ujiLocationFormat.FindStringSubmatch(fingerprint.Location); match != nil {
+			building, floor, space = match[1], match[2], match[3]
+		}
+		row = append(row, fingerprint.Location, building, floor, space, fingerprint.Username, strconv.FormatInt(fingerprint.Timestamp/1000000000, 10))
+		if err = rows.Write(row); err != nil {
+			return 0, err
+		}
+	}
+	rows.Flush()
+	return len(fingerprints), rows.Error()
+}
+
+// isCSVFile returns whether a file is to be read or written as CSV rather than NDJSON.
+func isCSVFile(file string) bool {
+	return strings.HasSuffix(file, ".csv") || strings.HasSuffix(file, ".csv.gz")
+}
+
+// importDataset learns the fingerprints of a CSV or NDJSON file into group, used by the -import flag.
+func importDataset(file string, group string) (BulkResult, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return BulkResult{}, err
+	}
+	defer f.Close()
+	if isCSVFile(file) {
+		return importUJICSV(f, group, "fingerprints")
+	}
+	return bulkImport(f, group, "fingerprints")
+}
+
+// exportDataset writes the learned fingerprints of group to a CSV or NDJSON
+// file, gzipped if it ends in .gz, used by the -export flag.
+func exportDataset(file string, group string) (int, error) {
+	if !groupExists(group) {
+		return 0, fmt.Errorf("group %s does not exist", group)
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var w io.Writer = f
+	if strings.HasSuffix(file, ".gz") {
+		gz := gzip.NewWriter(f)
+		defer gz.Close()
+		w = gz
+	}
+	if isCSVFile(file) {
+		return exportUJICSV(w, group, "fingerprints")
+	}
+	exported := 0
+	var writeErr error
+	err = storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v Fingerprint) bool {
+		dumped, _ := v.MarshalJSON()
+		if _, writeErr = w.Write(append(dumped, '\n')); writeErr != nil {
+			return false
+		}
+		exported++
+		return true
+	})
+	if writeErr != nil {
+		return exported, writeErr
+	}
+	return exported, err
+}
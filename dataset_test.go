@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+var ujiTest = `WAP001,WAP002,WAP003,LONGITUDE,LATITUDE,FLOOR,BUILDINGID,SPACEID,RELATIVEPOSITION,USERID,PHONEID,TIMESTAMP
+-60,100,-80,-7641.5,4864982.2,2,1,106,2,11,13,1371713733
+100,-45,-90,-7632.1,4864950.0,0,0,0,2,11,13,1371713800
+100,100,100,-7632.1,4864950.0,0,0,0,2,11,13,1371713900
+-60,x,100,-7632.1,4864950.0,0,0,0,2,11,13,1371713900
+`
+
+func TestUJICSV(t *testing.T) {
+	defer useMemoryStore()()
+
+	result, err := importUJICSV(strings.NewReader(ujiTest), "ujitest", "fingerprints")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, result.Lines)
+	assert.Equal(t, 2, result.Inserted)
+	assert.Equal(t, 2, len(result.Errors))
+	assert.Equal(t, 4, result.Errors[0].Line)
+	locations := getUniqueLocations("ujitest")
+	sort.Strings(locations)
+	assert.Equal(t, []string{"building 0 floor 0", "building 1 floor 2 space 106"}, locations)
+
+	var out bytes.Buffer
+	exported, err := exportUJICSV(&out, "ujitest", "fingerprints")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, exported)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, "wap001,wap002,wap003,LOCATION,BUILDINGID,FLOOR,SPACEID,USERID,TIMESTAMP", lines[0])
+	assert.Equal(t, "-60,100,-80,building 1 floor 2 space 106,1,2,106,11,1371713733", lines[1])
+
+	// exported data imports the same way
+	result, err = importUJICSV(&out, "ujitest2", "fingerprints")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, result.Inserted)
+	locations2 := getUniqueLocations("ujitest2")
+	sort.Strings(locations2)
+	assert.Equal(t, locations, locations2)
+}
+
+func TestBulkLearnPOSTCSV(t *testing.T) {
+	defer useMemoryStore()()
+
+	router := gin.New()
+	router.POST("/foo", bulkLearnPOST)
+
+	req, _ := http.NewRequest("POST", "/foo?group=ujitest&format=csv", strings.NewReader(strings.Join(strings.Split(ujiTest, "\n")[:3], "\n")))
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.Contains(t, resp.Body.String(), `"message":"Inserted 2 of 2 fingerprints"`)
+}
@@ -9,20 +9,12 @@
 package main
 
 import (
-	"log"
-	"os"
-	"path"
 	"strings"
 	"time"
-
-	"github.com/boltdb/bolt"
 )
 
 func groupExists(group string) bool {
-	if _, err := os.Stat(path.Join(RuntimeArgs.SourcePath, strings.ToLower(group)+".db")); os.IsNotExist(err) {
-		return false
-	}
-	return true
+	return storage.GroupExists(strings.ToLower(group))
 }
 
 func renameNetwork(group string, oldName string, newName string) {
@@ -48,6 +40,34 @@ func renameNetwork(group string, oldName string, newName string) {
 	savePersistentParameters(group, persistentPs)
 }
 
+// getFingerprintsInMemory loads the learning fingerprints of a group, and the order of their keys.
+func getFingerprintsInMemory(group string) (map[string]Fingerprint, []string, error) {
+	fingerprintsInMemory := make(map[string]Fingerprint)
+	var fingerprintsOrdering []string
+	err := storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v Fingerprint) bool {
+		fingerprintsInMemory[k] = v
+		fingerprintsOrdering = append(fingerprintsOrdering, k)
+		return true
+	})
+	return fingerprintsInMemory, fingerprintsOrdering, err
+}
+
+// updateFingerprintsWhere saves the fingerprints of a bucket that fn changed
+// (by returning true) and returns how many there were.
+func updateFingerprintsWhere(group string, bucket string, fn func(fingerprint *Fingerprint) bool) int {
+	toUpdate := make(map[string]Fingerprint)
+	storage.ForEachFingerprint(group, bucket, false, func(k string, v Fingerprint) bool {
+		if fn(&v) {
+			toUpdate[k] = v
+		}
+		return true
+	})
+	if len(toUpdate) > 0 {
+		storage.UpdateFingerprints(group, bucket, toUpdate)
+	}
+	return len(toUpdate)
+}
+
 func getUsers(group string) []string {
 	val, ok := getUserCache(group)
 	if ok {
@@ -55,26 +75,11 @@ func getUsers(group string) []string {
 	}
 
 	uniqueUsers := []string{}
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("fingerprints-track"))
-		if b == nil {
-			return nil
+	storage.ForEachFingerprint(group, "fingerprints-track", false, func(k string, v2 Fingerprint) bool {
+		if !stringInSlice(v2.Username, uniqueUsers) {
+			uniqueUsers = append(uniqueUsers, v2.Username)
 		}
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			v2 := loadFingerprint(v)
-			if !stringInSlice(v2.Username, uniqueUsers) {
-				uniqueUsers = append(uniqueUsers, v2.Username)
-			}
-		}
-		return nil
+		return true
 	})
 
 	go setUserCache(group, uniqueUsers)
@@ -84,100 +89,54 @@ func getUsers(group string) []string {
 func getUniqueMacs(group string) []string {
 	defer timeTrack(time.Now(), "getUniqueMacs")
 	uniqueMacs := []string{}
-
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("fingerprints"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			v2 := loadFingerprint(v)
-			for _, router := range v2.WifiFingerprint {
-				if !stringInSlice(router.Mac, uniqueMacs) {
-					uniqueMacs = append(uniqueMacs, router.Mac)
-				}
+	storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v2 Fingerprint) bool {
+		for _, router := range v2.WifiFingerprint {
+			if !stringInSlice(router.Mac, uniqueMacs) {
+				uniqueMacs = append(uniqueMacs, router.Mac)
 			}
 		}
-		return nil
+		return true
 	})
 	return uniqueMacs
 }
 
 func getUniqueLocations(group string) (uniqueLocs []string) {
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-	db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("fingerprints"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			v2 := loadFingerprint(v)
-			if !stringInSlice(v2.Location, uniqueLocs) {
-				uniqueLocs = append(uniqueLocs, v2.Location)
-			}
+	storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v2 Fingerprint) bool {
+		if !stringInSlice(v2.Location, uniqueLocs) {
+			uniqueLocs = append(uniqueLocs, v2.Location)
 		}
-		return nil
+		return true
 	})
 	return uniqueLocs
 }
 
 func getMacCount(group string) (macCount map[string]int) {
 	macCount = make(map[string]int)
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-	db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("fingerprints"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			v2 := loadFingerprint(v)
-			for _, router := range v2.WifiFingerprint {
-				if _, ok := macCount[router.Mac]; !ok {
-					macCount[router.Mac] = 0
-				}
-				macCount[router.Mac]++
+	storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v2 Fingerprint) bool {
+		for _, router := range v2.WifiFingerprint {
+			if _, ok := macCount[router.Mac]; !ok {
+				macCount[router.Mac] = 0
 			}
+			macCount[router.Mac]++
 		}
-		return nil
+		return true
 	})
 	return
 }
 
 func getMacCountByLoc(group string) (macCountByLoc map[string]map[string]int) {
 	macCountByLoc = make(map[string]map[string]int)
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-	db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("fingerprints"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			v2 := loadFingerprint(v)
-			if _, ok := macCountByLoc[v2.Location]; !ok {
-				macCountByLoc[v2.Location] = make(map[string]int)
-			}
-			for _, router := range v2.WifiFingerprint {
-				if _, ok := macCountByLoc[v2.Location][router.Mac]; !ok {
-					macCountByLoc[v2.Location][router.Mac] = 0
-				}
-				macCountByLoc[v2.Location][router.Mac]++
+	storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v2 Fingerprint) bool {
+		if _, ok := macCountByLoc[v2.Location]; !ok {
+			macCountByLoc[v2.Location] = make(map[string]int)
+		}
+		for _, router := range v2.WifiFingerprint {
+			if _, ok := macCountByLoc[v2.Location][router.Mac]; !ok {
+				macCountByLoc[v2.Location][router.Mac] = 0
 			}
+			macCountByLoc[v2.Location][router.Mac]++
 		}
-		return nil
+		return true
 	})
 	return
 }
@@ -0,0 +1,331 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// discover.go clusters the tracked fingerprints that are far from every
+// learned location, to suggest places people spend time in that were never learned.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// discoverMissingRssi is the signal used for access points a fingerprint did not see
+	discoverMissingRssi = -100
+	// discoverMinDistance is the RSSI distance in dB from every learned location that makes a fingerprint not fit
+	discoverMinDistance = 10
+	// discoverClusterRadius is the RSSI distance in dB within which a fingerprint joins a place
+	discoverClusterRadius = 10
+	// discoverMinSamples is the number of fingerprints a place needs to be suggested
+	discoverMinSamples = 10
+	// discoverVisitGap is the longest time between fingerprints of a user in one visit
+	discoverVisitGap = 10 * time.Minute
+	// discoverMaxFingerprints is the most tracked fingerprints that are looked at
+	discoverMaxFingerprints = 5000
+)
+
+// DiscoveredPlace is a cluster of tracked fingerprints that fit no learned location
+type DiscoveredPlace struct {
+	ID        string             `json:"id"` // key of its first fingerprint
+	Samples   int                `json:"samples"`
+	Users     []string           `json:"users"`
+	Visits    int                `json:"visits"`
+	MeanVisit float64            `json:"mean_visit"` // seconds
+	Hours     []int              `json:"hours"`      // samples per hour of the day
+	FirstSeen time.Time          `json:"first_seen"`
+	LastSeen  time.Time          `json:"last_seen"`
+	Nearest   string             `json:"nearest"` // closest learned location
+	Distance  float64            `json:"distance"`
+	Signature map[string]float64 `json:"signature"` // mean RSSI of the access points seen in most samples
+	keys      []string
+	centroid  rssiCentroid
+}
+
+// rssiCentroid is the mean signal of a set of fingerprints
+type rssiCentroid struct {
+	n      int
+	sums   map[string]float64
+	counts map[string]int
+}
+
+func newRssiCentroid() rssiCentroid {
+	return rssiCentroid{sums: make(map[string]float64), counts: make(map[string]int)}
+}
+
+func (c *rssiCentroid) add(fingerprint Fingerprint) {
+	c.n++
+	for _, router := range fingerprint.WifiFingerprint {
+		c.sums[router.Mac] += float64(router.Rssi)
+		c.counts[router.Mac]++
+	}
+}
+
+// mean returns the mean signal of an access point, counting fingerprints that did not see it as discoverMissingRssi.
+func (c rssiCentroid) mean(mac string) float64 {
+	return (c.sums[mac] + float64(c.n-c.counts[mac])*discoverMissingRssi) / float64(c.n)
+}
+
+// distance is the root mean square difference in dB between a fingerprint and the centroid.
+func (c rssiCentroid) distance(fingerprint Fingerprint) float64 {
+	seen := make(map[string]bool)
+	total, macs := float64(0), 0
+	for _, router := range fingerprint.WifiFingerprint {
+		seen[router.Mac] = true
+		total += math.Pow(float64(router.Rssi)-c.mean(router.Mac), 2)
+		macs++
+	}
+	for mac := range c.counts {
+		if !seen[mac] {
+			total += math.Pow(discoverMissingRssi-c.mean(mac), 2)
+			macs++
+		}
+	}
+	if macs == 0 {
+		return 0
+	}
+	return math.Sqrt(total / float64(macs))
+}
+
+// discoverPlaces clusters the tracked fingerprints of the last days that are
+// far from every learned location, oldest first so that places keep their ids
+// as more is tracked, and returns the places with enough samples, largest first.
+func discoverPlaces(group string, days float64) ([]DiscoveredPlace, error) {
+	locations := make(map[string]*rssiCentroid)
+	err := storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v Fingerprint) bool {
+		if _, ok := locations[v.Location]; !ok {
+			c := newRssiCentroid()
+			locations[v.Location] = &c
+		}
+		locations[v.Location].add(v)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	since := time.Now().Add(-time.Duration(days * float64(24*time.Hour))).UnixNano()
+	keys := []string{}
+	tracks := make(map[string]Fingerprint)
+	storage.ForEachFingerprint(group, "fingerprints-track", true, func(k string, v Fingerprint) bool {
+		if v.Timestamp < since || len(keys) >= discoverMaxFingerprints {
+			return false
+		}
+		if len(v.WifiFingerprint) > 0 {
+			keys = append(keys, k)
+			tracks[k] = v
+		}
+		return true
+	})
+	sort.Slice(keys, func(i, j int) bool { return tracks[keys[i]].Timestamp < tracks[keys[j]].Timestamp })
+
+	places := []*DiscoveredPlace{}
+	for _, k := range keys {
+		fingerprint := tracks[k]
+		fits := false
+		for _, c := range locations {
+			if c.distance(fingerprint) < discoverMinDistance {
+				fits = true
+				break
+			}
+		}
+		if fits {
+			continue
+		}
+		var closest *DiscoveredPlace
+		closestDistance := float64(discoverClusterRadius)
+		for _, place := range places {
+			if d := place.centroid.distance(fingerprint); d < closestDistance {
+				closest, closestDistance = place, d
+			}
+		}
+		if closest == nil {
+			closest = &DiscoveredPlace{ID: k, centroid: newRssiCentroid()}
+			places = append(places, closest)
+		}
+		closest.centroid.add(fingerprint)
+		closest.keys = append(closest.keys, k)
+	}
+
+	discovered := []DiscoveredPlace{}
+	for _, place := range places {
+		if len(place.keys) < discoverMinSamples {
+			continue
+		}
+		place.describe(tracks, locations)
+		discovered = append(discovered, *place)
+	}
+	sort.Slice(discovered, func(i, j int) bool { return discovered[i].Samples > discovered[j].Samples })
+	return discovered, nil
+}
+
+// describe fills in the statistics of a place from its fingerprints.
+func (place *DiscoveredPlace) describe(tracks map[string]Fingerprint, locations map[string]*rssiCentroid) {
+	place.Samples = len(place.keys)
+	place.Hours = make([]int, 24)
+	place.Users = []string{}
+	lastByUser := make(map[string]int64)
+	visitStart := make(map[string]int64)
+	visitTotal := float64(0)
+	for _, k := range place.keys {
+		fingerprint := tracks[k]
+		seen := time.Unix(0, fingerprint.Timestamp)
+		place.Hours[seen.Hour()]++
+		if place.FirstSeen.IsZero() {
+			place.FirstSeen = seen
+		}
+		place.LastSeen = seen
+		last, ok := lastByUser[fingerprint.Username]
+		if !ok {
+			place.Users = append(place.Users, fingerprint.Username)
+		}
+		if !ok || time.Duration(fingerprint.Timestamp-last) > discoverVisitGap {
+			if ok {
+				visitTotal += time.Duration(last - visitStart[fingerprint.Username]).Seconds()
+			}
+			place.Visits++
+			visitStart[fingerprint.Username] = fingerprint.Timestamp
+		}
+		lastByUser[fingerprint.Username] = fingerprint.Timestamp
+	}
+	for user, last := range lastByUser {
+		visitTotal += time.Duration(last - visitStart[user]).Seconds()
+	}
+	place.MeanVisit = visitTotal / float64(place.Visits)
+	sort.Strings(place.Users)
+
+	place.Signature = make(map[string]float64)
+	for mac, count := range place.centroid.counts {
+		if 2*count >= place.centroid.n {
+			place.Signature[mac] = math.Floor(place.centroid.sums[mac]/float64(count)*10+0.5) / 10
+		}
+	}
+	nearest := math.Inf(1)
+	for loc, c := range locations {
+		d := 0.0
+		for _, k := range place.keys {
+			d += c.distance(tracks[k])
+		}
+		if d /= float64(len(place.keys)); d < nearest {
+			place.Nearest, nearest = loc, d
+		}
+	}
+	if !math.IsInf(nearest, 1) {
+		place.Distance = math.Floor(nearest*10+0.5) / 10
+	}
+}
+
+// learnDiscoveredPlace learns the fingerprints of a discovered place at location.
+func learnDiscoveredPlace(group string, id string, location string, days float64) (int, error) {
+	places, err := discoverPlaces(group, days)
+	if err != nil {
+		return 0, err
+	}
+	for _, place := range places {
+		if place.ID != id {
+			continue
+		}
+		keys := make(map[string]bool)
+		for _, k := range place.keys {
+			keys[k] = true
+		}
+		// newest first, stopping at the first sample of the place
+		since := place.FirstSeen.UnixNano()
+		fingerprints := []Fingerprint{}
+		storage.ForEachFingerprint(group, "fingerprints-track", true, func(k string, v Fingerprint) bool {
+			if v.Timestamp < since || len(fingerprints) == len(keys) {
+				return false
+			}
+			if keys[k] {
+				v.Group = group
+				v.Location = location
+				fingerprints = append(fingerprints, v)
+			}
+			return true
+		})
+		learned, err := putFingerprintsIntoDatabase(group, "fingerprints", fingerprints)
+		if err == nil && learned > 0 {
+			recalculateGroup(group)
+		}
+		return learned, err
+	}
+	return 0, fmt.Errorf("place %s was not found, discover places again", id)
+}
+
+func discoverDays(c *gin.Context) (float64, error) {
+	days, err := strconv.ParseFloat(c.DefaultQuery("days", "7"), 64)
+	if err == nil && days <= 0 {
+		err = fmt.Errorf("days must be more than 0")
+	}
+	return days, err
+}
+
+// getDiscoveredPlaces usage: curl "http://localhost:8003/discover?group=X&days=7"
+func getDiscoveredPlaces(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	days, err := discoverDays(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not parse days", "success": false})
+		return
+	}
+	places, err := discoverPlaces(group, days)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Found " + strconv.Itoa(len(places)) + " places that were not learned", "success": true, "places": places})
+}
+
+// putDiscoveredPlace learns the fingerprints of a discovered place at a location.
+// usage: curl -X PUT "http://localhost:8003/discover?group=X&id=Y&location=Z"
+func putDiscoveredPlace(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	id := strings.TrimSpace(c.DefaultQuery("id", "noneasdf"))
+	location := strings.TrimSpace(strings.ToLower(c.DefaultQuery("location", "noneasdf")))
+	if group == "noneasdf" || id == "noneasdf" || location == "noneasdf" || len(location) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Need to provide group, id and location", "success": false})
+		return
+	}
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	days, err := discoverDays(c)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not parse days", "success": false})
+		return
+	}
+	learned, err := learnDiscoveredPlace(group, id, location, days)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Learned " + strconv.Itoa(learned) + " fingerprints at " + location, "success": true, "learned": learned})
+}
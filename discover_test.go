@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiscoverPlaces(t *testing.T) {
+	defer useMemoryStore()()
+
+	s := simulateGrid(t, Simulation{
+		Group: "discovertest",
+		Rooms: []SimulationRoom{
+			{Name: "kitchen", X1: 0, Y1: 0, X2: 6, Y2: 6},
+			{Name: "office", X1: 24, Y1: 0, X2: 30, Y2: 6},
+		},
+	})
+
+	// zack goes to the kitchen once and to the bathroom, which was never learned, twice
+	r := rand.New(rand.NewSource(2))
+	start := time.Now().Add(-3 * time.Hour)
+	tracks := []Fingerprint{}
+	visit := func(x float64, y float64, from time.Time, minutes int) {
+		for i := 0; i < minutes; i++ {
+			tracks = append(tracks, Fingerprint{Username: "zack", Timestamp: from.Add(time.Duration(i) * time.Minute).UnixNano(), WifiFingerprint: s.scan(r, x+r.Float64()*4, y+r.Float64()*4)})
+		}
+	}
+	visit(1, 1, start, 15)
+	visit(25, 25, start.Add(30*time.Minute), 10)
+	visit(25, 25, start.Add(2*time.Hour), 20)
+	_, err := insertSimulated("discovertest", "fingerprints-track", tracks)
+	assert.Nil(t, err)
+
+	places, err := discoverPlaces("discovertest", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(places))
+	place := places[0]
+	assert.True(t, place.Samples >= 25)
+	assert.Equal(t, 2, place.Visits)
+	assert.Equal(t, []string{"zack"}, place.Users)
+	assert.Equal(t, 24, len(place.Hours))
+	assert.True(t, place.MeanVisit > 5*60)
+	assert.Contains(t, place.Signature, "dd")
+
+	// tracking from before the window is not used
+	places, err = discoverPlaces("discovertest", 0.01)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(places))
+
+	learned, err := learnDiscoveredPlace("discovertest", place.ID, "bathroom", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, place.Samples, learned)
+	places, err = discoverPlaces("discovertest", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(places))
+	_, err = learnDiscoveredPlace("discovertest", place.ID, "bathroom", 1)
+	assert.NotNil(t, err)
+}
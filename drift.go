@@ -0,0 +1,304 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// drift.go compares the access points seen in recent tracking with the learned
+// ones, to notice when access points were replaced or moved, and can
+// recalculate the group or send an alert over MQTT when that happens.
+// Recalculating only follows the drift as far as self-training does (see
+// selftrain.go), so missing and new access points still need learning again.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	registerResources("driftSettings", "drift")
+}
+
+const (
+	// driftMinFrequency is the fraction of fingerprints an access point needs to be seen in to count
+	driftMinFrequency = 0.2
+	// driftMissingRatio is how much less often than when learning an access point is seen when it is missing
+	driftMissingRatio = 0.1
+	// driftMinTracks is the number of tracked fingerprints needed to measure drift
+	driftMinTracks = 20
+	// driftMaxFingerprints is the most tracked fingerprints that are looked at
+	driftMaxFingerprints = 5000
+)
+
+// DriftSettings is what a group does about drift. Drift is checked in the
+// background for every group, and only acted on if the group recalculates or alerts.
+type DriftSettings struct {
+	Threshold   float64 `json:"threshold"`   // score above which the group has drifted
+	Days        float64 `json:"days"`        // how far back tracked fingerprints are used
+	Recalculate bool    `json:"recalculate"` // needs self-training, otherwise recalculating uses the same learned fingerprints
+	Alert       bool    `json:"alert"`       // publish the report to GROUP/drift over MQTT
+}
+
+// NewDriftSettings returns the settings of a group that has not changed them
+func NewDriftSettings() DriftSettings {
+	return DriftSettings{Threshold: 0.3, Days: 3}
+}
+
+// DriftMac is how often an access point was seen when learning and tracking
+type DriftMac struct {
+	Mac     string  `json:"mac"`
+	Learned float64 `json:"learned"` // fraction of learned fingerprints that saw it
+	Tracked float64 `json:"tracked"` // fraction of recent tracked fingerprints that saw it
+}
+
+// DriftReport describes the drift of a group. The score is the share of the
+// frequent access points, weighted by how often they are seen, that went
+// missing or are new, between 0 and 1.
+type DriftReport struct {
+	Calculated time.Time  `json:"calculated"`
+	Learned    int        `json:"learned"`
+	Tracked    int        `json:"tracked"`
+	Missing    []DriftMac `json:"missing"`
+	New        []DriftMac `json:"new"`
+	Score      float64    `json:"score"`
+	Drifted    bool       `json:"drifted"`
+}
+
+// driftScores holds the score of the last background check of each group, for getStatus
+var driftScores = struct {
+	sync.RWMutex
+	m map[string]float64
+}{m: make(map[string]float64)}
+
+func getDriftScores() map[string]float64 {
+	driftScores.RLock()
+	defer driftScores.RUnlock()
+	scores := make(map[string]float64, len(driftScores.m))
+	for group, score := range driftScores.m {
+		scores[group] = score
+	}
+	return scores
+}
+
+func getDriftSettings(group string) DriftSettings {
+	settings := NewDriftSettings()
+	v, err := storage.GetResource(group, "driftSettings")
+	if err == nil && len(v) > 0 {
+		json.Unmarshal(v, &settings)
+	}
+	return settings
+}
+
+func setDriftSettings(group string, settings DriftSettings) error {
+	if settings.Threshold <= 0 || settings.Threshold > 1 {
+		return fmt.Errorf("threshold must be more than 0 and at most 1")
+	}
+	if settings.Days <= 0 {
+		return fmt.Errorf("days must be more than 0")
+	}
+	if settings.Recalculate && !getSelfTrainingSettings(group).Enabled {
+		return fmt.Errorf("recalculating on drift needs self-training, otherwise the group is recalculated from the same learned fingerprints")
+	}
+	jsonByte, _ := json.Marshal(settings)
+	return storage.PutResources(group, map[string][]byte{"driftSettings": jsonByte})
+}
+
+// calculateDrift compares how often each access point was seen in the learned
+// fingerprints of group and in those tracked in the last days.
+func calculateDrift(group string, settings DriftSettings) (DriftReport, error) {
+	report := DriftReport{Calculated: time.Now(), Missing: []DriftMac{}, New: []DriftMac{}}
+	learned := make(map[string]int)
+	err := storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v Fingerprint) bool {
+		report.Learned++
+		for _, router := range v.WifiFingerprint {
+			learned[router.Mac]++
+		}
+		return true
+	})
+	if err != nil {
+		return report, err
+	}
+	since := time.Now().Add(-time.Duration(settings.Days * float64(24*time.Hour))).UnixNano()
+	tracked := make(map[string]int)
+	storage.ForEachFingerprint(group, "fingerprints-track", true, func(k string, v Fingerprint) bool {
+		if v.Timestamp < since || report.Tracked >= driftMaxFingerprints {
+			return false
+		}
+		report.Tracked++
+		for _, router := range v.WifiFingerprint {
+			tracked[router.Mac]++
+		}
+		return true
+	})
+	if report.Learned == 0 || report.Tracked < driftMinTracks {
+		return report, nil
+	}
+
+	total, drifted := float64(0), float64(0)
+	for mac, count := range learned {
+		m := DriftMac{Mac: mac, Learned: float64(count) / float64(report.Learned), Tracked: float64(tracked[mac]) / float64(report.Tracked)}
+		if m.Learned < driftMinFrequency {
+			continue
+		}
+		total += m.Learned
+		if m.Tracked < m.Learned*driftMissingRatio {
+			drifted += m.Learned
+			report.Missing = append(report.Missing, m)
+		}
+	}
+	for mac, count := range tracked {
+		m := DriftMac{Mac: mac, Tracked: float64(count) / float64(report.Tracked)}
+		if learned[mac] > 0 || m.Tracked < driftMinFrequency {
+			continue
+		}
+		total += m.Tracked
+		drifted += m.Tracked
+		report.New = append(report.New, m)
+	}
+	sort.Slice(report.Missing, func(i, j int) bool { return report.Missing[i].Learned > report.Missing[j].Learned })
+	sort.Slice(report.New, func(i, j int) bool { return report.New[i].Tracked > report.New[j].Tracked })
+	if total > 0 {
+		report.Score = drifted / total
+	}
+	report.Drifted = report.Score >= settings.Threshold
+	return report, nil
+}
+
+// openDriftReport returns the report of the last background check of group.
+func openDriftReport(group string) (DriftReport, bool) {
+	var report DriftReport
+	v, err := storage.GetResource(group, "drift")
+	if err != nil || len(v) == 0 {
+		return report, false
+	}
+	return report, json.Unmarshal(v, &report) == nil
+}
+
+// checkDrift calculates and saves the drift of a group, and recalculates or
+// alerts if the group does so when it has drifted since the last check.
+func checkDrift(group string) (DriftReport, error) {
+	settings := getDriftSettings(group)
+	previous, _ := openDriftReport(group)
+	report, err := calculateDrift(group, settings)
+	if err != nil {
+		return report, err
+	}
+	jsonByte, _ := json.Marshal(report)
+	if err = storage.PutResources(group, map[string][]byte{"drift": jsonByte}); err != nil {
+		return report, err
+	}
+	driftScores.Lock()
+	driftScores.m[group] = report.Score
+	driftScores.Unlock()
+	if !report.Drifted || previous.Drifted {
+		return report, nil
+	}
+	Warning.Printf("%s has drifted (score %2.2f)", group, report.Score)
+	if settings.Alert && RuntimeArgs.Mqtt {
+		if err = sendMQTTDrift(string(jsonByte), group); err != nil {
+			Warning.Println(err)
+		}
+	}
+	if settings.Recalculate && getSelfTrainingSettings(group).Enabled {
+		err = optimizePriorsThreaded(group)
+	}
+	return report, err
+}
+
+func sendMQTTDrift(message string, group string) error {
+	if token := adminClient.Publish(group+"/drift", 1, false, message); token.Wait() && token.Error() != nil {
+		return fmt.Errorf("Failed to send message")
+	}
+	return nil
+}
+
+func checkDriftEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		groups, err := storage.ListGroups()
+		if err != nil {
+			Warning.Println(err)
+			continue
+		}
+		for _, group := range groups {
+			if _, err := checkDrift(group); err != nil {
+				Warning.Println(err)
+			}
+		}
+	}
+}
+
+// getDrift usage: curl "http://localhost:8003/drift?group=X"
+func getDrift(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	settings := getDriftSettings(group)
+	report, err := calculateDrift(group, settings)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	message := fmt.Sprintf("Drift is %2.2f, %d access points are missing and %d are new", report.Score, len(report.Missing), len(report.New))
+	if report.Tracked < driftMinTracks {
+		message = "Need at least " + strconv.Itoa(driftMinTracks) + " tracked fingerprints to measure drift"
+	} else if report.Drifted {
+		message += ", learn again"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "success": true, "drift": report, "settings": settings})
+}
+
+// putDrift usage: curl -X PUT "http://localhost:8003/drift?group=X&threshold=0.3&days=3&recalculate=true&alert=true"
+// Settings that are not given are kept.
+func putDrift(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	settings := getDriftSettings(group)
+	var err error
+	for key, value := range map[string]*float64{"threshold": &settings.Threshold, "days": &settings.Days} {
+		if v := c.Query(key); len(v) > 0 && err == nil {
+			*value, err = strconv.ParseFloat(v, 64)
+		}
+	}
+	for key, value := range map[string]*bool{"recalculate": &settings.Recalculate, "alert": &settings.Alert} {
+		if v := c.Query(key); len(v) > 0 && err == nil {
+			*value, err = strconv.ParseBool(v)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not parse settings: " + err.Error(), "success": false})
+		return
+	}
+	if err = setDriftSettings(group, settings); err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Saved drift settings for " + group, "success": true, "settings": settings})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDrift(t *testing.T) {
+	defer useMemoryStore()()
+
+	now := time.Now()
+	learned, tracks := []Fingerprint{}, []Fingerprint{}
+	for i := 0; i < 30; i++ {
+		learned = append(learned, Fingerprint{Group: "drifttest", Username: "zack", Location: "kitchen", Timestamp: now.Add(-time.Duration(30+i) * 24 * time.Hour).UnixNano(),
+			WifiFingerprint: []Router{{Mac: "aa", Rssi: -50}, {Mac: "bb", Rssi: -60}, {Mac: "cc", Rssi: -70}}})
+		// cc was replaced by ee
+		tracks = append(tracks, Fingerprint{Group: "drifttest", Username: "zack", Timestamp: now.Add(-time.Duration(i) * time.Minute).UnixNano(),
+			WifiFingerprint: []Router{{Mac: "aa", Rssi: -50}, {Mac: "bb", Rssi: -60}, {Mac: "ee", Rssi: -70}}})
+	}
+	_, err := putFingerprintsIntoDatabase("drifttest", "fingerprints", learned)
+	assert.Nil(t, err)
+
+	// too little tracking to tell
+	report, err := calculateDrift("drifttest", NewDriftSettings())
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), report.Score)
+	assert.False(t, report.Drifted)
+
+	_, err = putFingerprintsIntoDatabase("drifttest", "fingerprints-track", tracks)
+	assert.Nil(t, err)
+	report, err = calculateDrift("drifttest", NewDriftSettings())
+	assert.Nil(t, err)
+	assert.Equal(t, 30, report.Learned)
+	assert.Equal(t, 30, report.Tracked)
+	assert.Equal(t, []DriftMac{{Mac: "cc", Learned: 1, Tracked: 0}}, report.Missing)
+	assert.Equal(t, []DriftMac{{Mac: "ee", Learned: 0, Tracked: 1}}, report.New)
+	assert.InDelta(t, 0.5, report.Score, 0.001)
+	assert.True(t, report.Drifted)
+
+	// groups that do not act on drift are still checked, without recalculating
+	_, err = checkDrift("drifttest")
+	assert.Nil(t, err)
+	saved, ok := openDriftReport("drifttest")
+	assert.True(t, ok)
+	assert.True(t, saved.Drifted)
+	assert.Equal(t, report.Score, getDriftScores()["drifttest"])
+	v, _ := storage.GetResource("drifttest", "fullParameters")
+	assert.Empty(t, v)
+
+	// recalculating needs self-training to change anything
+	settings := getDriftSettings("drifttest")
+	settings.Recalculate = true
+	assert.NotNil(t, setDriftSettings("drifttest", settings))
+	selfTraining := NewSelfTrainingSettings()
+	selfTraining.Enabled = true
+	assert.Nil(t, setSelfTrainingSettings("drifttest", selfTraining))
+	assert.Nil(t, setDriftSettings("drifttest", settings))
+	// it recalculates when the group drifts again, after having recovered
+	saved.Drifted = false
+	jsonByte, _ := json.Marshal(saved)
+	assert.Nil(t, storage.PutResources("drifttest", map[string][]byte{"drift": jsonByte}))
+	report, err = checkDrift("drifttest")
+	assert.Nil(t, err)
+	assert.True(t, report.Drifted)
+	ps, err := openParameters("drifttest")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"kitchen"}, ps.UniqueLocs)
+
+	// the tracking window leaves out older tracked fingerprints
+	settings.Days = float64(10) / (24 * 60)
+	report, err = calculateDrift("drifttest", settings)
+	assert.Nil(t, err)
+	assert.True(t, report.Tracked < driftMinTracks)
+
+	settings.Threshold = 0
+	assert.NotNil(t, setDriftSettings("drifttest", settings))
+}
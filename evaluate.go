@@ -0,0 +1,242 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// evaluate.go trains the classifiers on labelled fingerprints and replays a
+// test set through them, for comparing changes reproducibly (see the -evaluate flag).
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// EvaluationResult is how well one classifier did on the test set
+type EvaluationResult struct {
+	Classifier  string                    `json:"classifier"`
+	Tested      int                       `json:"tested"`
+	Correct     int                       `json:"correct"`
+	Accuracy    float64                   `json:"accuracy"`
+	Confusion   map[string]map[string]int `json:"confusion"` // true location -> guessed location -> count
+	MeanLatency time.Duration             `json:"mean_latency"`
+	P95Latency  time.Duration             `json:"p95_latency"`
+}
+
+// EvaluationReport is the result of evaluating every enabled classifier
+type EvaluationReport struct {
+	Train     int                `json:"train"`
+	Test      int                `json:"test"`
+	Locations []string           `json:"locations"`
+	Results   []EvaluationResult `json:"results"`
+}
+
+// evaluationClassifier guesses the location of a fingerprint of the group being evaluated
+type evaluationClassifier struct {
+	name     string
+	classify func(group string, fingerprint Fingerprint) string
+}
+
+func enabledClassifiers() []evaluationClassifier {
+	classifiers := []evaluationClassifier{{"naive bayes", func(group string, fingerprint Fingerprint) string {
+		guess, _ := calculatePosterior(fingerprint, *NewFullParameters())
+		return guess
+	}}}
+	if RuntimeArgs.Svm {
+		classifiers = append(classifiers, evaluationClassifier{"svm", func(group string, fingerprint Fingerprint) string {
+			guess, _ := classify(fingerprint)
+			return guess
+		}})
+	}
+	if RuntimeArgs.RandomForests {
+		classifiers = append(classifiers, evaluationClassifier{"random forests", func(group string, fingerprint Fingerprint) string {
+			guess, best := "", math.Inf(-1)
+			for loc, value := range rfClassify(group, fingerprint) {
+				if value > best {
+					guess, best = loc, value
+				}
+			}
+			return guess
+		}})
+	}
+	return classifiers
+}
+
+// loadEvaluationSource returns the learned fingerprints of a group, or those
+// of a file of newline-delimited Fingerprint JSON if source is a file. A file
+// is read like a bulk import to /learn/bulk without a group, so every line
+// needs a group, a location and fingerprints.
+func loadEvaluationSource(source string) ([]Fingerprint, error) {
+	fingerprints := []Fingerprint{}
+	if info, err := os.Stat(source); err == nil && !info.IsDir() {
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		err = scanBulkLines(f, func(lineNum int, line []byte) error {
+			fingerprint, err := parseBulkLine(line, "", "fingerprints")
+			if err != nil {
+				return fmt.Errorf("line %d: %s", lineNum, err.Error())
+			}
+			fingerprints = append(fingerprints, fingerprint)
+			return nil
+		})
+		return fingerprints, err
+	}
+
+	group := strings.ToLower(source)
+	if !groupExists(group) {
+		return nil, fmt.Errorf("%s is neither a file nor a group", source)
+	}
+	err := storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v Fingerprint) bool {
+		fingerprints = append(fingerprints, v)
+		return true
+	})
+	return fingerprints, err
+}
+
+// splitEvaluationSet holds out every n-th fingerprint of each location, in
+// order of time, so that the same data is always split the same way.
+func splitEvaluationSet(fingerprints []Fingerprint, testFraction float64) ([]Fingerprint, []Fingerprint) {
+	train, test := []Fingerprint{}, []Fingerprint{}
+	if testFraction <= 0 {
+		return fingerprints, test
+	}
+	every := int(math.Max(1, math.Floor(1/testFraction+0.5)))
+	sorted := append([]Fingerprint{}, fingerprints...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Timestamp < sorted[j].Timestamp })
+	seen := make(map[string]int)
+	for _, fingerprint := range sorted {
+		seen[fingerprint.Location]++
+		if seen[fingerprint.Location]%every == 0 {
+			test = append(test, fingerprint)
+		} else {
+			train = append(train, fingerprint)
+		}
+	}
+	return train, test
+}
+
+// evaluateFingerprints trains every enabled classifier on train in a scratch
+// group, which is deleted afterwards, and classifies test with them.
+func evaluateFingerprints(train []Fingerprint, test []Fingerprint) (EvaluationReport, error) {
+	report := EvaluationReport{Train: len(train), Test: len(test), Locations: []string{}, Results: []EvaluationResult{}}
+	if len(train) == 0 || len(test) == 0 {
+		return report, fmt.Errorf("need fingerprints to train and test on")
+	}
+	group := "evaluate-" + strings.ToLower(RandStringBytesMaskImprSrc(8))
+	defer func() {
+		storage.DeleteGroup(group)
+		os.Remove(path.Join(RuntimeArgs.SourcePath, group+".model"))
+		// written by rfLearn and the random forests server
+		os.Remove(path.Join(RuntimeArgs.SourcePath, group+".rf.json"))
+		os.Remove(path.Join(RuntimeArgs.SourcePath, group+".rf.pkl"))
+	}()
+
+	scratch := make([]Fingerprint, len(train))
+	for i, fingerprint := range train {
+		fingerprint.Group = group
+		scratch[i] = fingerprint
+		if !stringInSlice(fingerprint.Location, report.Locations) {
+			report.Locations = append(report.Locations, fingerprint.Location)
+		}
+	}
+	sort.Strings(report.Locations)
+	if _, err := putFingerprintsIntoDatabase(group, "fingerprints", scratch); err != nil {
+		return report, err
+	}
+	recalculateGroup(group)
+
+	for _, classifier := range enabledClassifiers() {
+		result := EvaluationResult{Classifier: classifier.name, Confusion: make(map[string]map[string]int)}
+		latencies := []time.Duration{}
+		for _, fingerprint := range test {
+			location := fingerprint.Location
+			fingerprint.Group = group
+			fingerprint.Location = ""
+			filterFingerprint(&fingerprint)
+
+			start := time.Now()
+			guess := classifier.classify(group, fingerprint)
+			latencies = append(latencies, time.Since(start))
+
+			if _, ok := result.Confusion[location]; !ok {
+				result.Confusion[location] = make(map[string]int)
+			}
+			result.Confusion[location][guess]++
+			result.Tested++
+			if guess == location {
+				result.Correct++
+			}
+		}
+		result.Accuracy = 100 * float64(result.Correct) / float64(result.Tested)
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		total := time.Duration(0)
+		for _, latency := range latencies {
+			total += latency
+		}
+		result.MeanLatency = total / time.Duration(len(latencies))
+		result.P95Latency = latencies[(len(latencies)*95-1)/100]
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// print writes the report as a table per classifier.
+func (r EvaluationReport) print(w io.Writer) {
+	fmt.Fprintf(w, "Trained on %d fingerprints of %d locations, tested on %d\n", r.Train, len(r.Locations), r.Test)
+	for _, result := range r.Results {
+		fmt.Fprintf(w, "\n%s: %2.1f%% correct (%d/%d), mean %s, p95 %s\n", result.Classifier, result.Accuracy, result.Correct, result.Tested, result.MeanLatency, result.P95Latency)
+		guesses := append([]string{}, r.Locations...)
+		for _, row := range result.Confusion {
+			for guess := range row {
+				if !stringInSlice(guess, guesses) {
+					guesses = append(guesses, guess)
+				}
+			}
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "true \\ guess\t"+strings.Join(guesses, "\t")+"\t")
+		for _, location := range r.Locations {
+			if _, ok := result.Confusion[location]; !ok {
+				continue
+			}
+			fmt.Fprint(tw, location+"\t")
+			for _, guess := range guesses {
+				fmt.Fprintf(tw, "%d\t", result.Confusion[location][guess])
+			}
+			fmt.Fprintln(tw)
+		}
+		tw.Flush()
+	}
+}
+
+// runEvaluation evaluates the classifiers on source, testing on testSource if
+// it is set and otherwise on a held out testFraction of source. It trains in
+// a memory store, so that the stored data is not changed.
+func runEvaluation(source string, testSource string, testFraction float64) (EvaluationReport, error) {
+	fingerprints, err := loadEvaluationSource(source)
+	if err != nil {
+		return EvaluationReport{}, err
+	}
+	train, test := splitEvaluationSet(fingerprints, testFraction)
+	if len(testSource) > 0 {
+		train = fingerprints
+		test, err = loadEvaluationSource(testSource)
+		if err != nil {
+			return EvaluationReport{}, err
+		}
+	}
+	defaultStorage := storage
+	storage = newMemoryStore()
+	defer func() { storage = defaultStorage }()
+	return evaluateFingerprints(train, test)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateFingerprints(t *testing.T) {
+	defer useMemoryStore()()
+
+	fingerprints := []Fingerprint{}
+	for i := 0; i < 10; i++ {
+		fingerprints = append(fingerprints,
+			Fingerprint{Group: "evaltest", Location: "kitchen", Timestamp: int64(2 * i), WifiFingerprint: []Router{{Mac: "aa", Rssi: -40 - i}, {Mac: "bb", Rssi: -90}}},
+			Fingerprint{Group: "evaltest", Location: "den", Timestamp: int64(2*i + 1), WifiFingerprint: []Router{{Mac: "aa", Rssi: -90}, {Mac: "bb", Rssi: -40 - i}}})
+	}
+	train, test := splitEvaluationSet(fingerprints, 0.2)
+	assert.Equal(t, 16, len(train))
+	assert.Equal(t, 4, len(test))
+	assert.Equal(t, int64(8), test[0].Timestamp)
+
+	report, err := evaluateFingerprints(train, test)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"den", "kitchen"}, report.Locations)
+	assert.Equal(t, "naive bayes", report.Results[0].Classifier)
+	assert.Equal(t, 4, report.Results[0].Tested)
+	assert.Equal(t, 100.0, report.Results[0].Accuracy)
+	assert.Equal(t, 2, report.Results[0].Confusion["kitchen"]["kitchen"])
+	groups, _ := storage.ListGroups()
+	assert.Equal(t, 0, len(groups))
+
+	var out bytes.Buffer
+	report.print(&out)
+	assert.Contains(t, out.String(), "naive bayes: 100.0% correct (4/4)")
+
+	_, err = evaluateFingerprints(train, []Fingerprint{})
+	assert.NotNil(t, err)
+
+	// evaluating a group trains in a scratch store and puts the store back
+	store := storage
+	_, err = putFingerprintsIntoDatabase("evaltest", "fingerprints", fingerprints)
+	assert.Nil(t, err)
+	report, err = runEvaluation("evaltest", "", 0.2)
+	assert.Nil(t, err)
+	assert.Equal(t, 4, report.Test)
+	assert.True(t, store == storage)
+}
+
+func TestLoadEvaluationSource(t *testing.T) {
+	f, err := ioutil.TempFile("", "evaluate")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(`{"group": "evaltest", "location": "kitchen", "wifi-fingerprint": [{"rssi": -45, "mac": "aa"}]}` + "\n\n")
+	f.Close()
+	fingerprints, err := loadEvaluationSource(f.Name())
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(fingerprints))
+	assert.Equal(t, "kitchen", fingerprints[0].Location)
+
+	// the lines are checked like those of a bulk import
+	ioutil.WriteFile(f.Name(), []byte(`{"location": "kitchen", "wifi-fingerprint": [{"rssi": -45, "mac": "aa"}]}`), 0644)
+	_, err = loadEvaluationSource(f.Name())
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "line 1")
+	ioutil.WriteFile(f.Name(), []byte(strings.Repeat(" ", bulkMaxLineSize+1)), 0644)
+	_, err = loadEvaluationSource(f.Name())
+	assert.NotNil(t, err)
+}
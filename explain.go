@@ -0,0 +1,235 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// explain.go breaks the Naive-Bayes posterior of a fingerprint down into what
+// each access point adds to the score of each location, to show why a
+// fingerprint was classified where it was, and notes when tracking moved the
+// guess because it was too far to walk to (see graph.go).
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APContribution is what an access point adds to the score of a location,
+// before the terms are normalized.
+type APContribution struct {
+	Mac       string  `json:"mac"`
+	Rssi      int     `json:"rssi"`
+	MacFreq   float64 `json:"mac_freq"`  // term for having seen the access point
+	Histogram float64 `json:"histogram"` // term for the signal strength, 0 if it is ignored
+}
+
+// LocationExplanation is the score of a location and how it was reached
+type LocationExplanation struct {
+	Location            string           `json:"location"`
+	Prior               float64          `json:"prior"` // uniform unless the group uses the history of the user
+	MacFreq             float64          `json:"mac_freq"`
+	Histogram           float64          `json:"histogram"`
+	MacFreqNormalized   float64          `json:"mac_freq_normalized"`
+	HistogramNormalized float64          `json:"histogram_normalized"`
+	Score               float64          `json:"score"` // as returned by calculatePosterior
+	APs                 []APContribution `json:"aps"`   // largest contribution first
+}
+
+// IgnoredAP is an access point whose signal strength was not used
+type IgnoredAP struct {
+	Mac         string  `json:"mac"`
+	Rssi        int     `json:"rssi"`
+	Variability float64 `json:"variability"`
+}
+
+// Explanation is the posterior of a fingerprint broken down by access point
+type Explanation struct {
+	Guess     string                `json:"guess"`
+	Network   string                `json:"network"`
+	InNetwork bool                  `json:"in_network"`
+	MixIn     float64               `json:"mixin"`
+	Cutoff    float64               `json:"cutoff"`
+	Locations []LocationExplanation `json:"locations"`      // best first
+	Ignored   []IgnoredAP           `json:"ignored"`        // variability below the cutoff
+	Jump      *JumpPenalty          `json:"jump,omitempty"` // if tracking penalized locations too far to walk to
+}
+
+// explainPosterior calculates the posterior of res like
+// calculatePosteriorWithPrior, keeping the contribution of every access point
+// to every location. A nil userPrior is uniform.
+func explainPosterior(res Fingerprint, ps FullParameters, userPrior map[string]float64) Explanation {
+	if !ps.Loaded {
+		ps, _ = openParameters(res.Group)
+	}
+	W := make(map[string]int)
+	for _, router := range res.WifiFingerprint {
+		W[router.Mac] = router.Rssi
+	}
+	aps := make(map[string][]APContribution)
+	n, PBayes1, PBayes2 := posteriorTerms(res, ps, userPrior, func(loc string, mac string, macFreq float64, histogram float64) {
+		aps[loc] = append(aps[loc], APContribution{Mac: mac, Rssi: W[mac], MacFreq: macFreq, Histogram: histogram})
+	})
+	_, inNetwork := ps.NetworkMacs[n]
+	explanation := Explanation{Network: n, InNetwork: inNetwork, Locations: []LocationExplanation{}, Ignored: []IgnoredAP{}}
+	priors, ok := ps.Priors[n]
+	if !ok {
+		return explanation
+	}
+	explanation.MixIn = priors.Special["MixIn"]
+	explanation.Cutoff = priors.Special["VarabilityCutoff"]
+	for mac, rssi := range W {
+		if float64(ps.MacVariability[mac]) < explanation.Cutoff {
+			explanation.Ignored = append(explanation.Ignored, IgnoredAP{Mac: mac, Rssi: rssi, Variability: float64(ps.MacVariability[mac])})
+		}
+	}
+	sort.Slice(explanation.Ignored, func(i, j int) bool { return explanation.Ignored[i].Mac < explanation.Ignored[j].Mac })
+
+	normalized1 := normalizeBayes(copyBayes(PBayes1))
+	normalized2 := normalizeBayes(copyBayes(PBayes2))
+	guess, scores := mixPosterior(explanation.MixIn, normalized1, normalized2)
+	if len(ps.NetworkLocs[n]) == 1 {
+		for loc := range ps.NetworkLocs[n] {
+			guess, scores = loc, map[string]float64{loc: 1}
+		}
+	}
+	explanation.Guess = guess
+	for loc := range ps.NetworkLocs[n] {
+		prior := 1.0 / float64(len(ps.NetworkLocs[n]))
+		if userPrior != nil {
+			prior = userPrior[loc]
+		}
+		contributions := aps[loc]
+		sort.Slice(contributions, func(i, j int) bool {
+			return math.Abs(contributions[i].MacFreq+contributions[i].Histogram) > math.Abs(contributions[j].MacFreq+contributions[j].Histogram)
+		})
+		explanation.Locations = append(explanation.Locations, LocationExplanation{
+			Location:            loc,
+			Prior:               prior,
+			MacFreq:             PBayes1[loc],
+			Histogram:           PBayes2[loc],
+			MacFreqNormalized:   normalized1[loc],
+			HistogramNormalized: normalized2[loc],
+			Score:               scores[loc],
+			APs:                 contributions,
+		})
+	}
+	sort.Slice(explanation.Locations, func(i, j int) bool {
+		return explanation.Locations[i].Score > explanation.Locations[j].Score
+	})
+	return explanation
+}
+
+func copyBayes(bayes map[string]float64) map[string]float64 {
+	copied := make(map[string]float64, len(bayes))
+	for loc, val := range bayes {
+		copied[loc] = val
+	}
+	return copied
+}
+
+// findTrackedFingerprint returns the tracked fingerprint of group with the key
+// id, or the last one of user if id is empty.
+func findTrackedFingerprint(group string, user string, id string) (Fingerprint, bool) {
+	var found Fingerprint
+	ok := false
+	storage.ForEachFingerprint(group, "fingerprints-track", true, func(k string, v Fingerprint) bool {
+		if (len(id) > 0 && k == id) || (len(id) == 0 && v.Username == user) {
+			found, ok = v, true
+			return false
+		}
+		return true
+	})
+	return found, ok
+}
+
+// trackedJump returns the jump penalty that tracking applied to a tracked
+// fingerprint of group, which is only kept for the last one of each user.
+func trackedJump(group string, fingerprint Fingerprint) *JumpPenalty {
+	last, ok := getLastLocationCache(group, strings.ToLower(fingerprint.Username))
+	if !ok || last.Timestamp != fingerprint.Timestamp {
+		return nil
+	}
+	return last.Jump
+}
+
+// explainResponse explains fingerprint, and the jump penalty if tracking applied one.
+func explainResponse(c *gin.Context, fingerprint Fingerprint, jump *JumpPenalty) {
+	cleanFingerprint(&fingerprint)
+	if len(fingerprint.WifiFingerprint) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "No fingerprints found to explain, see API", "success": false})
+		return
+	}
+	ps, err := openParameters(fingerprint.Group)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not open parameters for " + fingerprint.Group + ", try /calculate", "success": false})
+		return
+	}
+	explanation := explainPosterior(fingerprint, ps, userLocationPrior(fingerprint, ps))
+	explanation.Jump = jump
+	message := "Classified as " + explanation.Guess
+	if !explanation.InNetwork {
+		message += ", but the access points are not in any learned network"
+	}
+	if jump != nil && jump.Tracked != explanation.Guess {
+		message += ", tracked as " + jump.Tracked + " because " + jump.Guess + " is too far to walk to from " + jump.From
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "success": true, "fingerprint": fingerprint, "explanation": explanation})
+}
+
+// getExplain usage: curl "http://localhost:8003/explain?group=X&user=Y" for the last tracked fingerprint of a user,
+// or curl "http://localhost:8003/explain?group=X&id=Z" for the tracked fingerprint with the key Z
+func getExplain(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	user := strings.TrimSpace(strings.ToLower(c.DefaultQuery("user", "noneasdf")))
+	id := strings.TrimSpace(c.Query("id"))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	if len(id) == 0 && user == "noneasdf" {
+		c.JSON(http.StatusOK, gin.H{"message": "You need to specify user or id", "success": false})
+		return
+	}
+	fingerprint, ok := findTrackedFingerprint(group, user, id)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not find a tracked fingerprint", "success": false})
+		return
+	}
+	fingerprint.Group = group
+	explainResponse(c, fingerprint, trackedJump(group, fingerprint))
+}
+
+// explainPOST usage: curl -X POST -d '{"group":"X","wifi-fingerprint":[...]}' "http://localhost:8003/explain"
+// The fingerprint is not stored.
+func explainPOST(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var fingerprint Fingerprint
+	if c.BindJSON(&fingerprint) != nil {
+		Warning.Println("Could not bind JSON")
+		c.JSON(http.StatusOK, gin.H{"message": "Could not bind JSON", "success": false})
+		return
+	}
+	if !groupExists(strings.TrimSpace(strings.ToLower(fingerprint.Group))) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	filterFingerprint(&fingerprint)
+	explainResponse(c, fingerprint, nil)
+}
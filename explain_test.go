@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplainPosterior(t *testing.T) {
+	defer useMemoryStore()()
+
+	s := simulateGrid(t, Simulation{
+		Group: "explaintest",
+		Rooms: []SimulationRoom{
+			{Name: "kitchen", X1: 0, Y1: 0, X2: 4, Y2: 4},
+			{Name: "bedroom", X1: 26, Y1: 26, X2: 30, Y2: 30},
+			{Name: "office", X1: 26, Y1: 0, X2: 30, Y2: 4},
+		},
+	})
+	fingerprint := Fingerprint{Group: "explaintest", Username: "zack", WifiFingerprint: s.scan(rand.New(rand.NewSource(2)), 28, 2)}
+
+	ps, err := openParameters("explaintest")
+	assert.Nil(t, err)
+	guess, bayes := calculatePosterior(fingerprint, ps)
+	explanation := explainPosterior(fingerprint, ps, nil)
+	assert.True(t, explanation.InNetwork)
+	assert.Equal(t, guess, explanation.Guess)
+	assert.Equal(t, "office", explanation.Guess)
+	assert.Equal(t, ps.Priors[explanation.Network].Special["MixIn"], explanation.MixIn)
+	assert.Equal(t, 3, len(explanation.Locations))
+	assert.Equal(t, "office", explanation.Locations[0].Location)
+	for _, loc := range explanation.Locations {
+		assert.InDelta(t, bayes[loc.Location], loc.Score, 1e-9)
+		assert.InDelta(t, loc.Score, explanation.MixIn*loc.MacFreqNormalized+(1-explanation.MixIn)*loc.HistogramNormalized, 1e-9)
+		macFreq, histogram := float64(0), float64(0)
+		for _, ap := range loc.APs {
+			macFreq += ap.MacFreq
+			histogram += ap.Histogram
+		}
+		assert.InDelta(t, loc.MacFreq, macFreq, 1e-9)
+		assert.InDelta(t, loc.Histogram, histogram, 1e-9)
+	}
+
+	// access points below the cutoff only count for having been seen
+	first := fingerprint.WifiFingerprint[0]
+	cutoff := float64(ps.MacVariability[first.Mac]) + 0.001
+	explanation = explainPosterior(fingerprint, withCutoff(ps, explanation.Network, cutoff), nil)
+	assert.Equal(t, cutoff, explanation.Cutoff)
+	assert.Contains(t, explanation.Ignored, IgnoredAP{Mac: first.Mac, Rssi: first.Rssi, Variability: float64(ps.MacVariability[first.Mac])})
+	for _, loc := range explanation.Locations {
+		for _, ap := range loc.APs {
+			for _, ignored := range explanation.Ignored {
+				if ap.Mac == ignored.Mac {
+					assert.Equal(t, float64(0), ap.Histogram)
+				}
+			}
+		}
+	}
+
+	// a stored tracked fingerprint and a posted one are explained the same way
+	_, err = putFingerprintsIntoDatabase("explaintest", "fingerprints-track", []Fingerprint{fingerprint})
+	assert.Nil(t, err)
+	router := gin.New()
+	router.GET("/explain", getExplain)
+	router.POST("/explain", explainPOST)
+	req, _ := http.NewRequest("GET", "/explain?group=explaintest&user=zack", nil)
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.True(t, strings.Contains(resp.Body.String(), `"message":"Classified as office"`))
+	req, _ = http.NewRequest("POST", "/explain", bytes.NewBuffer(dumpFingerprintJSON(fingerprint)))
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.True(t, strings.Contains(resp.Body.String(), `"message":"Classified as office"`))
+	req, _ = http.NewRequest("GET", "/explain?group=explaintest&user=nobody", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.True(t, strings.Contains(resp.Body.String(), `"success":false`))
+
+	// the office is too far to walk to from the kitchen in a second
+	defer resetCache("lastLocationCache")
+	graph := NewLocationGraph()
+	graph.connect("kitchen", "bedroom", 40)
+	graph.connect("bedroom", "office", 40)
+	assert.Nil(t, setLocationGraph("explaintest", graph))
+	r := rand.New(rand.NewSource(3))
+	start := time.Now()
+	trackFingerprint(Fingerprint{Group: "explaintest", Username: "jane", Timestamp: start.UnixNano(), WifiFingerprint: s.scan(r, 2, 2)})
+	trackFingerprint(Fingerprint{Group: "explaintest", Username: "jane", Timestamp: start.Add(time.Second).UnixNano(), WifiFingerprint: s.scan(r, 28, 2)})
+	req, _ = http.NewRequest("GET", "/explain?group=explaintest&user=jane", nil)
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+	assert.True(t, strings.Contains(resp.Body.String(), `"message":"Classified as office, tracked as `))
+	assert.True(t, strings.Contains(resp.Body.String(), `"jump":{"from":"kitchen","guess":"office"`))
+}
+
+// withCutoff returns a copy of ps with the variability cutoff of network n changed
+func withCutoff(ps FullParameters, n string, cutoff float64) FullParameters {
+	priors := ps.Priors[n]
+	special := make(map[string]float64)
+	for k, v := range priors.Special {
+		special[k] = v
+	}
+	special["VarabilityCutoff"] = cutoff
+	priors.Special = special
+	ps.Priors = map[string]PriorParameters{n: priors}
+	return ps
+}
+
+func dumpFingerprintJSON(fingerprint Fingerprint) []byte {
+	b, _ := fingerprint.MarshalJSON()
+	return b
+}
@@ -8,17 +8,14 @@ package main
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
+	"errors"
 	"math"
 	"strconv"
 
 	"net/http"
-	"path"
 	"strings"
 	"time"
 
-	"github.com/boltdb/bolt"
 	"github.com/gin-gonic/gin"
 )
 
@@ -35,6 +32,7 @@ type Fingerprint struct {
 	Location        string   `json:"location"`
 	Timestamp       int64    `json:"timestamp"`
 	WifiFingerprint []Router `json:"wifi-fingerprint"`
+	ID              string   `json:"id,omitempty"` // optional client-supplied ID, used to ignore retried uploads
 }
 
 // Router is the router information for each invdividual mac address
@@ -60,14 +58,18 @@ var jsonExample = `{
 func dumpFingerprint(res Fingerprint) []byte {
 	dumped, _ := res.MarshalJSON()
 	//dumped, _ := json.Marshal(res)
-	return compressByte(dumped)
+	return fingerprintSchema.encode(dumped)
 }
 
 // compression 30 us -> 600 us
 func loadFingerprint(jsonByte []byte) Fingerprint {
 	res := Fingerprint{}
 	//json.Unmarshal(decompressByte(jsonByte), res)
-	res.UnmarshalJSON(decompressByte(jsonByte))
+	decoded, err := fingerprintSchema.decode(jsonByte)
+	if err != nil {
+		Warning.Println(err)
+	}
+	res.UnmarshalJSON(decoded)
 	filterFingerprint(&res)
 	return res
 }
@@ -92,6 +94,7 @@ func cleanFingerprint(res *Fingerprint) {
 	res.Group = strings.TrimSpace(strings.ToLower(res.Group))
 	res.Location = strings.TrimSpace(strings.ToLower(res.Location))
 	res.Username = strings.TrimSpace(strings.ToLower(res.Username))
+	res.ID = strings.TrimSpace(res.ID)
 	deleteIndex := -1
 	for r := range res.WifiFingerprint {
 		if res.WifiFingerprint[r].Rssi >= 0 { // https://stackoverflow.com/questions/15797920/how-to-convert-wifi-signal-strength-from-quality-percent-to-rssi-dbm
@@ -107,31 +110,24 @@ func cleanFingerprint(res *Fingerprint) {
 	}
 }
 
+// errDuplicateFingerprint is returned when a fingerprint has already been inserted
+var errDuplicateFingerprint = errors.New("fingerprint already inserted")
+
 func putFingerprintIntoDatabase(res Fingerprint, database string) error {
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, res.Group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
+	inserted, err := putFingerprintsIntoDatabase(res.Group, database, []Fingerprint{res})
+	if err == nil && inserted == 0 {
+		return errDuplicateFingerprint
 	}
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket, err2 := tx.CreateBucketIfNotExists([]byte(database))
-		if err2 != nil {
-			return fmt.Errorf("create bucket: %s", err2)
-		}
-
-		if res.Timestamp == 0 {
-			res.Timestamp = time.Now().UnixNano()
-		}
-		err2 = bucket.Put([]byte(strconv.FormatInt(res.Timestamp, 10)), dumpFingerprint(res))
-		if err2 != nil {
-			return fmt.Errorf("could add to bucket: %s", err2)
-		}
-		return err2
-	})
-	db.Close()
 	return err
 }
 
+// putFingerprintsIntoDatabase inserts a batch of fingerprints into a bucket of
+// a group, keeping any timestamps that were set. Fingerprints that were already
+// inserted are skipped, and the number that were actually inserted is returned.
+func putFingerprintsIntoDatabase(group string, database string, fingerprints []Fingerprint) (int, error) {
+	return storage.PutFingerprints(group, database, fingerprints)
+}
+
 func trackFingerprintPOST(c *gin.Context) {
 	c.Writer.Header().Set("Content-Type", "application/json")
 	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
@@ -162,12 +158,16 @@ func learnFingerprintPOST(c *gin.Context) {
 	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
 	var jsonFingerprint Fingerprint
 	if c.BindJSON(&jsonFingerprint) == nil {
-		message, success := learnFingerprint(jsonFingerprint)
+		message, success, session := learnFingerprintInSession(jsonFingerprint)
 		Debug.Println(message)
 		if !success {
 			Debug.Println(jsonFingerprint)
 		}
-		c.JSON(http.StatusOK, gin.H{"message": message, "success": success})
+		if session != nil {
+			c.JSON(http.StatusOK, gin.H{"message": message, "success": success, "session": session})
+		} else {
+			c.JSON(http.StatusOK, gin.H{"message": message, "success": success})
+		}
 	} else {
 		Warning.Println("Could not bind JSON")
 		c.JSON(http.StatusOK, gin.H{"message": "Could not bind JSON", "success": false})
@@ -175,17 +175,33 @@ func learnFingerprintPOST(c *gin.Context) {
 }
 
 func learnFingerprint(jsonFingerprint Fingerprint) (string, bool) {
+	message, success, _ := learnFingerprintInSession(jsonFingerprint)
+	return message, success
+}
+
+// learnFingerprintInSession learns a fingerprint and counts it towards the
+// learning session of its user (see session.go), returning the session if there is one.
+func learnFingerprintInSession(jsonFingerprint Fingerprint) (string, bool, *LearningSession) {
 	cleanFingerprint(&jsonFingerprint)
 	if len(jsonFingerprint.Group) == 0 {
-		return "Need to define your group name in request, see API", false
+		return "Need to define your group name in request, see API", false, nil
 	}
 	if len(jsonFingerprint.WifiFingerprint) == 0 {
-		return "No fingerprints found to insert, see API", false
+		return "No fingerprints found to insert, see API", false, nil
+	}
+	err := putFingerprintIntoDatabase(jsonFingerprint, "fingerprints")
+	if err == errDuplicateFingerprint {
+		return "Fingerprint already inserted for " + jsonFingerprint.Username + " (" + jsonFingerprint.Group + ") at " + jsonFingerprint.Location, true, nil
 	}
-	putFingerprintIntoDatabase(jsonFingerprint, "fingerprints")
 	go setLearningCache(strings.ToLower(jsonFingerprint.Group), true)
 	message := "Inserted fingerprint containing " + strconv.Itoa(len(jsonFingerprint.WifiFingerprint)) + " APs for " + jsonFingerprint.Username + " (" + jsonFingerprint.Group + ") at " + jsonFingerprint.Location
-	return message, true
+	if session, ok := recordSessionFingerprint(jsonFingerprint); ok {
+		if session.Open && session.Location != jsonFingerprint.Location {
+			return message + ", not counted for the session at " + session.Location, true, &session
+		}
+		return message + ", " + session.String(), true, &session
+	}
+	return message, true, nil
 }
 
 func trackFingerprint(jsonFingerprint Fingerprint) (string, bool, string, map[string]float64, map[string]float64, map[string]float64) {
@@ -222,7 +238,18 @@ func trackFingerprint(jsonFingerprint Fingerprint) (string, bool, string, map[st
 			go appendUserCache(group, jsonFingerprint.Username)
 		}
 	}
-	locationGuess1, bayes := calculatePosterior(jsonFingerprint, *NewFullParameters())
+	group, user := strings.ToLower(jsonFingerprint.Group), strings.ToLower(jsonFingerprint.Username)
+	ps, _ := openParameters(group)
+	locationGuess1, bayes := calculatePosteriorWithPrior(jsonFingerprint, ps, userLocationPrior(jsonFingerprint, ps))
+	// the shadow classifies without jump penalties, so it is compared to the guess before them
+	shadowGuess := locationGuess1
+	trackedAt := jsonFingerprint.Timestamp
+	if trackedAt == 0 {
+		trackedAt = time.Now().UnixNano()
+		fullFingerprint.Timestamp = trackedAt
+	}
+	locationGuess1, bayes, jump := penalizeJumps(group, user, trackedAt, bayes)
+	setLastLocationCache(group, user, lastLocation{Location: locationGuess1, Timestamp: trackedAt, Jump: jump})
 	percentGuess1 := float64(0)
 	total := float64(0)
 	for _, locBayes := range bayes {
@@ -234,6 +261,7 @@ func trackFingerprint(jsonFingerprint Fingerprint) (string, bool, string, map[st
 	percentGuess1 = math.Exp(bayes[locationGuess1]) / total * 100.0
 
 	jsonFingerprint.Location = locationGuess1
+	go shadowClassify(jsonFingerprint, fullFingerprint.Location, shadowGuess)
 
 	// Insert full fingerprint
 	putFingerprintIntoDatabase(fullFingerprint, "fingerprints-track")
@@ -0,0 +1,269 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// fingerprintBinary.go contains the compact binary encoding of stored
+// fingerprints, which refers to MACs by their index in a dictionary kept per group.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+)
+
+func init() {
+	registerResources("macDictionary")
+}
+
+// binaryMarker starts fingerprints stored in the binary encoding and is
+// followed by the schema version. Like schemaMarker it can not start a deflate stream.
+const binaryMarker = 0xff
+
+// Field numbers of the binary encoding, which uses the protobuf wire format
+const (
+	binaryFieldGroup     = 1
+	binaryFieldUsername  = 2
+	binaryFieldLocation  = 3
+	binaryFieldTimestamp = 4
+	binaryFieldID        = 5
+	binaryFieldRouters   = 6 // packed pairs of MAC index and zigzag RSSI
+)
+
+// macDictionary assigns each MAC of a group a permanent index. MACs are only
+// ever appended, so that stored fingerprints keep referring to the same MACs.
+type macDictionary struct {
+	macs    []string
+	index   map[string]int
+	changed bool
+}
+
+// newMacDictionary loads the dictionary stored in the macDictionary resource.
+func newMacDictionary(stored []byte) *macDictionary {
+	d := &macDictionary{index: make(map[string]int)}
+	if len(stored) > 0 {
+		d.macs = strings.Split(string(stored), "\n")
+		for i, mac := range d.macs {
+			d.index[mac] = i
+		}
+	}
+	return d
+}
+
+// id returns the index of mac, adding it if it is new.
+func (d *macDictionary) id(mac string) int {
+	if i, ok := d.index[mac]; ok {
+		return i
+	}
+	d.index[mac] = len(d.macs)
+	d.macs = append(d.macs, mac)
+	d.changed = true
+	return d.index[mac]
+}
+
+// bytes returns the dictionary as it is stored.
+func (d *macDictionary) bytes() []byte {
+	return []byte(strings.Join(d.macs, "\n"))
+}
+
+// fingerprintCodec dumps and loads the fingerprints of one group, in the
+// encoding set by the -encoding flag when dumping and in any encoding when loading.
+type fingerprintCodec struct {
+	dictionary *macDictionary
+}
+
+func newFingerprintCodec(dictionary []byte) *fingerprintCodec {
+	return &fingerprintCodec{dictionary: newMacDictionary(dictionary)}
+}
+
+func (c *fingerprintCodec) dump(res Fingerprint) []byte {
+	if RuntimeArgs.Encoding == "binary" {
+		return c.encodeBinary(res)
+	}
+	return dumpFingerprint(res)
+}
+
+// load decodes and filters a stored fingerprint.
+func (c *fingerprintCodec) load(v []byte) Fingerprint {
+	if !isBinaryFingerprint(v) {
+		return loadFingerprint(v)
+	}
+	res, err := c.decodeBinary(v)
+	if err != nil {
+		Warning.Println(err)
+	}
+	filterFingerprint(&res)
+	return res
+}
+
+// convert returns a stored fingerprint in the current encoding and schema
+// version, or false if it already is.
+func (c *fingerprintCodec) convert(v []byte) ([]byte, bool, error) {
+	binary := isBinaryFingerprint(v)
+	version := recordVersion(v)
+	if binary {
+		version = int(v[1])
+	}
+	if binary == (RuntimeArgs.Encoding == "binary") && version == fingerprintSchema.version {
+		return v, false, nil
+	}
+	res, err := c.decode(v)
+	if err != nil {
+		return v, false, err
+	}
+	return c.dump(res), true, nil
+}
+
+// decode returns a stored fingerprint in any encoding, without filtering it.
+func (c *fingerprintCodec) decode(v []byte) (Fingerprint, error) {
+	if isBinaryFingerprint(v) {
+		return c.decodeBinary(v)
+	}
+	var res Fingerprint
+	jsonByte, err := fingerprintSchema.decode(v)
+	if err == nil {
+		err = res.UnmarshalJSON(jsonByte)
+	}
+	return res, err
+}
+
+func isBinaryFingerprint(v []byte) bool {
+	return len(v) >= 2 && v[0] == binaryMarker
+}
+
+func (c *fingerprintCodec) encodeBinary(res Fingerprint) []byte {
+	buf := proto.NewBuffer([]byte{binaryMarker, byte(fingerprintSchema.version)})
+	for _, field := range []struct {
+		num   uint64
+		value string
+	}{
+		{binaryFieldGroup, res.Group},
+		{binaryFieldUsername, res.Username},
+		{binaryFieldLocation, res.Location},
+		{binaryFieldID, res.ID},
+	} {
+		if len(field.value) > 0 {
+			buf.EncodeVarint(field.num<<3 | proto.WireBytes)
+			buf.EncodeStringBytes(field.value)
+		}
+	}
+	buf.EncodeVarint(binaryFieldTimestamp<<3 | proto.WireVarint)
+	buf.EncodeVarint(uint64(res.Timestamp))
+
+	routers := proto.NewBuffer(nil)
+	for _, router := range res.WifiFingerprint {
+		routers.EncodeVarint(uint64(c.dictionary.id(router.Mac)))
+		routers.EncodeZigzag64(uint64(router.Rssi))
+	}
+	buf.EncodeVarint(binaryFieldRouters<<3 | proto.WireBytes)
+	buf.EncodeRawBytes(routers.Bytes())
+	return buf.Bytes()
+}
+
+func (c *fingerprintCodec) decodeBinary(v []byte) (Fingerprint, error) {
+	res := Fingerprint{}
+	if len(v) < 2 {
+		return res, fmt.Errorf("binary fingerprint is missing its version")
+	}
+	version := int(v[1])
+	buf := &binaryDecoder{buf: v[2:]}
+	for len(buf.buf) > 0 {
+		tag, err := buf.varint()
+		if err != nil {
+			return res, err
+		}
+		switch tag >> 3 {
+		case binaryFieldGroup:
+			res.Group, err = buf.str()
+		case binaryFieldUsername:
+			res.Username, err = buf.str()
+		case binaryFieldLocation:
+			res.Location, err = buf.str()
+		case binaryFieldID:
+			res.ID, err = buf.str()
+		case binaryFieldTimestamp:
+			var timestamp uint64
+			timestamp, err = buf.varint()
+			res.Timestamp = int64(timestamp)
+		case binaryFieldRouters:
+			var routers []byte
+			routers, err = buf.bytes()
+			if err == nil {
+				res.WifiFingerprint, err = c.decodeRouters(routers)
+			}
+		default:
+			err = fmt.Errorf("unknown field %d in binary fingerprint", tag>>3)
+		}
+		if err != nil {
+			return res, err
+		}
+	}
+	if version < fingerprintSchema.version {
+		jsonByte, _ := res.MarshalJSON()
+		jsonByte, err := fingerprintSchema.upgrade(jsonByte, version)
+		if err != nil {
+			return res, err
+		}
+		res = Fingerprint{}
+		err = res.UnmarshalJSON(jsonByte)
+		return res, err
+	}
+	return res, nil
+}
+
+func (c *fingerprintCodec) decodeRouters(routers []byte) ([]Router, error) {
+	result := []Router{}
+	buf := &binaryDecoder{buf: routers}
+	for len(buf.buf) > 0 {
+		id, err := buf.varint()
+		if err != nil {
+			return result, err
+		}
+		rssi, err := buf.varint()
+		if err != nil {
+			return result, err
+		}
+		if int(id) >= len(c.dictionary.macs) {
+			return result, fmt.Errorf("MAC %d is not in the dictionary", id)
+		}
+		result = append(result, Router{Mac: c.dictionary.macs[id], Rssi: int(int64(rssi>>1) ^ -int64(rssi&1))})
+	}
+	return result, nil
+}
+
+// binaryDecoder reads the protobuf wire format from buf, which only ever
+// shrinks, so that decoding stops exactly when every byte is consumed and a
+// truncated fingerprint is an error instead of a shorter fingerprint.
+type binaryDecoder struct {
+	buf []byte
+}
+
+func (d *binaryDecoder) varint() (uint64, error) {
+	x, n := proto.DecodeVarint(d.buf)
+	if n == 0 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	d.buf = d.buf[n:]
+	return x, nil
+}
+
+func (d *binaryDecoder) bytes() ([]byte, error) {
+	n, err := d.varint()
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(d.buf)) < n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *binaryDecoder) str() (string, error) {
+	b, err := d.bytes()
+	return string(b), err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBinaryFingerprint(t *testing.T) {
+	res := Fingerprint{Group: "binarytest", Username: "zack", Location: "kitchen", Timestamp: 1439596533831000000, ID: "a1",
+		WifiFingerprint: []Router{{Mac: "80:37:73:ba:f7:d8", Rssi: -45}, {Mac: "80:37:73:ba:f7:dc", Rssi: -58}}}
+	codec := newFingerprintCodec(nil)
+	encoded := codec.encodeBinary(res)
+	assert.True(t, isBinaryFingerprint(encoded))
+	assert.True(t, codec.dictionary.changed)
+	assert.True(t, len(encoded) < len(dumpFingerprint(res)))
+
+	// a new codec only knows the MACs through the stored dictionary
+	decoded, err := newFingerprintCodec(codec.dictionary.bytes()).decodeBinary(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, res, decoded)
+	_, err = newFingerprintCodec(nil).decodeBinary(encoded)
+	assert.NotNil(t, err)
+
+	// truncated fingerprints are errors, not shorter fingerprints
+	for _, truncated := range [][]byte{encoded[:1], encoded[:len(encoded)-1], encoded[:len(encoded)/2]} {
+		_, err = codec.decodeBinary(truncated)
+		assert.NotNil(t, err)
+	}
+}
+
+func TestBinaryEncodingStore(t *testing.T) {
+	defer useMemoryStore()()
+	defer func() { RuntimeArgs.Encoding = "" }()
+
+	storage.PutFingerprints("binarytest", "fingerprints", []Fingerprint{{Group: "binarytest", Location: "kitchen", Timestamp: 1, WifiFingerprint: []Router{{Mac: "aa", Rssi: -40}}}})
+	RuntimeArgs.Encoding = "binary"
+	storage.PutFingerprints("binarytest", "fingerprints", []Fingerprint{{Group: "binarytest", Location: "office", Timestamp: 2, WifiFingerprint: []Router{{Mac: "bb", Rssi: -60}}}})
+
+	binary := 0
+	storage.ForEachRecord("binarytest", "fingerprints", func(k string, v []byte) bool {
+		if isBinaryFingerprint(v) {
+			binary++
+		}
+		return true
+	})
+	assert.Equal(t, 1, binary)
+	assert.Equal(t, []string{"kitchen", "office"}, getUniqueLocations("binarytest"))
+
+	report := migrateGroup("binarytest")
+	assert.Equal(t, 1, report.Migrated["fingerprints"])
+	storage.ForEachRecord("binarytest", "fingerprints", func(k string, v []byte) bool {
+		assert.True(t, isBinaryFingerprint(v))
+		return true
+	})
+	fingerprints, _, _ := getFingerprintsInMemory("binarytest")
+	assert.Equal(t, "aa", fingerprints["1"].WifiFingerprint[0].Mac)
+	assert.Equal(t, "bb", fingerprints["2"].WifiFingerprint[0].Mac)
+}
@@ -61,6 +61,10 @@ func (mj *Fingerprint) MarshalJSONBuf(buf fflib.EncodingBuffer) error {
 	} else {
 		buf.WriteString(`null`)
 	}
+	if len(mj.ID) != 0 {
+		buf.WriteString(`,"id":`)
+		fflib.WriteJsonString(buf, string(mj.ID))
+	}
 	buf.WriteByte('}')
 	return nil
 }
@@ -78,6 +82,8 @@ const (
 	ffj_t_Fingerprint_Timestamp
 
 	ffj_t_Fingerprint_WifiFingerprint
+
+	ffj_t_Fingerprint_ID
 )
 
 var ffj_key_Fingerprint_Group = []byte("group")
@@ -90,6 +96,8 @@ var ffj_key_Fingerprint_Timestamp = []byte("timestamp")
 
 var ffj_key_Fingerprint_WifiFingerprint = []byte("wifi-fingerprint")
 
+var ffj_key_Fingerprint_ID = []byte("id")
+
 func (uj *Fingerprint) UnmarshalJSON(input []byte) error {
 	fs := fflib.NewFFLexer(input)
 	return uj.UnmarshalJSONFFLexer(fs, fflib.FFParse_map_start)
@@ -157,6 +165,14 @@ mainparse:
 						goto mainparse
 					}
 
+				case 'i':
+
+					if bytes.Equal(ffj_key_Fingerprint_ID, kn) {
+						currentKey = ffj_t_Fingerprint_ID
+						state = fflib.FFParse_want_colon
+						goto mainparse
+					}
+
 				case 'l':
 
 					if bytes.Equal(ffj_key_Fingerprint_Location, kn) {
@@ -191,6 +207,12 @@ mainparse:
 
 				}
 
+				if fflib.SimpleLetterEqualFold(ffj_key_Fingerprint_ID, kn) {
+					currentKey = ffj_t_Fingerprint_ID
+					state = fflib.FFParse_want_colon
+					goto mainparse
+				}
+
 				if fflib.AsciiEqualFold(ffj_key_Fingerprint_WifiFingerprint, kn) {
 					currentKey = ffj_t_Fingerprint_WifiFingerprint
 					state = fflib.FFParse_want_colon
@@ -253,6 +275,9 @@ mainparse:
 				case ffj_t_Fingerprint_WifiFingerprint:
 					goto handle_WifiFingerprint
 
+				case ffj_t_Fingerprint_ID:
+					goto handle_ID
+
 				case ffj_t_Fingerprintno_such_key:
 					err = fs.SkipField(tok)
 					if err != nil {
@@ -443,6 +468,32 @@ handle_WifiFingerprint:
 	state = fflib.FFParse_after_value
 	goto mainparse
 
+handle_ID:
+
+	/* handler: uj.ID type=string kind=string quoted=false*/
+
+	{
+
+		{
+			if tok != fflib.FFTok_string && tok != fflib.FFTok_null {
+				return fs.WrapErr(fmt.Errorf("cannot unmarshal %s into Go value for string", tok))
+			}
+		}
+
+		if tok == fflib.FFTok_null {
+
+		} else {
+
+			outBuf := fs.Output.Bytes()
+
+			uj.ID = string(string(outBuf))
+
+		}
+	}
+
+	state = fflib.FFParse_after_value
+	goto mainparse
+
 wantedvalue:
 	return fs.WrapErr(fmt.Errorf("wanted value token, but got token: %v", tok))
 wrongtokenerror:
@@ -174,6 +174,37 @@ func TestLearnFingerprint(t *testing.T) {
 	assert.Equal(t, strings.TrimSpace(message), "Inserted fingerprint containing 18 APs for zack (find) at zakhome floor 2 office")
 }
 
+func TestPutFingerprintIntoDatabaseCollisions(t *testing.T) {
+	os.Remove(path.Join(RuntimeArgs.SourcePath, "testdbkeys.db"))
+	defer os.Remove(path.Join(RuntimeArgs.SourcePath, "testdbkeys.db"))
+	res := Fingerprint{Group: "testdbkeys", Username: "zack", Location: "kitchen", Timestamp: 1439596533831000000, WifiFingerprint: []Router{{Mac: "80:37:73:ba:f7:d8", Rssi: -45}}}
+	assert.Nil(t, putFingerprintIntoDatabase(res, "fingerprints"))
+	// a retried upload is ignored
+	assert.Equal(t, errDuplicateFingerprint, putFingerprintIntoDatabase(res, "fingerprints"))
+	// a different fingerprint with the same timestamp is kept
+	res.Location = "bedroom"
+	assert.Nil(t, putFingerprintIntoDatabase(res, "fingerprints"))
+	// a retried upload with an ID is ignored even if the timestamp changed
+	res.ID = "abc"
+	res.Timestamp = 0
+	assert.Nil(t, putFingerprintIntoDatabase(res, "fingerprints"))
+	assert.Equal(t, errDuplicateFingerprint, putFingerprintIntoDatabase(res, "fingerprints"))
+
+	var keys []string
+	db, _ := bolt.Open(path.Join(RuntimeArgs.SourcePath, "testdbkeys.db"), 0600, nil)
+	db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte("fingerprints")).Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			keys = append(keys, string(k))
+		}
+		return nil
+	})
+	db.Close()
+	assert.Equal(t, 3, len(keys))
+	assert.Equal(t, "1439596533831000000", keys[0])
+	assert.Equal(t, "1439596533831000001", keys[1])
+}
+
 func TestTrackFingerprintPOST(t *testing.T) {
 	jsonTest := `{"username": "zack", "group": "Find", "wifi-fingerprint": [{"rssi": -45, "mac": "80:37:73:ba:f7:d8"}, {"rssi": -58, "mac": "80:37:73:ba:f7:dc"}, {"rssi": -61, "mac": "a0:63:91:2b:9e:65"}, {"rssi": -68, "mac": "a0:63:91:2b:9e:64"}, {"rssi": -70, "mac": "70:73:cb:bd:9f:b5"}, {"rssi": -75, "mac": "d4:05:98:57:b3:10"}, {"rssi": -75, "mac": "00:23:69:d4:47:9f"}, {"rssi": -76, "mac": "30:46:9a:a0:28:c4"}, {"rssi": -81, "mac": "2c:b0:5d:36:e3:b8"}, {"rssi": -82, "mac": "00:1a:1e:46:cd:10"}, {"rssi": -82, "mac": "20:aa:4b:b8:31:c8"}, {"rssi": -83, "mac": "e8:ed:05:55:21:10"}, {"rssi": -83, "mac": "ec:1a:59:4a:9c:ed"}, {"rssi": -88, "mac": "b8:3e:59:78:35:99"}, {"rssi": -84, "mac": "e0:46:9a:6d:02:ea"}, {"rssi": -84, "mac": "00:1a:1e:46:cd:11"}, {"rssi": -84, "mac": "f8:35:dd:0a:da:be"}, {"rssi": -84, "mac": "b4:75:0e:03:cd:69"}], "location": "zakhome floor 2 office", "time": 1439596533831, "password": "frusciante_0128"}`
 
@@ -0,0 +1,351 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// graph.go keeps which locations of a group connect, through doors or stairs,
+// and how far apart they are. Tracking uses it to penalise jumps that cannot
+// be walked in the time since the last fingerprint, and /route uses it to find
+// the shortest way from where a user is to another location.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	registerResources("locationGraph")
+}
+
+// LocationEdge connects two locations both ways
+type LocationEdge struct {
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Distance float64 `json:"distance"` // in meters
+}
+
+// LocationGraph is the map of a group
+type LocationGraph struct {
+	Edges   []LocationEdge `json:"edges"`
+	Speed   float64        `json:"speed"`   // fastest a user moves, in meters per second
+	Penalty float64        `json:"penalty"` // subtracted from the score of a location that cannot be reached
+}
+
+// NewLocationGraph returns the graph of a group that has not defined one
+func NewLocationGraph() LocationGraph {
+	return LocationGraph{Edges: []LocationEdge{}, Speed: 2, Penalty: 3}
+}
+
+// neighbors returns the locations connected to each location and their distance
+func (g LocationGraph) neighbors() map[string]map[string]float64 {
+	neighbors := make(map[string]map[string]float64)
+	for _, edge := range g.Edges {
+		for _, pair := range [][2]string{{edge.From, edge.To}, {edge.To, edge.From}} {
+			if _, ok := neighbors[pair[0]]; !ok {
+				neighbors[pair[0]] = make(map[string]float64)
+			}
+			neighbors[pair[0]][pair[1]] = edge.Distance
+		}
+	}
+	return neighbors
+}
+
+// shortestPaths returns the distance from location to every location it
+// connects to, and the location before each on the shortest path.
+func (g LocationGraph) shortestPaths(location string) (map[string]float64, map[string]string) {
+	neighbors := g.neighbors()
+	distance := map[string]float64{location: 0}
+	previous := make(map[string]string)
+	done := make(map[string]bool)
+	for {
+		current, best := "", math.Inf(1)
+		for loc, d := range distance {
+			if !done[loc] && (d < best || (d == best && loc < current)) {
+				current, best = loc, d
+			}
+		}
+		if len(current) == 0 {
+			return distance, previous
+		}
+		done[current] = true
+		for next, d := range neighbors[current] {
+			if old, ok := distance[next]; !ok || best+d < old {
+				distance[next] = best + d
+				previous[next] = current
+			}
+		}
+	}
+}
+
+// route returns the locations on the shortest way from one location to another
+// and its length, or false if they do not connect.
+func (g LocationGraph) route(from string, to string) ([]string, float64, bool) {
+	distance, previous := g.shortestPaths(from)
+	d, ok := distance[to]
+	if !ok {
+		return nil, 0, false
+	}
+	path := []string{to}
+	for loc := to; loc != from; {
+		loc = previous[loc]
+		path = append([]string{loc}, path...)
+	}
+	return path, d, true
+}
+
+func getLocationGraph(group string) LocationGraph {
+	if cached, ok := getLocationGraphCache(group); ok {
+		cached.Edges = append([]LocationEdge{}, cached.Edges...)
+		return cached
+	}
+	graph := NewLocationGraph()
+	v, err := storage.GetResource(group, "locationGraph")
+	if err == nil && len(v) > 0 {
+		json.Unmarshal(v, &graph)
+	}
+	setLocationGraphCache(group, graph)
+	return graph
+}
+
+func setLocationGraph(group string, graph LocationGraph) error {
+	if graph.Speed <= 0 {
+		return fmt.Errorf("speed must be more than 0")
+	}
+	if graph.Penalty < 0 {
+		return fmt.Errorf("penalty must not be negative")
+	}
+	for _, edge := range graph.Edges {
+		if edge.Distance <= 0 {
+			return fmt.Errorf("distance from %s to %s must be more than 0", edge.From, edge.To)
+		}
+		if edge.From == edge.To {
+			return fmt.Errorf("%s cannot connect to itself", edge.From)
+		}
+	}
+	jsonByte, _ := json.Marshal(graph)
+	err := storage.PutResources(group, map[string][]byte{"locationGraph": jsonByte})
+	deleteLocationGraphCache(group)
+	return err
+}
+
+// connect adds the edge between from and to, or changes its distance
+func (g *LocationGraph) connect(from string, to string, distance float64) {
+	for i, edge := range g.Edges {
+		if (edge.From == from && edge.To == to) || (edge.From == to && edge.To == from) {
+			g.Edges[i].Distance = distance
+			return
+		}
+	}
+	g.Edges = append(g.Edges, LocationEdge{From: from, To: to, Distance: distance})
+}
+
+// disconnect removes the edge between from and to and returns whether there was one
+func (g *LocationGraph) disconnect(from string, to string) bool {
+	for i, edge := range g.Edges {
+		if (edge.From == from && edge.To == to) || (edge.From == to && edge.To == from) {
+			g.Edges = append(g.Edges[:i], g.Edges[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// lastLocation is where a user was last tracked
+type lastLocation struct {
+	Location  string
+	Timestamp int64
+	Jump      *JumpPenalty // nil if no location was penalized
+}
+
+// JumpPenalty is how tracking penalized the locations a user could not have walked to
+type JumpPenalty struct {
+	From      string   `json:"from"`      // where the user was last tracked
+	Guess     string   `json:"guess"`     // the best location before the penalty
+	Tracked   string   `json:"tracked"`   // the best location after it
+	Penalty   float64  `json:"penalty"`   // subtracted from the posterior of each location
+	Locations []string `json:"locations"` // penalized locations, sorted
+}
+
+// penalizeJumps lowers the posterior of the locations that user could not have
+// walked to, by the graph of group, since they were last tracked. Locations
+// next to the last one are always reachable, and locations that are not in the
+// graph are not changed, and nothing is penalized if no time has passed, as
+// when fingerprints arrive out of order. It returns the new best location, and
+// what was penalized or nil if nothing was.
+func penalizeJumps(group string, user string, timestamp int64, bayes map[string]float64) (string, map[string]float64, *JumpPenalty) {
+	graph := getLocationGraph(group)
+	neighbors := graph.neighbors()
+	last, ok := getLastLocationCache(group, user)
+	elapsed := time.Duration(timestamp - last.Timestamp).Seconds()
+	jump := &JumpPenalty{From: last.Location, Guess: bestPosterior(bayes), Penalty: graph.Penalty, Locations: []string{}}
+	if ok && elapsed > 0 && neighbors[last.Location] != nil {
+		distance, _ := graph.shortestPaths(last.Location)
+		for loc := range bayes {
+			if loc == last.Location || neighbors[loc] == nil {
+				continue
+			}
+			if _, adjacent := neighbors[last.Location][loc]; adjacent {
+				continue
+			}
+			if d, connected := distance[loc]; !connected || d > graph.Speed*elapsed {
+				bayes[loc] -= graph.Penalty
+				jump.Locations = append(jump.Locations, loc)
+			}
+		}
+	}
+	if len(jump.Locations) == 0 {
+		return bestPosterior(bayes), bayes, nil
+	}
+	sort.Strings(jump.Locations)
+	jump.Tracked = bestPosterior(bayes)
+	return jump.Tracked, bayes, jump
+}
+
+// bestPosterior returns the location with the highest posterior, the first by
+// name of any that are tied.
+func bestPosterior(bayes map[string]float64) string {
+	best, bestVal := "", math.Inf(-1)
+	for loc, val := range bayes {
+		if val > bestVal || (val == bestVal && loc < best) {
+			best, bestVal = loc, val
+		}
+	}
+	return best
+}
+
+// getGraph usage: curl "http://localhost:8003/graph?group=X"
+func getGraph(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	graph := getLocationGraph(group)
+	c.JSON(http.StatusOK, gin.H{"message": "Found " + strconv.Itoa(len(graph.Edges)) + " connections", "success": true, "graph": graph})
+}
+
+// putGraph usage: curl -X PUT "http://localhost:8003/graph?group=X&from=kitchen&to=hallway&distance=4"
+// to connect two learned locations, and/or curl -X PUT "http://localhost:8003/graph?group=X&speed=2&penalty=3"
+func putGraph(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	graph := getLocationGraph(group)
+	var err error
+	for key, value := range map[string]*float64{"speed": &graph.Speed, "penalty": &graph.Penalty} {
+		if v := c.Query(key); len(v) > 0 && err == nil {
+			*value, err = strconv.ParseFloat(v, 64)
+		}
+	}
+	from := strings.TrimSpace(strings.ToLower(c.Query("from")))
+	to := strings.TrimSpace(strings.ToLower(c.Query("to")))
+	if len(from) > 0 || len(to) > 0 {
+		ps, _ := openParameters(group)
+		var distance float64
+		if !stringInSlice(from, ps.UniqueLocs) || !stringInSlice(to, ps.UniqueLocs) {
+			err = fmt.Errorf("from and to must be learned locations")
+		} else if distance, err = strconv.ParseFloat(c.Query("distance"), 64); err == nil {
+			graph.connect(from, to, distance)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	if err = setLocationGraph(group, graph); err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Saved the graph of " + group, "success": true, "graph": graph})
+}
+
+// deleteGraph usage: curl -X DELETE "http://localhost:8003/graph?group=X&from=kitchen&to=hallway"
+func deleteGraph(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	from := strings.TrimSpace(strings.ToLower(c.Query("from")))
+	to := strings.TrimSpace(strings.ToLower(c.Query("to")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	graph := getLocationGraph(group)
+	if !graph.disconnect(from, to) {
+		c.JSON(http.StatusOK, gin.H{"message": from + " and " + to + " are not connected", "success": false})
+		return
+	}
+	if err := setLocationGraph(group, graph); err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Disconnected " + from + " and " + to, "success": true, "graph": graph})
+}
+
+// getRoute usage: curl "http://localhost:8003/route?group=X&user=Y&to=kitchen"
+// The way starts at the current location of the user, or at from=Z if it is given.
+func getRoute(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	user := strings.TrimSpace(strings.ToLower(c.DefaultQuery("user", "noneasdf")))
+	from := strings.TrimSpace(strings.ToLower(c.Query("from")))
+	to := strings.TrimSpace(strings.ToLower(c.Query("to")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	if len(from) == 0 {
+		if user == "noneasdf" {
+			c.JSON(http.StatusOK, gin.H{"message": "You need to specify user or from", "success": false})
+			return
+		}
+		from, _ = getCurrentPositionOfUser(group, user).Location.(string)
+		if len(from) == 0 {
+			c.JSON(http.StatusOK, gin.H{"message": "Could not find where " + user + " is", "success": false})
+			return
+		}
+	}
+	path, distance, ok := getLocationGraph(group).route(from, to)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"message": "There is no way from " + from + " to " + to, "success": false})
+		return
+	}
+	message := fmt.Sprintf("%s to %s is %2.1f m through %d locations", from, to, distance, len(path))
+	c.JSON(http.StatusOK, gin.H{"message": message, "success": true, "from": from, "to": to, "path": path, "distance": distance})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocationGraph(t *testing.T) {
+	defer useMemoryStore()()
+	defer resetCache("lastLocationCache")
+
+	graph := NewLocationGraph()
+	graph.connect("kitchen", "hallway", 4)
+	graph.connect("hallway", "office", 5)
+	graph.connect("bedroom", "hallway", 6)
+	graph.connect("office", "bedroom", 20)
+	graph.connect("office", "hallway", 5) // the same edge
+	assert.Equal(t, 4, len(graph.Edges))
+	assert.Nil(t, setLocationGraph("graphtest", graph))
+
+	graph = getLocationGraph("graphtest")
+	path, distance, ok := graph.route("kitchen", "bedroom")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"kitchen", "hallway", "bedroom"}, path)
+	assert.Equal(t, float64(10), distance)
+	path, distance, ok = graph.route("office", "office")
+	assert.True(t, ok)
+	assert.Equal(t, []string{"office"}, path)
+	assert.Equal(t, float64(0), distance)
+	_, _, ok = graph.route("kitchen", "garage")
+	assert.False(t, ok)
+
+	// the office is 9 m from the kitchen, too far to walk in a second
+	start := time.Now()
+	bayes := func() map[string]float64 {
+		return map[string]float64{"kitchen": 0.1, "hallway": 0, "office": 0.5, "bedroom": -1, "garage": 0.2}
+	}
+	best, _, jump := penalizeJumps("graphtest", "zack", start.UnixNano(), bayes())
+	assert.Equal(t, "office", best)
+	assert.Nil(t, jump)
+	setLastLocationCache("graphtest", "zack", lastLocation{Location: "kitchen", Timestamp: start.UnixNano()})
+	best, penalized, jump := penalizeJumps("graphtest", "zack", start.Add(time.Second).UnixNano(), bayes())
+	assert.Equal(t, "garage", best)
+	assert.Equal(t, &JumpPenalty{From: "kitchen", Guess: "office", Tracked: "garage", Penalty: graph.Penalty, Locations: []string{"bedroom", "office"}}, jump)
+	assert.Equal(t, 0.5-graph.Penalty, penalized["office"])
+	assert.Equal(t, float64(0), penalized["hallway"])
+	best, _, _ = penalizeJumps("graphtest", "zack", start.Add(10*time.Second).UnixNano(), bayes())
+	assert.Equal(t, "office", best)
+	// a fingerprint from before the last one is not penalized
+	best, _, _ = penalizeJumps("graphtest", "zack", start.Add(-time.Second).UnixNano(), bayes())
+	assert.Equal(t, "office", best)
+	best, _, _ = penalizeJumps("graphtest", "zack", start.UnixNano(), bayes())
+	assert.Equal(t, "office", best)
+
+	assert.True(t, graph.disconnect("hallway", "kitchen"))
+	assert.False(t, graph.disconnect("hallway", "kitchen"))
+	assert.Equal(t, 4, len(getLocationGraph("graphtest").Edges))
+	graph.connect("kitchen", "kitchen", 1)
+	assert.NotNil(t, setLocationGraph("graphtest", graph))
+	graph = NewLocationGraph()
+	graph.connect("kitchen", "hallway", 0)
+	assert.NotNil(t, setLocationGraph("graphtest", graph))
+}
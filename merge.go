@@ -0,0 +1,220 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// merge.go contains merging the fingerprints of one group into another.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MergeRequest describes merging the group From into the group To
+type MergeRequest struct {
+	From      string            `json:"from"`
+	To        string            `json:"to"`
+	Locations map[string]string `json:"locations"` // old location name -> new location name
+	Users     map[string]string `json:"users"`     // old username -> new username
+	DryRun    bool              `json:"dryrun"`
+}
+
+// MergeReport describes what a merge changed, or would change for a dry run
+type MergeReport struct {
+	From             string         `json:"from"`
+	To               string         `json:"to"`
+	DryRun           bool           `json:"dryrun"`
+	Inserted         map[string]int `json:"inserted"`
+	Duplicates       map[string]int `json:"duplicates"`
+	RenamedLocations int            `json:"renamed_locations"`
+	RenamedUsers     int            `json:"renamed_users"`
+	NewLocations     []string       `json:"new_locations"`
+	NewUsers         []string       `json:"new_users"`
+}
+
+// mergeDatabasePOST usage: curl -X POST -d '{"from":"X","to":"Y","locations":{"old":"new"},"dryrun":true}' http://localhost:8003/database/merge
+func mergeDatabasePOST(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "POST")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var request MergeRequest
+	if c.BindJSON(&request) != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not bind JSON - did you not send it as a JSON?", "success": false})
+		return
+	}
+	report, err := mergeGroups(request)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": mergeMessage(report), "success": true, "report": report})
+}
+
+func mergeMessage(report MergeReport) string {
+	message := "Merged "
+	if report.DryRun {
+		message = "Would merge "
+	}
+	return message + strconv.Itoa(report.Inserted["fingerprints"]) + " learned and " +
+		strconv.Itoa(report.Inserted["fingerprints-track"]) + " tracked fingerprints from " +
+		report.From + " into " + report.To + ", skipping " +
+		strconv.Itoa(report.Duplicates["fingerprints"]+report.Duplicates["fingerprints-track"]) + " duplicates"
+}
+
+// mergeGroups copies the fingerprints of request.From into request.To, as they
+// are stored, renaming locations and users and skipping fingerprints that To
+// already has.
+// If anything was learned the priors of To are recalculated.
+func mergeGroups(request MergeRequest) (MergeReport, error) {
+	from := strings.TrimSpace(strings.ToLower(request.From))
+	to := strings.TrimSpace(strings.ToLower(request.To))
+	report := MergeReport{
+		From:         from,
+		To:           to,
+		DryRun:       request.DryRun,
+		Inserted:     make(map[string]int),
+		Duplicates:   make(map[string]int),
+		NewLocations: []string{},
+		NewUsers:     []string{},
+	}
+	if len(from) == 0 || len(to) == 0 {
+		return report, fmt.Errorf("need to define from and to")
+	}
+	if from == to {
+		return report, fmt.Errorf("can't merge %s into itself", from)
+	}
+	if !storage.GroupExists(from) {
+		return report, fmt.Errorf("can't merge from %s, it does not exist", from)
+	}
+	locations := lowerMap(request.Locations)
+	users := lowerMap(request.Users)
+	toExists := storage.GroupExists(to)
+
+	knownLocations := []string{}
+	knownUsers := []string{}
+	if toExists {
+		knownLocations = getUniqueLocations(to)
+		knownUsers = getUsers(to)
+	}
+
+	for _, bucket := range []string{"fingerprints", "fingerprints-track"} {
+		existing := make(map[string]bool)
+		ids := make(map[string]bool)
+		if toExists {
+			forEachStoredFingerprint(to, bucket, func(k string, v Fingerprint) bool {
+				existing[mergeSignature(k, v)] = true
+				if len(v.ID) > 0 {
+					ids[v.ID] = true
+				}
+				return true
+			})
+		}
+
+		toInsert := []Fingerprint{}
+		forEachStoredFingerprint(from, bucket, func(k string, v Fingerprint) bool {
+			newLocation, renamedLocation := locations[v.Location]
+			if renamedLocation = renamedLocation && len(v.Location) > 0; renamedLocation {
+				v.Location = newLocation
+			}
+			newUser, renamedUser := users[v.Username]
+			if renamedUser = renamedUser && len(v.Username) > 0; renamedUser {
+				v.Username = newUser
+			}
+			v.Group = to
+			signature := mergeSignature(k, v)
+			if existing[signature] || (len(v.ID) > 0 && ids[v.ID]) {
+				report.Duplicates[bucket]++
+				return true
+			}
+			existing[signature] = true
+			if v.Timestamp == 0 {
+				v.Timestamp = keyTimestamp(k)
+			}
+			toInsert = append(toInsert, v)
+			// renames are only counted for fingerprints that are merged
+			if renamedLocation {
+				report.RenamedLocations++
+			}
+			if renamedUser {
+				report.RenamedUsers++
+			}
+			if bucket == "fingerprints" && len(v.Location) > 0 && !stringInSlice(v.Location, knownLocations) && !stringInSlice(v.Location, report.NewLocations) {
+				report.NewLocations = append(report.NewLocations, v.Location)
+			}
+			if len(v.Username) > 0 && !stringInSlice(v.Username, knownUsers) && !stringInSlice(v.Username, report.NewUsers) {
+				report.NewUsers = append(report.NewUsers, v.Username)
+			}
+			return true
+		})
+		report.Inserted[bucket] = len(toInsert)
+		if request.DryRun || len(toInsert) == 0 {
+			continue
+		}
+		inserted, err := storage.PutFingerprints(to, bucket, toInsert)
+		report.Inserted[bucket] = inserted
+		if err != nil {
+			return report, err
+		}
+	}
+	sort.Strings(report.NewLocations)
+	sort.Strings(report.NewUsers)
+	if request.DryRun {
+		return report, nil
+	}
+
+	if !toExists {
+		// a new group keeps the overrides and network names of the old one,
+		// everything else is recalculated or specific to the old group
+		resources, _ := storage.ListResources(from)
+		toCopy := make(map[string][]byte)
+		for _, key := range []string{"persistentParameters", "mixinOverride", "cutoffOverride"} {
+			if v, ok := resources[key]; ok {
+				toCopy[key] = v
+			}
+		}
+		if len(toCopy) > 0 {
+			storage.PutResources(to, toCopy)
+		}
+	}
+	if report.Inserted["fingerprints"] > 0 {
+		Debug.Println("Merge finished, calculating priors for " + to)
+		setLearningCache(to, false)
+		recalculateGroup(to)
+	}
+	go resetCache("userCache")
+	go resetCache("userPositionCache")
+	return report, nil
+}
+
+// mergeSignature identifies a fingerprint regardless of the group it is in.
+func mergeSignature(key string, v Fingerprint) string {
+	routers := make([]string, len(v.WifiFingerprint))
+	for i, router := range v.WifiFingerprint {
+		routers[i] = router.Mac + "=" + strconv.Itoa(router.Rssi)
+	}
+	sort.Strings(routers)
+	timestamp := v.Timestamp
+	if timestamp == 0 {
+		timestamp = keyTimestamp(key)
+	}
+	return strconv.FormatInt(timestamp, 10) + "|" + v.Username + "|" + v.Location + "|" + strings.Join(routers, ",")
+}
+
+// lowerMap returns a copy of m with all keys and values lowercased and trimmed.
+func lowerMap(m map[string]string) map[string]string {
+	lowered := make(map[string]string)
+	for k, v := range m {
+		lowered[strings.TrimSpace(strings.ToLower(k))] = strings.TrimSpace(strings.ToLower(v))
+	}
+	return lowered
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeGroups(t *testing.T) {
+	defer useMemoryStore()()
+
+	storage.PutFingerprints("mergefrom", "fingerprints", []Fingerprint{
+		{Group: "mergefrom", Location: "kitchen", Timestamp: 1, WifiFingerprint: []Router{{Mac: "aa", Rssi: -40}}},
+		{Group: "mergefrom", Location: "den", Timestamp: 2, WifiFingerprint: []Router{{Mac: "aa", Rssi: -70}}},
+	})
+	storage.PutFingerprints("mergefrom", "fingerprints-track", []Fingerprint{
+		{Group: "mergefrom", Username: "zack", Timestamp: 3, WifiFingerprint: []Router{{Mac: "aa", Rssi: -50}}},
+	})
+	storage.PutFingerprints("mergeto", "fingerprints", []Fingerprint{
+		{Group: "mergeto", Location: "kitchen", Timestamp: 1, WifiFingerprint: []Router{{Mac: "aa", Rssi: -40}}},
+	})
+
+	request := MergeRequest{From: "mergefrom", To: "mergeto", Locations: map[string]string{"Den": "office"}, Users: map[string]string{"zack": "zack2"}, DryRun: true}
+	report, err := mergeGroups(request)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Inserted["fingerprints"])
+	assert.Equal(t, 1, report.Duplicates["fingerprints"])
+	assert.Equal(t, 1, report.Inserted["fingerprints-track"])
+	assert.Equal(t, []string{"office"}, report.NewLocations)
+	assert.Equal(t, []string{"zack2"}, report.NewUsers)
+	assert.Equal(t, 1, report.RenamedLocations)
+	assert.Equal(t, 1, report.RenamedUsers)
+	assert.Equal(t, []string{"kitchen"}, getUniqueLocations("mergeto"))
+
+	request.DryRun = false
+	report, err = mergeGroups(request)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, report.Inserted["fingerprints"])
+	assert.Equal(t, []string{"kitchen", "office"}, getUniqueLocations("mergeto"))
+
+	// merging again changes nothing
+	report, err = mergeGroups(request)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, report.Inserted["fingerprints"]+report.Inserted["fingerprints-track"])
+	assert.Equal(t, 3, report.Duplicates["fingerprints"]+report.Duplicates["fingerprints-track"])
+	assert.Equal(t, 0, report.RenamedLocations+report.RenamedUsers)
+
+	// the -filter whitelist is not applied to the merged fingerprints
+	RuntimeArgs.Filtering = true
+	RuntimeArgs.FilterMacs = map[string]bool{"bb": true}
+	storage.PutFingerprints("mergefrom", "fingerprints", []Fingerprint{
+		{Group: "mergefrom", Location: "kitchen", Timestamp: 4, WifiFingerprint: []Router{{Mac: "aa", Rssi: -40}, {Mac: "bb", Rssi: -60}}},
+	})
+	_, err = mergeGroups(MergeRequest{From: "mergefrom", To: "mergefiltered"})
+	RuntimeArgs.Filtering = false
+	RuntimeArgs.FilterMacs = nil
+	assert.Nil(t, err)
+	merged := []Router{}
+	storage.ForEachFingerprint("mergefiltered", "fingerprints", false, func(k string, v Fingerprint) bool {
+		if v.Timestamp == 4 {
+			merged = v.WifiFingerprint
+		}
+		return true
+	})
+	assert.Equal(t, []Router{{Mac: "aa", Rssi: -40}, {Mac: "bb", Rssi: -60}}, merged)
+
+	_, err = mergeGroups(MergeRequest{From: "mergenone", To: "mergeto"})
+	assert.NotNil(t, err)
+	_, err = mergeGroups(MergeRequest{From: "mergeto", To: "mergeto"})
+	assert.NotNil(t, err)
+}
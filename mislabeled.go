@@ -0,0 +1,244 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// mislabeled.go flags learned fingerprints that are classified as another
+// location when they are left out of the priors, such as the ones taken in the
+// hallway while learning the kitchen, so they can be relabelled or deleted.
+// The check is calculated whenever the priors are optimized, and saved for
+// the mislabeled routes.
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	registerResources("mislabeled")
+}
+
+// mislabeledMinMargin is how much better, in standard deviations of the
+// posterior, the guessed location must score than the label to flag a fingerprint
+const mislabeledMinMargin = 0.25
+
+// MislabeledFingerprint is a learned fingerprint that looks like it was taken at another location
+type MislabeledFingerprint struct {
+	ID        string  `json:"id"`
+	Location  string  `json:"location"`
+	Guess     string  `json:"guess"`
+	Margin    float64 `json:"margin"` // posterior of the guess minus posterior of the location
+	Username  string  `json:"username"`
+	Timestamp int64   `json:"timestamp"`
+}
+
+// MislabeledReport lists the flagged fingerprints of a group, most doubtful first
+type MislabeledReport struct {
+	Calculated   time.Time               `json:"calculated"`
+	Checked      int                     `json:"checked"`
+	Fingerprints []MislabeledFingerprint `json:"fingerprints"`
+}
+
+// findMislabeled classifies every learned fingerprint with priors calculated
+// without it, using the optimized MixIn and cutoff of ps. Fingerprints whose
+// label scores far below the guess are flagged. Instead of leaving out one
+// fingerprint at a time, which would recalculate the priors once per
+// fingerprint, it leaves out every FoldCrossValidation'th fingerprint at a
+// time like crossValidation does, so each is classified by priors calculated
+// from the other folds. This approximates leave-one-out.
+func findMislabeled(ps FullParameters, fingerprintsInMemory map[string]Fingerprint, fingerprintsOrdering []string) MislabeledReport {
+	report := MislabeledReport{Calculated: time.Now(), Fingerprints: []MislabeledFingerprint{}}
+	folds := int(FoldCrossValidation)
+	for fold := 0; fold < folds; fold++ {
+		foldPs := ps
+		calculatePriorsHoldingOut(&foldPs, fingerprintsInMemory, fingerprintsOrdering, func(it float64) bool {
+			return int(it)%folds == fold
+		}, nil)
+		for n := range foldPs.Priors {
+			foldPs.Priors[n].Special["MixIn"] = ps.Priors[n].Special["MixIn"]
+			foldPs.Priors[n].Special["VarabilityCutoff"] = ps.Priors[n].Special["VarabilityCutoff"]
+		}
+
+		for it := fold; it < len(fingerprintsOrdering); it += folds {
+			id := fingerprintsOrdering[it]
+			fingerprint := fingerprintsInMemory[id]
+			if len(fingerprint.WifiFingerprint) == 0 {
+				continue
+			}
+			report.Checked++
+			guess, posteriors := calculatePosterior(fingerprint, foldPs)
+			labelPosterior, ok := posteriors[fingerprint.Location]
+			if !ok {
+				labelPosterior = math.Inf(-1)
+			}
+			margin := posteriors[guess] - labelPosterior
+			if guess == fingerprint.Location || margin < mislabeledMinMargin {
+				continue
+			}
+			if math.IsInf(margin, 1) {
+				margin = math.MaxFloat64
+			}
+			report.Fingerprints = append(report.Fingerprints, MislabeledFingerprint{
+				ID:        id,
+				Location:  fingerprint.Location,
+				Guess:     guess,
+				Margin:    margin,
+				Username:  fingerprint.Username,
+				Timestamp: fingerprint.Timestamp,
+			})
+		}
+	}
+	sort.Slice(report.Fingerprints, func(i, j int) bool {
+		return report.Fingerprints[i].Margin > report.Fingerprints[j].Margin
+	})
+	return report
+}
+
+func saveMislabeled(group string, report MislabeledReport) error {
+	jsonByte, _ := json.Marshal(report)
+	return storage.PutResources(group, map[string][]byte{"mislabeled": jsonByte})
+}
+
+// openMislabeled returns the fingerprints flagged when the priors of group were last optimized.
+func openMislabeled(group string) MislabeledReport {
+	report := MislabeledReport{Fingerprints: []MislabeledFingerprint{}}
+	v, err := storage.GetResource(group, "mislabeled")
+	if err == nil {
+		json.Unmarshal(v, &report)
+	}
+	return report
+}
+
+// selectMislabeled returns the flagged fingerprints with the given ids, or all of them if ids is empty.
+func selectMislabeled(report MislabeledReport, ids []string) map[string]MislabeledFingerprint {
+	selected := make(map[string]MislabeledFingerprint)
+	for _, flagged := range report.Fingerprints {
+		if len(ids) == 0 || stringInSlice(flagged.ID, ids) {
+			selected[flagged.ID] = flagged
+		}
+	}
+	return selected
+}
+
+// relabelMislabeled moves the selected fingerprints to location, or to the
+// location they were guessed as if location is empty, and returns how many changed.
+func relabelMislabeled(group string, ids []string, location string) int {
+	selected := selectMislabeled(openMislabeled(group), ids)
+	if len(selected) == 0 {
+		return 0
+	}
+	toUpdate := make(map[string]Fingerprint)
+	storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v Fingerprint) bool {
+		if flagged, ok := selected[k]; ok && flagged.Location == v.Location {
+			v.Location = flagged.Guess
+			if len(location) > 0 {
+				v.Location = location
+			}
+			toUpdate[k] = v
+		}
+		return true
+	})
+	if len(toUpdate) == 0 {
+		return 0
+	}
+	storage.UpdateFingerprints(group, "fingerprints", toUpdate)
+	recalculateGroup(group)
+	return len(toUpdate)
+}
+
+// trashMislabeled moves the selected fingerprints to the trash.
+func trashMislabeled(group string, ids []string) (TrashEntry, error) {
+	selected := selectMislabeled(openMislabeled(group), ids)
+	if len(selected) == 0 {
+		return TrashEntry{}, nil
+	}
+	entry, err := trashFingerprintKeysWhere(group, "fingerprints", "mislabeled fingerprints", func(key string, fingerprint Fingerprint) bool {
+		flagged, ok := selected[key]
+		return ok && flagged.Location == fingerprint.Location
+	})
+	if err != nil {
+		return entry, err
+	}
+	recalculateGroup(group)
+	return entry, nil
+}
+
+func mislabeledIDs(c *gin.Context) []string {
+	ids := []string{}
+	for _, id := range strings.Split(c.DefaultQuery("ids", ""), ",") {
+		if id = strings.TrimSpace(id); len(id) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// getMislabeled usage: curl "http://localhost:8003/mislabeled?group=X"
+// The fingerprints are checked when the priors are optimized, see /calculate.
+func getMislabeled(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	report := openMislabeled(group)
+	c.JSON(http.StatusOK, gin.H{"message": strconv.Itoa(len(report.Fingerprints)) + " of " + strconv.Itoa(report.Checked) + " fingerprints look mislabeled", "success": true, "mislabeled": report})
+}
+
+// putMislabeled relabels flagged fingerprints as their guess, or as location if it is given.
+// usage: curl -X PUT "http://localhost:8003/mislabeled?group=X&ids=1,2&location=Y"
+func putMislabeled(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	location := strings.TrimSpace(strings.ToLower(c.DefaultQuery("location", "")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	numChanges := relabelMislabeled(group, mislabeledIDs(c), location)
+	c.JSON(http.StatusOK, gin.H{"message": "Relabelled " + strconv.Itoa(numChanges) + " fingerprints", "success": true})
+}
+
+// deleteMislabeled moves flagged fingerprints to the trash.
+// usage: curl -X DELETE "http://localhost:8003/mislabeled?group=X&ids=1,2"
+func deleteMislabeled(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	entry, err := trashMislabeled(group, mislabeledIDs(c))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Deleted " + strconv.Itoa(entry.Fingerprints) + " fingerprints", "success": true, "trash": entry})
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMislabeled(t *testing.T) {
+	defer useMemoryStore()()
+
+	learnPerRoom := 40
+	simulateGrid(t, Simulation{
+		Group: "mislabeledtest",
+		Rooms: []SimulationRoom{
+			{Name: "kitchen", X1: 0, Y1: 0, X2: 4, Y2: 4},
+			{Name: "bedroom", X1: 26, Y1: 26, X2: 30, Y2: 30},
+			{Name: "office", X1: 26, Y1: 0, X2: 30, Y2: 4},
+		},
+		LearnPerRoom: &learnPerRoom,
+	})
+
+	// learn a few of the bedroom fingerprints as the kitchen by mistake
+	wrong := 0
+	updateFingerprintsWhere("mislabeledtest", "fingerprints", func(fingerprint *Fingerprint) bool {
+		if fingerprint.Location == "bedroom" && wrong < 4 {
+			fingerprint.Location = "kitchen"
+			wrong++
+			return true
+		}
+		return false
+	})
+	assert.Nil(t, optimizePriorsThreaded("mislabeledtest"))
+
+	report := openMislabeled("mislabeledtest")
+	assert.Equal(t, 120, report.Checked)
+	assert.Equal(t, 4, len(report.Fingerprints))
+	for _, flagged := range report.Fingerprints {
+		assert.Equal(t, "kitchen", flagged.Location)
+		assert.Equal(t, "bedroom", flagged.Guess)
+	}
+
+	// relabel one of them as its guess and delete the rest
+	assert.Equal(t, 1, relabelMislabeled("mislabeledtest", []string{report.Fingerprints[0].ID}, ""))
+	assert.Equal(t, 3, len(openMislabeled("mislabeledtest").Fingerprints))
+	entry, err := trashMislabeled("mislabeledtest", []string{})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, entry.Fingerprints)
+	assert.Equal(t, 0, len(openMislabeled("mislabeledtest").Fingerprints))
+
+	counts := make(map[string]int)
+	storage.ForEachFingerprint("mislabeledtest", "fingerprints", false, func(k string, v Fingerprint) bool {
+		counts[v.Location]++
+		return true
+	})
+	assert.Equal(t, map[string]int{"kitchen": 40, "bedroom": 37, "office": 40}, counts)
+}
@@ -9,15 +9,14 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 	"os/exec"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 
-	"github.com/boltdb/bolt"
 	"github.com/gin-gonic/gin"
 
 	MQTT "github.com/schollz/org.eclipse.paho.mqtt.golang"
@@ -77,77 +76,36 @@ func putMQTT(c *gin.Context) {
 
 func setMQTT(group string) (string, error) {
 	password := RandStringBytesMaskImprSrc(6)
-	db, err := bolt.Open(path.Join(RuntimeArgs.Cwd, "global.db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte("mqtt"))
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
-		}
-
-		err = bucket.Put([]byte(group), []byte(password))
-		if err != nil {
-			return fmt.Errorf("could add to bucket: %s", err)
-		}
-		return err
-	})
+	err := storage.PutCredential(group, password)
 	return password, err
 }
 
 func getMQTT(group string) (string, error) {
-	password := ""
-	db, err := bolt.Open(path.Join(RuntimeArgs.Cwd, "global.db"), 0600, nil)
+	password, err := storage.GetCredential(group)
 	if err != nil {
 		Error.Println(err)
 	}
-	defer db.Close()
-
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("mqtt"))
-		if b == nil {
-			return fmt.Errorf("Resources dont exist")
-		}
-		v := b.Get([]byte(group))
-		password = string(v)
-		return nil
-	})
 	return password, nil
 }
 
 func updateMosquittoConfig() {
-	db, err := bolt.Open(path.Join(RuntimeArgs.Cwd, "global.db"), 0600, nil)
-	if err != nil {
-		Error.Println(err)
-	}
-	defer db.Close()
-
 	acl := "user " + RuntimeArgs.MqttAdmin + "\ntopic readwrite #\n\n"
 	passwd := "admin:" + RuntimeArgs.MqttAdminPassword + "\n"
 	conf := "allow_anonymous false\n\nacl_file " + path.Join(RuntimeArgs.Cwd, "mosquitto") + "/acl\n\npassword_file " + path.Join(RuntimeArgs.Cwd, "mosquitto") + "/passwd"
 
-	db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("mqtt"))
-		if b == nil {
-			return fmt.Errorf("No such bucket yet")
-		}
-
-		c := b.Cursor()
-
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			group := string(k)
-			pass := string(v)
-			acl = acl + "user " + group + "\ntopic readwrite " + group + "/#\n\n"
-			passwd = passwd + group + ":" + pass + "\n"
-		}
-
-		return nil
-	})
+	credentials, err := storage.ListCredentials()
+	if err != nil {
+		Error.Println(err)
+	}
+	groups := []string{}
+	for group := range credentials {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		acl = acl + "user " + group + "\ntopic readwrite " + group + "/#\n\n"
+		passwd = passwd + group + ":" + credentials[group] + "\n"
+	}
 	os.MkdirAll(path.Join(RuntimeArgs.Cwd, "mosquitto"), 0644)
 	ioutil.WriteFile(path.Join(RuntimeArgs.Cwd, "mosquitto/acl"), []byte(acl), 0644)
 	ioutil.WriteFile(path.Join(RuntimeArgs.Cwd, "mosquitto/passwd"), []byte(passwd), 0644)
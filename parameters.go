@@ -9,14 +9,14 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"log"
-	"path"
 	"strconv"
 	"strings"
-
-	"github.com/boltdb/bolt"
 )
 
+func init() {
+	registerResources("fullParameters", "persistentParameters", "mixinOverride", "cutoffOverride")
+}
+
 // PersistentParameters are not reloaded each time
 type PersistentParameters struct {
 	NetworkRenamed map[string][]string
@@ -99,35 +99,23 @@ func NewPersistentParameters() *PersistentParameters {
 
 func dumpParameters(res FullParameters) []byte {
 	jsonByte, _ := res.MarshalJSON()
-	return compressByte(jsonByte)
+	return parametersSchema.encode(jsonByte)
 }
 
 func loadParameters(jsonByte []byte) FullParameters {
 	var res2 FullParameters
-	res2.UnmarshalJSON(decompressByte(jsonByte))
+	decoded, err := parametersSchema.decode(jsonByte)
+	if err != nil {
+		Warning.Println(err)
+	}
+	res2.UnmarshalJSON(decoded)
 	return res2
 }
 
+// saveParameters saves res as a new snapshot of the parameters, which becomes
+// active and cached unless a previous snapshot is pinned (see snapshots.go).
 func saveParameters(group string, res FullParameters) error {
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		Error.Println(err)
-	}
-	defer db.Close()
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket, err2 := tx.CreateBucketIfNotExists([]byte("resources"))
-		if err2 != nil {
-			return fmt.Errorf("create bucket: %s", err2)
-		}
-
-		err2 = bucket.Put([]byte("fullParameters"), dumpParameters(res))
-		if err2 != nil {
-			return fmt.Errorf("could add to bucket: %s", err2)
-		}
-		return err2
-	})
-	return err
+	return saveParameterSnapshot(group, res)
 }
 
 func openParameters(group string) (FullParameters, error) {
@@ -137,22 +125,10 @@ func openParameters(group string) (FullParameters, error) {
 	}
 
 	var ps = *NewFullParameters()
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		Error.Println(err)
-	}
-	defer db.Close()
-
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("resources"))
-		if b == nil {
-			return fmt.Errorf("Resources dont exist")
-		}
-		v := b.Get([]byte("fullParameters"))
+	v, err := storage.GetResource(group, "fullParameters")
+	if err == nil {
 		ps = loadParameters(v)
-		return nil
-	})
+	}
 
 	go setPsCache(group, ps)
 	return ps, err
@@ -160,51 +136,22 @@ func openParameters(group string) (FullParameters, error) {
 
 func openPersistentParameters(group string) (PersistentParameters, error) {
 	var persistentPs = *NewPersistentParameters()
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		Error.Println(err)
-	}
-	defer db.Close()
-
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("resources"))
-		if b == nil {
-			return fmt.Errorf("Resources dont exist")
-		}
-		v := b.Get([]byte("persistentParameters"))
+	v, err := storage.GetResource(group, "persistentParameters")
+	if err == nil {
 		json.Unmarshal(v, &persistentPs)
-		return nil
-	})
+	}
 	return persistentPs, err
 }
 
 func savePersistentParameters(group string, res PersistentParameters) error {
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		Error.Println(err)
-	}
-	defer db.Close()
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket, err2 := tx.CreateBucketIfNotExists([]byte("resources"))
-		if err2 != nil {
-			return fmt.Errorf("create bucket: %s", err)
-		}
-
-		jsonByte, _ := json.Marshal(res)
-		err2 = bucket.Put([]byte("persistentParameters"), jsonByte)
-		if err2 != nil {
-			return fmt.Errorf("could add to bucket: %s", err)
-		}
-		return err2
-	})
+	jsonByte, _ := json.Marshal(res)
+	err := storage.PutResources(group, map[string][]byte{"persistentParameters": jsonByte})
 	Debug.Println("Saved")
 	return err
 }
 
 func getParameters(group string, ps *FullParameters, fingerprintsInMemory map[string]Fingerprint, fingerprintsOrdering []string) {
-	persistentPs, err := openPersistentParameters(group)
+	persistentPs, _ := openPersistentParameters(group)
 	ps.NetworkMacs = make(map[string]map[string]bool)
 	ps.NetworkLocs = make(map[string]map[string]bool)
 	ps.UniqueMacs = []string{}
@@ -212,11 +159,6 @@ func getParameters(group string, ps *FullParameters, fingerprintsInMemory map[st
 	ps.MacCount = make(map[string]int)
 	ps.MacCountByLoc = make(map[string]map[string]int)
 	ps.Loaded = true
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	defer db.Close()
 
 	// Get all parameters that don't need a network graph
 	for _, v1 := range fingerprintsOrdering {
@@ -313,101 +255,36 @@ func getParameters(group string, ps *FullParameters, fingerprintsInMemory map[st
 }
 
 func getMixinOverride(group string) (float64, error) {
-	group = strings.ToLower(group)
-	override := float64(-1)
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	defer db.Close()
-	if err != nil {
-		Error.Println(err)
-	}
-
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("resources"))
-		if b == nil {
-			return fmt.Errorf("Resources dont exist")
-		}
-		v := b.Get([]byte("mixinOverride"))
-		if len(v) == 0 {
-			return fmt.Errorf("No mixin override")
-		}
-		override, err = strconv.ParseFloat(string(v), 64)
-		return err
-	})
-	return override, err
+	return getOverride(group, "mixinOverride")
 }
 
 func getCutoffOverride(group string) (float64, error) {
+	return getOverride(group, "cutoffOverride")
+}
+
+func getOverride(group string, key string) (float64, error) {
 	group = strings.ToLower(group)
 	override := float64(-1)
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	defer db.Close()
+	v, err := storage.GetResource(group, key)
 	if err != nil {
-		Error.Println(err)
+		return override, err
 	}
-
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("resources"))
-		if b == nil {
-			return fmt.Errorf("Resources dont exist")
-		}
-		v := b.Get([]byte("cutoffOverride"))
-		if len(v) == 0 {
-			return fmt.Errorf("No mixin override")
-		}
-		override, err = strconv.ParseFloat(string(v), 64)
-		return err
-	})
-	return override, err
+	if len(v) == 0 {
+		return override, fmt.Errorf("No %s", key)
+	}
+	return strconv.ParseFloat(string(v), 64)
 }
 
 func setMixinOverride(group string, mixin float64) error {
 	if (mixin < 0 || mixin > 1) && mixin != -1 {
 		return fmt.Errorf("mixin must be between 0 and 1")
 	}
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	defer db.Close()
-	if err != nil {
-		Error.Println(err)
-	}
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket, err2 := tx.CreateBucketIfNotExists([]byte("resources"))
-		if err2 != nil {
-			return fmt.Errorf("create bucket: %s", err2)
-		}
-
-		err2 = bucket.Put([]byte("mixinOverride"), []byte(strconv.FormatFloat(mixin, 'E', -1, 64)))
-		if err2 != nil {
-			return fmt.Errorf("could add to bucket: %s", err2)
-		}
-		return err2
-	})
-	return err
+	return storage.PutResources(group, map[string][]byte{"mixinOverride": []byte(strconv.FormatFloat(mixin, 'E', -1, 64))})
 }
 
 func setCutoffOverride(group string, cutoff float64) error {
 	if (cutoff < 0 || cutoff > 1) && cutoff != -1 {
 		return fmt.Errorf("cutoff must be between 0 and 1")
 	}
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	defer db.Close()
-	if err != nil {
-		Error.Println(err)
-	}
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket, err2 := tx.CreateBucketIfNotExists([]byte("resources"))
-		if err2 != nil {
-			return fmt.Errorf("create bucket: %s", err2)
-		}
-
-		err2 = bucket.Put([]byte("cutoffOverride"), []byte(strconv.FormatFloat(cutoff, 'E', -1, 64)))
-		if err2 != nil {
-			return fmt.Errorf("could add to bucket: %s", err2)
-		}
-		return err2
-	})
-	return err
+	return storage.PutResources(group, map[string][]byte{"cutoffOverride": []byte(strconv.FormatFloat(cutoff, 'E', -1, 64))})
 }
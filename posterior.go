@@ -8,11 +8,35 @@ package main
 
 import "math"
 
-// calculatePosterior takes a Fingerprint and a Parameter set and returns the noramlized Bayes probabilities of possible locations
+// calculatePosterior takes a Fingerprint and a Parameter set and returns the noramlized Bayes probabilities of possible locations.
 func calculatePosterior(res Fingerprint, ps FullParameters) (string, map[string]float64) {
+	return calculatePosteriorWithPrior(res, ps, nil)
+}
+
+// calculatePosteriorWithPrior is calculatePosterior with the prior of each
+// location, or the uniform prior if prior is nil (see userLocationPrior).
+func calculatePosteriorWithPrior(res Fingerprint, ps FullParameters, prior map[string]float64) (string, map[string]float64) {
 	if !ps.Loaded {
 		ps, _ = openParameters(res.Group)
 	}
+	n, PBayes1, PBayes2 := posteriorTerms(res, ps, prior, nil)
+	if len(ps.NetworkLocs[n]) == 1 {
+		for key := range ps.NetworkLocs[n] {
+			PBayesMix := make(map[string]float64)
+			PBayesMix[key] = 1
+			return key, PBayesMix
+		}
+	}
+	return mixPosterior(ps.Priors[n].Special["MixIn"], normalizeBayes(PBayes1), normalizeBayes(PBayes2))
+}
+
+// posteriorTerms returns the network of res and the two terms of its
+// posterior before they are normalized: the log posteriors of each location
+// given the access points seen (PBayes1) and given their RSSI (PBayes2).
+// A network with one location has no terms. A nil prior is uniform. If apTerm
+// is not nil it is called with what each access point adds to both terms of
+// each location, with a histogram term of 0 if its RSSI is not used.
+func posteriorTerms(res Fingerprint, ps FullParameters, prior map[string]float64, apTerm func(loc string, mac string, macFreq float64, histogram float64)) (string, map[string]float64, map[string]float64) {
 	macs := []string{}
 	W := make(map[string]int)
 	for v2 := range res.WifiFingerprint {
@@ -27,11 +51,7 @@ func calculatePosterior(res Fingerprint, ps FullParameters) (string, map[string]
 	}
 
 	if len(ps.NetworkLocs[n]) == 1 {
-		for key := range ps.NetworkLocs[n] {
-			PBayesMix := make(map[string]float64)
-			PBayesMix[key] = 1
-			return key, PBayesMix
-		}
+		return n, nil, nil
 	}
 
 	PBayes1 := make(map[string]float64)
@@ -39,6 +59,10 @@ func calculatePosterior(res Fingerprint, ps FullParameters) (string, map[string]
 	PA := 1.0 / float64(len(ps.NetworkLocs[n]))
 	PnA := (float64(len(ps.NetworkLocs[n])) - 1.0) / float64(len(ps.NetworkLocs[n]))
 	for loc := range ps.NetworkLocs[n] {
+		if prior != nil {
+			PA = prior[loc]
+			PnA = 1 - PA
+		}
 		PBayes1[loc] = float64(0)
 		PBayes2[loc] = float64(0)
 		for mac := range W {
@@ -54,29 +78,37 @@ func calculatePosterior(res Fingerprint, ps FullParameters) (string, map[string]
 			} else {
 				nweight = float64(ps.Priors[n].Special["NMacFreqMin"])
 			}
-			PBayes1[loc] += math.Log(weight*PA) - math.Log(weight*PA+PnA*nweight)
-
+			macFreq := math.Log(weight*PA) - math.Log(weight*PA+PnA*nweight)
+			histogram := float64(0)
 			if float64(ps.MacVariability[mac]) >= ps.Priors[n].Special["VarabilityCutoff"] && W[mac] > MinRssi {
 				ind := int(W[mac] - MinRssi)
 				if len(ps.Priors[n].P[loc][mac]) > 0 {
 					PBA := float64(ps.Priors[n].P[loc][mac][ind])
 					PBnA := float64(ps.Priors[n].NP[loc][mac][ind])
 					if PBA > 0 {
-						PBayes2[loc] += (math.Log(PBA*PA) - math.Log(PBA*PA+PBnA*PnA))
+						histogram = math.Log(PBA*PA) - math.Log(PBA*PA+PBnA*PnA)
 					} else {
-						PBayes2[loc] += -1
+						histogram = -1
 					}
 				}
 			}
+			PBayes1[loc] += macFreq
+			PBayes2[loc] += histogram
+			if apTerm != nil {
+				apTerm(loc, mac, macFreq, histogram)
+			}
 		}
 	}
-	PBayes1 = normalizeBayes(PBayes1)
-	PBayes2 = normalizeBayes(PBayes2)
+	return n, PBayes1, PBayes2
+}
+
+// mixPosterior mixes the normalized terms of a posterior and returns the best location with the mixed posterior.
+func mixPosterior(mixin float64, PBayes1 map[string]float64, PBayes2 map[string]float64) (string, map[string]float64) {
 	PBayesMix := make(map[string]float64)
 	bestLocation := ""
 	maxVal := float64(-100)
 	for key := range PBayes1 {
-		PBayesMix[key] = ps.Priors[n].Special["MixIn"]*PBayes1[key] + (1-ps.Priors[n].Special["MixIn"])*PBayes2[key]
+		PBayesMix[key] = mixin*PBayes1[key] + (1-mixin)*PBayes2[key]
 		if PBayesMix[key] > maxVal {
 			maxVal = PBayesMix[key]
 			bestLocation = key
@@ -0,0 +1,197 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// predict.go learns from the tracking history of a user how often they go from
+// each location to each other one and how long they stay before they do, and
+// predicts where they go next and when, so rooms can be heated or lit before
+// they arrive.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// predictMinSamples is how many consecutive fingerprints a location needs to count as a stay
+	predictMinSamples = 2
+	// predictMaxGap is the longest time without tracking that a stay lasts through
+	predictMaxGap = 30 * time.Minute
+)
+
+// LocationStay is a time a user spent at one location
+type LocationStay struct {
+	Location string `json:"location"`
+	Start    int64  `json:"start"`
+	End      int64  `json:"end"`
+	Samples  int    `json:"samples"`
+}
+
+// userStays turns the classified tracks of a user into the stays between
+// which they moved. Stays shorter than predictMinSamples are left out, as
+// they are usually misclassified, and a gap of more than predictMaxGap starts
+// a new list, since where the user went in between is unknown.
+func userStays(tracks []classifiedTrack) [][]LocationStay {
+	runs := [][]LocationStay{}
+	var last int64
+	for _, track := range tracks {
+		if len(runs) == 0 || time.Duration(track.Timestamp-last) > predictMaxGap {
+			runs = append(runs, []LocationStay{})
+		}
+		last = track.Timestamp
+		run := runs[len(runs)-1]
+		if len(run) > 0 && run[len(run)-1].Location == track.Location {
+			run[len(run)-1].End = track.Timestamp
+			run[len(run)-1].Samples++
+			continue
+		}
+		runs[len(runs)-1] = append(run, LocationStay{Location: track.Location, Start: track.Timestamp, End: track.Timestamp, Samples: 1})
+	}
+
+	stays := [][]LocationStay{}
+	for _, run := range runs {
+		merged := []LocationStay{}
+		for _, stay := range run {
+			if stay.Samples < predictMinSamples {
+				continue
+			}
+			if len(merged) > 0 && merged[len(merged)-1].Location == stay.Location {
+				merged[len(merged)-1].End = stay.End
+				merged[len(merged)-1].Samples += stay.Samples
+				continue
+			}
+			merged = append(merged, stay)
+		}
+		if len(merged) > 0 {
+			stays = append(stays, merged)
+		}
+	}
+	return stays
+}
+
+// markovModel counts the moves of a user between locations and how long they
+// stayed at a location before each move
+type markovModel struct {
+	transitions map[string]map[string]int
+	dwell       map[string]map[string][]float64 // seconds from arriving at a location to arriving at the next
+}
+
+func newMarkovModel(stays [][]LocationStay) markovModel {
+	model := markovModel{transitions: make(map[string]map[string]int), dwell: make(map[string]map[string][]float64)}
+	for _, run := range stays {
+		for i := 0; i+1 < len(run); i++ {
+			from, to := run[i].Location, run[i+1].Location
+			if _, ok := model.transitions[from]; !ok {
+				model.transitions[from] = make(map[string]int)
+				model.dwell[from] = make(map[string][]float64)
+			}
+			model.transitions[from][to]++
+			model.dwell[from][to] = append(model.dwell[from][to], time.Duration(run[i+1].Start-run[i].Start).Seconds())
+		}
+	}
+	return model
+}
+
+// NextLocation is a location a user may go to next
+type NextLocation struct {
+	Location    string  `json:"location"`
+	Probability float64 `json:"probability"`
+	Seconds     float64 `json:"seconds"` // expected time until the user arrives
+}
+
+// LocationPrediction is where a user is and where they may go next, most likely first
+type LocationPrediction struct {
+	User        string         `json:"user"`
+	Current     string         `json:"current"`
+	Since       time.Time      `json:"since"`
+	Transitions int            `json:"transitions"` // moves from the current location that were seen
+	Next        []NextLocation `json:"next"`
+}
+
+// predictNextLocations learns the moves of user in group over the last days
+// and predicts where they go next from the location they are at now. The
+// expected time takes off the time already spent at the location.
+func predictNextLocations(group string, user string, days float64, now time.Time) (LocationPrediction, error) {
+	prediction := LocationPrediction{User: user, Next: []NextLocation{}}
+	ps, err := openParameters(group)
+	if err != nil {
+		return prediction, err
+	}
+	stays := userStays(classifyTracks(group, ps, days, user)[user])
+	if len(stays) == 0 {
+		return prediction, fmt.Errorf("no tracking history for %s", user)
+	}
+	model := newMarkovModel(stays)
+	lastRun := stays[len(stays)-1]
+	current := lastRun[len(lastRun)-1]
+	prediction.Current = current.Location
+	prediction.Since = time.Unix(0, current.Start)
+	elapsed := now.Sub(prediction.Since).Seconds()
+
+	for _, count := range model.transitions[current.Location] {
+		prediction.Transitions += count
+	}
+	for to, count := range model.transitions[current.Location] {
+		seconds := average64(model.dwell[current.Location][to]) - elapsed
+		if seconds < 0 {
+			seconds = 0
+		}
+		prediction.Next = append(prediction.Next, NextLocation{
+			Location:    to,
+			Probability: float64(count) / float64(prediction.Transitions),
+			Seconds:     seconds,
+		})
+	}
+	sort.Slice(prediction.Next, func(i, j int) bool {
+		if prediction.Next[i].Probability != prediction.Next[j].Probability {
+			return prediction.Next[i].Probability > prediction.Next[j].Probability
+		}
+		return prediction.Next[i].Location < prediction.Next[j].Location
+	})
+	return prediction, nil
+}
+
+// getPrediction usage: curl "http://localhost:8003/predict?group=X&user=Y&days=14"
+func getPrediction(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	user := strings.TrimSpace(strings.ToLower(c.DefaultQuery("user", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	if user == "noneasdf" {
+		c.JSON(http.StatusOK, gin.H{"message": "You need to specify user", "success": false})
+		return
+	}
+	days, err := strconv.ParseFloat(c.DefaultQuery("days", "14"), 64)
+	if err != nil || days <= 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "days must be a number more than 0", "success": false})
+		return
+	}
+	prediction, err := predictNextLocations(group, user, days, time.Now())
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	message := user + " has not been seen leaving " + prediction.Current
+	if len(prediction.Next) > 0 {
+		next := prediction.Next[0]
+		message = fmt.Sprintf("%s will most likely go from %s to %s (%d%%) in %d minutes", user, prediction.Current, next.Location, int(100*next.Probability), int(next.Seconds/60))
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "success": true, "prediction": prediction})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserStays(t *testing.T) {
+	minute := int64(time.Minute)
+	tracks := []classifiedTrack{
+		{0, "bedroom"}, {minute, "bedroom"}, {2 * minute, "kitchen"}, {3 * minute, "bedroom"},
+		{4 * minute, "kitchen"}, {5 * minute, "kitchen"},
+		{60 * minute, "office"}, {61 * minute, "office"},
+	}
+	assert.Equal(t, [][]LocationStay{
+		{{Location: "bedroom", Start: 0, End: minute, Samples: 2}, {Location: "kitchen", Start: 4 * minute, End: 5 * minute, Samples: 2}},
+		{{Location: "office", Start: 60 * minute, End: 61 * minute, Samples: 2}},
+	}, userStays(tracks))
+}
+
+func TestPredictNextLocations(t *testing.T) {
+	defer useMemoryStore()()
+
+	s := simulateGrid(t, Simulation{
+		Group: "predicttest",
+		Rooms: []SimulationRoom{
+			{Name: "bedroom", X1: 0, Y1: 0, X2: 6, Y2: 6},
+			{Name: "kitchen", X1: 24, Y1: 0, X2: 30, Y2: 6},
+			{Name: "office", X1: 24, Y1: 24, X2: 30, Y2: 30},
+		},
+	})
+
+	// every morning zack gets up, spends 5 minutes in the kitchen and goes to the office
+	r := rand.New(rand.NewSource(2))
+	now := time.Now()
+	tracks := []Fingerprint{}
+	stay := func(x float64, y float64, from time.Time, minutes int) {
+		for i := 0; i < minutes; i++ {
+			tracks = append(tracks, Fingerprint{Username: "zack", Timestamp: from.Add(time.Duration(i) * time.Minute).UnixNano(), WifiFingerprint: s.scan(r, x+r.Float64()*4, y+r.Float64()*4)})
+		}
+	}
+	for day := 3; day > 0; day-- {
+		morning := now.Add(-time.Duration(day) * 24 * time.Hour)
+		stay(1, 1, morning, 10)
+		stay(25, 1, morning.Add(10*time.Minute), 5)
+		stay(25, 25, morning.Add(15*time.Minute), 20)
+	}
+	stay(1, 1, now.Add(-12*time.Minute), 10)
+	stay(25, 1, now.Add(-2*time.Minute), 2)
+	// bob is tracked as well, but only the tracks of zack are classified
+	for i := 0; i < 5; i++ {
+		tracks = append(tracks, Fingerprint{Username: "bob", Timestamp: now.Add(-time.Duration(i) * time.Minute).UnixNano(), WifiFingerprint: s.scan(r, 1, 25)})
+	}
+	_, err := insertSimulated("predicttest", "fingerprints-track", tracks)
+	assert.Nil(t, err)
+	ps, err := openParameters("predicttest")
+	assert.Nil(t, err)
+	classified := classifyTracks("predicttest", ps, 7, "zack")
+	assert.Equal(t, 1, len(classified))
+	assert.Equal(t, 117, len(classified["zack"]))
+	assert.Equal(t, 2, len(classifyTracks("predicttest", ps, 7, "")))
+
+	prediction, err := predictNextLocations("predicttest", "zack", 7, now)
+	assert.Nil(t, err)
+	assert.Equal(t, "kitchen", prediction.Current)
+	assert.Equal(t, 3, prediction.Transitions)
+	assert.Equal(t, 1, len(prediction.Next))
+	assert.Equal(t, "office", prediction.Next[0].Location)
+	assert.Equal(t, float64(1), prediction.Next[0].Probability)
+	assert.InDelta(t, 180, prediction.Next[0].Seconds, 1)
+
+	// later than usual, the move is expected any moment
+	prediction, err = predictNextLocations("predicttest", "zack", 7, now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, float64(0), prediction.Next[0].Seconds)
+
+	_, err = predictNextLocations("predicttest", "nobody", 7, now)
+	assert.NotNil(t, err)
+}
@@ -7,11 +7,7 @@
 package main
 
 import (
-	"log"
 	"math"
-	"path"
-
-	"github.com/boltdb/bolt"
 )
 
 // PdfType dictates the width of gaussian smoothing
@@ -51,23 +47,7 @@ func init() {
 // deprecated
 func optimizePriors(group string) {
 	// generate the fingerprintsInMemory
-	fingerprintsInMemory := make(map[string]Fingerprint)
-	var fingerprintsOrdering []string
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("fingerprints"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			fingerprintsInMemory[string(k)] = loadFingerprint(v)
-			// fmt.Println(fingerprintsInMemory[string(k)].Location, string(k))
-			fingerprintsOrdering = append(fingerprintsOrdering, string(k))
-		}
-		return nil
-	})
-	db.Close()
+	fingerprintsInMemory, fingerprintsOrdering, _ := getFingerprintsInMemory(group)
 
 	var ps = *NewFullParameters()
 	getParameters(group, &ps, fingerprintsInMemory, fingerprintsOrdering)
@@ -112,27 +92,11 @@ func optimizePriors(group string) {
 	}
 
 	go saveParameters(group, ps)
-	go setPsCache(group, ps)
 }
 
 func regenerateEverything(group string) {
 	// generate the fingerprintsInMemory
-	fingerprintsInMemory := make(map[string]Fingerprint)
-	var fingerprintsOrdering []string
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("fingerprints"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			fingerprintsInMemory[string(v)] = loadFingerprint(v)
-			fingerprintsOrdering = append(fingerprintsOrdering, string(v))
-		}
-		return nil
-	})
-	db.Close()
+	fingerprintsInMemory, fingerprintsOrdering, _ := getFingerprintsInMemory(group)
 
 	var ps = *NewFullParameters()
 	ps, _ = openParameters(group)
@@ -196,6 +160,21 @@ func crossValidation(group string, n string, ps *FullParameters, fingerprintsInM
 
 // calculatePriors generates the prior data for Naive-Bayes classification. Now deprecated, use calculatePriorsThreaded instead.
 func calculatePriors(group string, ps *FullParameters, fingerprintsInMemory map[string]Fingerprint, fingerprintsOrdering []string) {
+	calculatePriorsHoldingOut(ps, fingerprintsInMemory, fingerprintsOrdering, func(it float64) bool {
+		return math.Mod(it, FoldCrossValidation) == 0
+	}, nil)
+}
+
+// calculatePriorsHoldingOut generates the prior data from the fingerprints
+// whose position in fingerprintsOrdering is not held out. Fingerprints in
+// weights count that much towards the RSSI distributions, the rest count 1.
+func calculatePriorsHoldingOut(ps *FullParameters, fingerprintsInMemory map[string]Fingerprint, fingerprintsOrdering []string, heldOut func(it float64) bool, weights map[string]float32) {
+	calculatePriorsSmoothed(ps, fingerprintsInMemory, fingerprintsOrdering, heldOut, weights, PdfType)
+}
+
+// calculatePriorsSmoothed is calculatePriorsHoldingOut with the gaussian
+// smoothing of the RSSI distributions given by pdf instead of PdfType.
+func calculatePriorsSmoothed(ps *FullParameters, fingerprintsInMemory map[string]Fingerprint, fingerprintsOrdering []string, heldOut func(it float64) bool, weights map[string]float32, pdf []float32) {
 	// defer timeTrack(time.Now(), "calculatePriors")
 	ps.Priors = make(map[string]PriorParameters)
 	for n := range ps.NetworkLocs {
@@ -224,21 +203,25 @@ func calculatePriors(group string, ps *FullParameters, fingerprintsInMemory map[
 	for _, v1 := range fingerprintsOrdering {
 		v2 := fingerprintsInMemory[v1]
 		it++
-		if math.Mod(it, FoldCrossValidation) != 0 { // cross-validation
+		if !heldOut(it) { // cross-validation
 			macs := []string{}
 			for _, router := range v2.WifiFingerprint {
 				macs = append(macs, router.Mac)
 			}
 
+			weight, ok := weights[v1]
+			if !ok {
+				weight = 1
+			}
 			networkName, inNetwork := hasNetwork(ps.NetworkMacs, macs)
 			if inNetwork {
 				for _, router := range v2.WifiFingerprint {
 					if router.Rssi > MinRssi {
-						ps.Priors[networkName].P[v2.Location][router.Mac][router.Rssi-MinRssi] += PdfType[0]
-						for i, val := range PdfType {
+						ps.Priors[networkName].P[v2.Location][router.Mac][router.Rssi-MinRssi] += weight * pdf[0]
+						for i, val := range pdf {
 							if i > 0 {
-								ps.Priors[networkName].P[v2.Location][router.Mac][router.Rssi-MinRssi-i] += val
-								ps.Priors[networkName].P[v2.Location][router.Mac][router.Rssi-MinRssi+i] += val
+								ps.Priors[networkName].P[v2.Location][router.Mac][router.Rssi-MinRssi-i] += weight * val
+								ps.Priors[networkName].P[v2.Location][router.Mac][router.Rssi-MinRssi+i] += weight * val
 							}
 						}
 					} else {
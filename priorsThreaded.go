@@ -7,13 +7,8 @@
 package main
 
 import (
-	"fmt"
-	"log"
 	"math"
-	"path"
 	"runtime"
-
-	"github.com/boltdb/bolt"
 )
 
 // following this:https://play.golang.org/p/hK2h-irKyz
@@ -65,33 +60,14 @@ func worker(id int, jobs <-chan jobA, results chan<- resultA) {
 func optimizePriorsThreaded(group string) error {
 	// Debug.Println("Optimizing priors for " + group)
 	// generate the fingerprintsInMemory
-	fingerprintsInMemory := make(map[string]Fingerprint)
-	var fingerprintsOrdering []string
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-		return err
-	}
-	err = db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("fingerprints"))
-		if b == nil {
-			return fmt.Errorf("No fingerprint bucket")
-		}
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			fingerprintsInMemory[string(k)] = loadFingerprint(v)
-			fingerprintsOrdering = append(fingerprintsOrdering, string(k))
-		}
-		return nil
-	})
-	db.Close()
+	fingerprintsInMemory, fingerprintsOrdering, err := getFingerprintsInMemory(group)
 	if err != nil {
 		return err
 	}
 
 	var ps = *NewFullParameters()
 	getParameters(group, &ps, fingerprintsInMemory, fingerprintsOrdering)
-	calculatePriors(group, &ps, fingerprintsInMemory, fingerprintsOrdering)
+	calculatePriorsSelfTraining(group, &ps, fingerprintsInMemory, fingerprintsOrdering)
 
 	var results = *NewResultsParameters()
 	for n := range ps.Priors {
@@ -234,33 +210,21 @@ func optimizePriorsThreaded(group string) error {
 		crossValidation(group, n, &ps, fingerprintsInMemory, fingerprintsOrdering)
 	}
 
+	// Flag the learned fingerprints that look like another location
+	if err = saveMislabeled(group, findMislabeled(ps, fingerprintsInMemory, fingerprintsOrdering)); err != nil {
+		Warning.Println(err)
+	}
+
 	// Debug.Println(getUsers(group))
 	go resetCache("usersCache")
-	go saveParameters(group, ps)
-	go setPsCache(group, ps)
-
-	return nil
+	// saved before returning, so the group is classified with the new parameters right away
+	return saveParameters(group, ps)
 }
 
 func optimizePriorsThreadedNot(group string) {
 	// generate the fingerprintsInMemory
 	// Debug.Println("Optimizing priors for " + group)
-	fingerprintsInMemory := make(map[string]Fingerprint)
-	var fingerprintsOrdering []string
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0600, nil)
-	if err != nil {
-		log.Fatal(err)
-	}
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("fingerprints"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			fingerprintsInMemory[string(k)] = loadFingerprint(v)
-			fingerprintsOrdering = append(fingerprintsOrdering, string(k))
-		}
-		return nil
-	})
-	db.Close()
+	fingerprintsInMemory, fingerprintsOrdering, _ := getFingerprintsInMemory(group)
 
 	var ps = *NewFullParameters()
 	getParameters(group, &ps, fingerprintsInMemory, fingerprintsOrdering)
@@ -376,6 +340,5 @@ func optimizePriorsThreadedNot(group string) {
 		crossValidation(group, n, &ps, fingerprintsInMemory, fingerprintsOrdering)
 	}
 	go saveParameters(group, ps)
-	go setPsCache(group, ps)
 	// Debug.Println("Analyzed ", totalJobs, " fingerprints")
 }
@@ -0,0 +1,102 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// relabel.go copies the tracked fingerprints of a user over a time window into
+// the learned fingerprints with a known location, to grow the training data
+// from everyday use instead of a separate learning walk.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// relabelTracks copies the fingerprints that user tracked in group between
+// from and to into the learned fingerprints at location, recalculates the
+// group and returns how many were learned. Fingerprints that were already
+// copied to the same location are skipped.
+func relabelTracks(group string, user string, from time.Time, to time.Time, location string) (int, error) {
+	if !to.After(from) {
+		return 0, fmt.Errorf("the time window must end after it starts")
+	}
+	fingerprints := []Fingerprint{}
+	err := storage.ForEachFingerprint(group, "fingerprints-track", false, func(k string, v Fingerprint) bool {
+		if v.Username == user && v.Timestamp >= from.UnixNano() && v.Timestamp <= to.UnixNano() && len(v.WifiFingerprint) > 0 {
+			v.Group = group
+			v.Location = location
+			fingerprints = append(fingerprints, v)
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(fingerprints) == 0 {
+		return 0, fmt.Errorf("%s has no tracked fingerprints between %s and %s", user, from.Format(time.RFC3339), to.Format(time.RFC3339))
+	}
+	learned, err := putFingerprintsIntoDatabase(group, "fingerprints", fingerprints)
+	if err != nil {
+		return learned, err
+	}
+	if learned > 0 {
+		recalculateGroup(group)
+	}
+	return learned, nil
+}
+
+// parseRelabelTime reads a time as Unix seconds or RFC 3339.
+func parseRelabelTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// putRelabelTracks usage: curl -X PUT "http://localhost:8003/tracks/relabel?group=X&user=Y&location=Z&from=1500000000&to=1500000600"
+// to defaults to now, and times can also be given as RFC 3339.
+func putRelabelTracks(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	user := strings.TrimSpace(strings.ToLower(c.DefaultQuery("user", "noneasdf")))
+	location := strings.TrimSpace(strings.ToLower(c.DefaultQuery("location", "noneasdf")))
+	if group == "noneasdf" || user == "noneasdf" || location == "noneasdf" || len(location) == 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Need to provide group, user, location and from", "success": false})
+		return
+	}
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	from, err := parseRelabelTime(c.DefaultQuery("from", ""))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not parse from, use Unix seconds or RFC 3339", "success": false})
+		return
+	}
+	to := time.Now()
+	if len(c.Query("to")) > 0 {
+		to, err = parseRelabelTime(c.Query("to"))
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"message": "Could not parse to, use Unix seconds or RFC 3339", "success": false})
+			return
+		}
+	}
+	learned, err := relabelTracks(group, user, from, to, location)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Learned " + strconv.Itoa(learned) + " tracked fingerprints of " + user + " at " + location, "success": true, "learned": learned})
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRelabelTracks(t *testing.T) {
+	defer useMemoryStore()()
+
+	simulateGrid(t, Simulation{
+		Group: "relabeltest",
+		APs:   []SimulationAP{{Mac: "aa", X: 0, Y: 0}, {Mac: "bb", X: 30, Y: 0}, {Mac: "cc", X: 0, Y: 30}},
+		Rooms: []SimulationRoom{
+			{Name: "kitchen", X1: 0, Y1: 0, X2: 4, Y2: 4},
+			{Name: "office", X1: 26, Y1: 0, X2: 30, Y2: 4},
+		},
+	})
+
+	start := time.Unix(1500000000, 0)
+	tracks := []Fingerprint{}
+	for i := 0; i < 10; i++ {
+		for _, user := range []string{"zack", "bob"} {
+			tracks = append(tracks, Fingerprint{
+				Group:           "relabeltest",
+				Username:        user,
+				Timestamp:       start.Add(time.Duration(i) * time.Minute).UnixNano(),
+				WifiFingerprint: []Router{{Mac: "aa", Rssi: -60}, {Mac: "bb", Rssi: -70 - i}},
+			})
+		}
+	}
+	_, err := putFingerprintsIntoDatabase("relabeltest", "fingerprints-track", tracks)
+	assert.Nil(t, err)
+
+	learned, err := relabelTracks("relabeltest", "zack", start.Add(2*time.Minute), start.Add(5*time.Minute), "hallway")
+	assert.Nil(t, err)
+	assert.Equal(t, 4, learned)
+	ps, _ := openParameters("relabeltest")
+	assert.True(t, stringInSlice("hallway", ps.UniqueLocs))
+
+	// copying the same window again does not learn anything twice
+	learned, err = relabelTracks("relabeltest", "zack", start.Add(2*time.Minute), start.Add(5*time.Minute), "hallway")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, learned)
+
+	_, err = relabelTracks("relabeltest", "zack", start.Add(time.Hour), start.Add(2*time.Hour), "hallway")
+	assert.NotNil(t, err)
+	_, err = relabelTracks("relabeltest", "zack", start.Add(5*time.Minute), start, "hallway")
+	assert.NotNil(t, err)
+
+	parsed, err := parseRelabelTime("1500000000")
+	assert.Nil(t, err)
+	assert.True(t, parsed.Equal(start))
+	parsed, err = parseRelabelTime("2017-07-14T02:40:00Z")
+	assert.Nil(t, err)
+	assert.True(t, parsed.Equal(start))
+}
@@ -12,8 +12,6 @@ import (
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/boltdb/bolt"
 )
 
 func RandomString(strlen int) string {
@@ -29,27 +27,16 @@ func RandomString(strlen int) string {
 func rfLearn(group string) float64 {
 	tempFile := group + ".rf.json"
 
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0664, nil)
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-
 	Debug.Println("Writing " + tempFile)
 	f, err := os.OpenFile(path.Join(RuntimeArgs.SourcePath, tempFile), os.O_WRONLY|os.O_CREATE, 0664)
 	if err != nil {
 		return -1
 	}
 
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("fingerprints"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			v2 := loadFingerprint(v)
-			bJSON, _ := json.Marshal(v2)
-			f.WriteString(string(bJSON) + "\n")
-		}
-		return nil
+	storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v2 Fingerprint) bool {
+		bJSON, _ := json.Marshal(v2)
+		f.WriteString(string(bJSON) + "\n")
+		return true
 	})
 	f.Close()
 
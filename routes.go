@@ -11,8 +11,6 @@ import (
 	"fmt"
 	"html/template"
 	"net/http"
-	"os"
-	"path"
 	"sort"
 	"strconv"
 	"strings"
@@ -51,7 +49,7 @@ func slashLogin(c *gin.Context) {
 func slashLoginPOST(c *gin.Context) {
 	loginGroup := sessions.Default(c)
 	group := strings.ToLower(c.PostForm("group"))
-	if _, err := os.Stat(path.Join(RuntimeArgs.SourcePath, group+".db")); err == nil {
+	if groupExists(group) {
 		loginGroup.Set("group", group)
 		loginGroup.Save()
 		c.Redirect(302, "/dashboard/"+group)
@@ -101,7 +99,7 @@ func slashDashboard(c *gin.Context) {
 		}
 	}
 	group := c.Param("group")
-	if _, err := os.Stat(path.Join(RuntimeArgs.SourcePath, group+".db")); os.IsNotExist(err) {
+	if !groupExists(group) {
 		c.HTML(http.StatusOK, "login.tmpl", gin.H{
 			"ErrorMessage": "First download the app or CLI program to insert some fingerprints.",
 		})
@@ -159,7 +157,7 @@ func slashDashboard(c *gin.Context) {
 // slash Location returns location (to be deprecated)
 func slashLocation(c *gin.Context) {
 	group := c.Param("group")
-	if _, err := os.Stat(path.Join(RuntimeArgs.SourcePath, group+".db")); os.IsNotExist(err) {
+	if !groupExists(group) {
 		c.JSON(http.StatusOK, gin.H{"success": "false", "message": "First download the app or CLI program to insert some fingerprints."})
 		return
 	}
@@ -171,7 +169,7 @@ func slashLocation(c *gin.Context) {
 // slashExplore returns a chart of the data
 func slashExplore(c *gin.Context) {
 	group := c.Param("group")
-	if _, err := os.Stat(path.Join(RuntimeArgs.SourcePath, group+".db")); os.IsNotExist(err) {
+	if !groupExists(group) {
 		c.HTML(http.StatusOK, "login.tmpl", gin.H{
 			"ErrorMessage": "First download the app or CLI program to insert some fingerprints.",
 		})
@@ -213,7 +211,7 @@ func slashExplore(c *gin.Context) {
 // slashExplore returns a chart of the data (canvas.js)
 func slashExplore2(c *gin.Context) {
 	group := c.Param("group")
-	if _, err := os.Stat(path.Join(RuntimeArgs.SourcePath, group+".db")); os.IsNotExist(err) {
+	if !groupExists(group) {
 		c.HTML(http.StatusOK, "login.tmpl", gin.H{
 			"ErrorMessage": "First download the app or CLI program to insert some fingerprints.",
 		})
@@ -279,7 +277,7 @@ func slashExplore2(c *gin.Context) {
 // slashPie returns a Pie chart
 func slashPie(c *gin.Context) {
 	group := c.Param("group")
-	if _, err := os.Stat(path.Join(RuntimeArgs.SourcePath, group+".db")); os.IsNotExist(err) {
+	if !groupExists(group) {
 		c.HTML(http.StatusOK, "login.tmpl", gin.H{
 			"ErrorMessage": "First download the app or CLI program to insert some fingerprints.",
 		})
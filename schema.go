@@ -0,0 +1,166 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// schema.go contains the versioning of stored values and the migrations
+// between versions.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// schemaMarker starts every versioned value and is followed by the version
+// byte. Its low bits are the reserved deflate block type, so it can never be
+// the start of the unversioned (version 1) values which are plain deflate.
+const schemaMarker = 0xfe
+
+// migration converts the JSON of a stored value from one version to the next
+type migration func(jsonByte []byte) ([]byte, error)
+
+// recordSchema is the current version of a kind of stored value, and the
+// migrations that bring older values up to it. migrations[n] converts version n to n+1.
+type recordSchema struct {
+	name       string
+	version    int
+	migrations map[int]migration
+}
+
+// fingerprintSchema versions the values in the fingerprint buckets
+var fingerprintSchema = &recordSchema{
+	name:    "fingerprint",
+	version: 2,
+	migrations: map[int]migration{
+		// version 2 only adds the header
+		1: func(jsonByte []byte) ([]byte, error) { return jsonByte, nil },
+	},
+}
+
+// parametersSchema versions the fullParameters resource
+var parametersSchema = &recordSchema{
+	name:    "fullParameters",
+	version: 2,
+	migrations: map[int]migration{
+		// version 2 only adds the header
+		1: func(jsonByte []byte) ([]byte, error) { return jsonByte, nil },
+	},
+}
+
+// recordVersion returns the version of a stored value.
+func recordVersion(data []byte) int {
+	if len(data) >= 2 && data[0] == schemaMarker {
+		return int(data[1])
+	}
+	return 1
+}
+
+// encode returns the stored value for the JSON of the current version.
+func (s *recordSchema) encode(jsonByte []byte) []byte {
+	return append([]byte{schemaMarker, byte(s.version)}, compressByte(jsonByte)...)
+}
+
+// decode returns the JSON of a stored value, migrated to the current version.
+func (s *recordSchema) decode(data []byte) ([]byte, error) {
+	version := recordVersion(data)
+	if version == 1 {
+		data = decompressByte(data)
+	} else {
+		data = decompressByte(data[2:])
+	}
+	return s.upgrade(data, version)
+}
+
+// upgrade runs the migrations needed to bring JSON of version up to the current version.
+func (s *recordSchema) upgrade(jsonByte []byte, version int) ([]byte, error) {
+	if version > s.version {
+		return jsonByte, fmt.Errorf("%s version %d is newer than %d", s.name, version, s.version)
+	}
+	var err error
+	for ; version < s.version; version++ {
+		m, ok := s.migrations[version]
+		if !ok {
+			return jsonByte, fmt.Errorf("no migration for %s version %d", s.name, version)
+		}
+		jsonByte, err = m(jsonByte)
+		if err != nil {
+			return jsonByte, fmt.Errorf("migrating %s version %d: %s", s.name, version, err.Error())
+		}
+	}
+	return jsonByte, nil
+}
+
+// MigrationReport counts the values of a group rewritten by migrateGroup
+type MigrationReport struct {
+	Group    string         `json:"group"`
+	Migrated map[string]int `json:"migrated"`
+	Errors   []string       `json:"errors"`
+}
+
+// migrateGroup rewrites every value of group that is older than the current
+// schema, or not in the encoding set by the -encoding flag. It should not run
+// while the group is being written to, as it saves the MAC dictionary separately.
+func migrateGroup(group string) MigrationReport {
+	report := MigrationReport{Group: group, Migrated: make(map[string]int), Errors: []string{}}
+	dictionary, _ := storage.GetResource(group, "macDictionary")
+	codec := newFingerprintCodec(dictionary)
+	for _, bucket := range []string{"fingerprints", "fingerprints-track"} {
+		toUpdate := make(map[string][]byte)
+		storage.ForEachRecord(group, bucket, func(k string, v []byte) bool {
+			converted, changed, err := codec.convert(v)
+			if err != nil {
+				report.Errors = append(report.Errors, bucket+"/"+k+": "+err.Error())
+			} else if changed {
+				toUpdate[k] = converted
+			}
+			return true
+		})
+		if codec.dictionary.changed {
+			// the dictionary has to be saved before anything that refers to it
+			if err := storage.PutResources(group, map[string][]byte{"macDictionary": codec.dictionary.bytes()}); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+			codec.dictionary.changed = false
+		}
+		if len(toUpdate) > 0 {
+			if err := storage.PutRecords(group, bucket, toUpdate); err != nil {
+				report.Errors = append(report.Errors, err.Error())
+				continue
+			}
+		}
+		report.Migrated[bucket] = len(toUpdate)
+	}
+
+	v, err := storage.GetResource(group, "fullParameters")
+	if err == nil && v != nil && recordVersion(v) < parametersSchema.version {
+		jsonByte, err := parametersSchema.decode(v)
+		if err == nil {
+			err = storage.PutResources(group, map[string][]byte{"fullParameters": parametersSchema.encode(jsonByte)})
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, "fullParameters: "+err.Error())
+		} else {
+			report.Migrated["fullParameters"] = 1
+		}
+	}
+	go resetCache("psCache")
+	return report
+}
+
+// migrateAllGroups migrates every group, used by the -migrate flag.
+func migrateAllGroups() error {
+	groups, err := storage.ListGroups()
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		report := migrateGroup(group)
+		fmt.Printf("Migrated %s: %v\n", group, report.Migrated)
+		if len(report.Errors) > 0 {
+			return fmt.Errorf("could not migrate %s: %s", group, strings.Join(report.Errors, ", "))
+		}
+	}
+	return nil
+}
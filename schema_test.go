@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordSchema(t *testing.T) {
+	jsonByte := []byte(`{"location":"kitchen"}`)
+	legacy := compressByte(jsonByte)
+	assert.Equal(t, 1, recordVersion(legacy))
+	decoded, err := fingerprintSchema.decode(legacy)
+	assert.Nil(t, err)
+	assert.Equal(t, string(jsonByte), string(decoded))
+
+	encoded := fingerprintSchema.encode(jsonByte)
+	assert.Equal(t, fingerprintSchema.version, recordVersion(encoded))
+	decoded, err = fingerprintSchema.decode(encoded)
+	assert.Nil(t, err)
+	assert.Equal(t, string(jsonByte), string(decoded))
+
+	s := &recordSchema{name: "test", version: 3, migrations: map[int]migration{
+		1: func(b []byte) ([]byte, error) { return append(b, '2'), nil },
+		2: func(b []byte) ([]byte, error) { return append(b, '3'), nil },
+	}}
+	upgraded, err := s.upgrade([]byte("1"), 1)
+	assert.Nil(t, err)
+	assert.Equal(t, "123", string(upgraded))
+	_, err = s.upgrade([]byte("4"), 4)
+	assert.NotNil(t, err)
+}
+
+func TestMigrateGroup(t *testing.T) {
+	defer useMemoryStore()()
+
+	fingerprint := Fingerprint{Group: "schematest", Location: "kitchen", Timestamp: 1, WifiFingerprint: []Router{{Mac: "aa", Rssi: -40}}}
+	jsonByte, _ := fingerprint.MarshalJSON()
+	storage.PutRecords("schematest", "fingerprints", map[string][]byte{"1": compressByte(jsonByte)})
+	storage.PutFingerprints("schematest", "fingerprints", []Fingerprint{{Group: "schematest", Location: "office", Timestamp: 2, WifiFingerprint: []Router{{Mac: "aa", Rssi: -60}}}})
+
+	report := migrateGroup("schematest")
+	assert.Equal(t, 0, len(report.Errors))
+	assert.Equal(t, 1, report.Migrated["fingerprints"])
+	storage.ForEachRecord("schematest", "fingerprints", func(k string, v []byte) bool {
+		assert.Equal(t, fingerprintSchema.version, recordVersion(v))
+		return true
+	})
+	fingerprints, _, _ := getFingerprintsInMemory("schematest")
+	assert.Equal(t, "kitchen", fingerprints["1"].Location)
+	assert.Equal(t, 0, migrateGroup("schematest").Migrated["fingerprints"])
+}
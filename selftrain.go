@@ -0,0 +1,289 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// selftrain.go optionally adds tracked fingerprints that were classified
+// confidently and consistently to the RSSI distributions of the priors,
+// labelled with their guess and weighted lower than learned fingerprints, so
+// the priors follow slow changes in the signal strength of the known access
+// points, such as moved furniture, without learning again. Only learned
+// fingerprints count towards how often each access point is seen at a location
+// (MacFreq), and access points that were never learned are left out, so new
+// or removed access points still need learning again.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	registerResources("selfTraining")
+}
+
+// selfTrainingMaxGap is the longest time between two tracked fingerprints of a user that are still consecutive
+const selfTrainingMaxGap = 2 * time.Minute
+
+// selfTrainingMaxFingerprints is the most tracked fingerprints that are classified per calculation
+const selfTrainingMaxFingerprints = 5000
+
+// SelfTrainingSettings is whether and how a group learns from its tracked fingerprints
+type SelfTrainingSettings struct {
+	Enabled   bool    `json:"enabled"`
+	Threshold float64 `json:"threshold"`  // confidence a guess needs, from 0.5 (a tie with the next best location) to 1
+	Weight    float64 `json:"weight"`     // of a tracked fingerprint compared to a learned one
+	MinStable int     `json:"min_stable"` // consecutive fingerprints of a user that need the same guess
+	Days      float64 `json:"days"`       // how far back tracked fingerprints are used
+}
+
+// NewSelfTrainingSettings returns the settings of a group that has not changed them
+func NewSelfTrainingSettings() SelfTrainingSettings {
+	return SelfTrainingSettings{Threshold: 0.6, Weight: 0.3, MinStable: 3, Days: 7}
+}
+
+func (s SelfTrainingSettings) validate() error {
+	if s.Threshold < 0 || s.Threshold > 1 {
+		return fmt.Errorf("threshold must be between 0 and 1")
+	}
+	if s.Weight <= 0 || s.Weight > 1 {
+		return fmt.Errorf("weight must be more than 0 and at most 1")
+	}
+	if s.MinStable < 1 {
+		return fmt.Errorf("min_stable must be at least 1")
+	}
+	if s.Days <= 0 {
+		return fmt.Errorf("days must be more than 0")
+	}
+	return nil
+}
+
+func getSelfTrainingSettings(group string) SelfTrainingSettings {
+	settings := NewSelfTrainingSettings()
+	v, err := storage.GetResource(group, "selfTraining")
+	if err == nil && len(v) > 0 {
+		json.Unmarshal(v, &settings)
+	}
+	return settings
+}
+
+func setSelfTrainingSettings(group string, settings SelfTrainingSettings) error {
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	jsonByte, _ := json.Marshal(settings)
+	return storage.PutResources(group, map[string][]byte{"selfTraining": jsonByte})
+}
+
+// posteriorConfidence classifies res like calculatePosterior and returns the
+// guess with how sure it is: the logistic of the margin between the guess and
+// the next best location in their mixed log posteriors before normalizing, so
+// 0.5 is a tie and fingerprints that fit two locations about as well stay
+// below the threshold however the posterior is normalized afterwards.
+func posteriorConfidence(res Fingerprint, ps FullParameters) (string, float64) {
+	n, PBayes1, PBayes2 := posteriorTerms(res, ps, nil, nil)
+	if len(ps.NetworkLocs[n]) == 1 {
+		for loc := range ps.NetworkLocs[n] {
+			return loc, 1
+		}
+	}
+	mixin := ps.Priors[n].Special["MixIn"]
+	raw := make(map[string]float64, len(PBayes1))
+	for loc := range PBayes1 {
+		raw[loc] = mixin*PBayes1[loc] + (1-mixin)*PBayes2[loc]
+	}
+	guess, _ := mixPosterior(mixin, normalizeBayes(PBayes1), normalizeBayes(PBayes2))
+	if len(guess) == 0 {
+		return guess, 0
+	}
+	margin := math.Inf(1)
+	for loc, val := range raw {
+		if loc != guess {
+			margin = math.Min(margin, raw[guess]-val)
+		}
+	}
+	return guess, 1 / (1 + math.Exp(-margin))
+}
+
+// selfTrainingFingerprints classifies the recent tracked fingerprints of group
+// with its current parameters and returns, by key, those in runs of at least
+// settings.MinStable consecutive fingerprints of a user with the same
+// confident guess, labelled as the guess. Only the access points and locations
+// of the networks in ps are kept, since the priors have no room for others.
+func selfTrainingFingerprints(group string, ps FullParameters, settings SelfTrainingSettings) map[string]Fingerprint {
+	pseudo := make(map[string]Fingerprint)
+	current, err := openParameters(group)
+	if err != nil {
+		return pseudo
+	}
+	since := time.Now().Add(-time.Duration(settings.Days * float64(24*time.Hour))).UnixNano()
+
+	type guessed struct {
+		key         string
+		fingerprint Fingerprint
+		guess       string
+	}
+	byUser := make(map[string][]guessed)
+	classified := 0
+	storage.ForEachFingerprint(group, "fingerprints-track", true, func(k string, v Fingerprint) bool {
+		if v.Timestamp < since || classified >= selfTrainingMaxFingerprints {
+			return false
+		}
+		classified++
+		if len(v.WifiFingerprint) == 0 {
+			return true
+		}
+		v.Group = group
+		guess, confidence := posteriorConfidence(v, current)
+		if confidence < settings.Threshold {
+			guess = ""
+		}
+		byUser[v.Username] = append(byUser[v.Username], guessed{k, v, guess})
+		return true
+	})
+
+	for _, tracks := range byUser {
+		sort.Slice(tracks, func(i, j int) bool { return tracks[i].fingerprint.Timestamp < tracks[j].fingerprint.Timestamp })
+		for start := 0; start < len(tracks); {
+			end := start + 1
+			for end < len(tracks) && tracks[end].guess == tracks[start].guess &&
+				time.Duration(tracks[end].fingerprint.Timestamp-tracks[end-1].fingerprint.Timestamp) <= selfTrainingMaxGap {
+				end++
+			}
+			if len(tracks[start].guess) > 0 && end-start >= settings.MinStable {
+				for _, track := range tracks[start:end] {
+					if fingerprint, ok := pseudoLabel(ps, track.fingerprint, track.guess); ok {
+						pseudo["selftraining/"+track.key] = fingerprint
+					}
+				}
+			}
+			start = end
+		}
+	}
+	return pseudo
+}
+
+// pseudoLabel labels a tracked fingerprint as location, keeping only the
+// access points of the network it belongs to in ps.
+func pseudoLabel(ps FullParameters, fingerprint Fingerprint, location string) (Fingerprint, bool) {
+	macs := []string{}
+	for _, router := range fingerprint.WifiFingerprint {
+		macs = append(macs, router.Mac)
+	}
+	n, inNetwork := hasNetwork(ps.NetworkMacs, macs)
+	if !inNetwork || !ps.NetworkLocs[n][location] {
+		return fingerprint, false
+	}
+	routers := []Router{}
+	for _, router := range fingerprint.WifiFingerprint {
+		if ps.NetworkMacs[n][router.Mac] {
+			routers = append(routers, router)
+		}
+	}
+	fingerprint.WifiFingerprint = routers
+	fingerprint.Location = location
+	return fingerprint, true
+}
+
+// calculatePriorsSelfTraining calculates the priors like calculatePriors, adding
+// the fingerprints from selfTrainingFingerprints to the RSSI distributions if the
+// group enabled self-training. The MAC frequencies still come from the learned
+// fingerprints only, through ps.MacCountByLoc of getParameters. The tracked
+// fingerprints are never held out, so cross validation only tests learned fingerprints.
+// It returns how many tracked fingerprints were added.
+func calculatePriorsSelfTraining(group string, ps *FullParameters, fingerprintsInMemory map[string]Fingerprint, fingerprintsOrdering []string) int {
+	settings := getSelfTrainingSettings(group)
+	if !settings.Enabled {
+		calculatePriors(group, ps, fingerprintsInMemory, fingerprintsOrdering)
+		return 0
+	}
+	pseudo := selfTrainingFingerprints(group, *ps, settings)
+	training := make(map[string]Fingerprint, len(fingerprintsInMemory)+len(pseudo))
+	for k, v := range fingerprintsInMemory {
+		training[k] = v
+	}
+	trainingOrdering := append([]string{}, fingerprintsOrdering...)
+	weights := make(map[string]float32, len(pseudo))
+	for k, v := range pseudo {
+		training[k] = v
+		trainingOrdering = append(trainingOrdering, k)
+		weights[k] = float32(settings.Weight)
+	}
+	calculatePriorsHoldingOut(ps, training, trainingOrdering, func(it float64) bool {
+		return int(it) < len(fingerprintsOrdering) && math.Mod(it, FoldCrossValidation) == 0
+	}, weights)
+	return len(pseudo)
+}
+
+// getSelfTraining usage: curl "http://localhost:8003/selftraining?group=X"
+// The response includes how many tracked fingerprints would be used now.
+func getSelfTraining(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	settings := getSelfTrainingSettings(group)
+	ps, _ := openParameters(group)
+	candidates := len(selfTrainingFingerprints(group, ps, settings))
+	message := "Self-training is off, " + strconv.Itoa(candidates) + " tracked fingerprints could be used"
+	if settings.Enabled {
+		message = "Self-training is on, using " + strconv.Itoa(candidates) + " tracked fingerprints"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "success": true, "settings": settings, "candidates": candidates})
+}
+
+// putSelfTraining usage: curl -X PUT "http://localhost:8003/selftraining?group=X&enabled=true&threshold=0.5&weight=0.3&min_stable=3&days=7"
+// Settings that are not given are kept, and the group is recalculated.
+func putSelfTraining(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	settings := getSelfTrainingSettings(group)
+	var err error
+	if v := c.Query("enabled"); len(v) > 0 {
+		settings.Enabled, err = strconv.ParseBool(v)
+	}
+	for key, value := range map[string]*float64{"threshold": &settings.Threshold, "weight": &settings.Weight, "days": &settings.Days} {
+		if v := c.Query(key); len(v) > 0 && err == nil {
+			*value, err = strconv.ParseFloat(v, 64)
+		}
+	}
+	if v := c.Query("min_stable"); len(v) > 0 && err == nil {
+		settings.MinStable, err = strconv.Atoi(v)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not parse settings: " + err.Error(), "success": false})
+		return
+	}
+	if err = setSelfTrainingSettings(group, settings); err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	optimizePriorsThreaded(group)
+	c.JSON(http.StatusOK, gin.H{"message": "Saved self-training settings and recalculated " + group, "success": true, "settings": settings})
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfTraining(t *testing.T) {
+	defer useMemoryStore()()
+
+	simulateGrid(t, Simulation{
+		Group: "selftraintest",
+		Rooms: []SimulationRoom{
+			{Name: "kitchen", X1: 0, Y1: 0, X2: 10, Y2: 10},
+			{Name: "office", X1: 20, Y1: 0, X2: 30, Y2: 10},
+			{Name: "bedroom", X1: 20, Y1: 20, X2: 30, Y2: 30},
+		},
+		Users: []SimulationUser{{Name: "zack", Path: []string{"kitchen", "office", "bedroom", "office", "kitchen"}, Steps: 20}},
+	})
+
+	settings := getSelfTrainingSettings("selftraintest")
+	assert.False(t, settings.Enabled)
+	fingerprintsInMemory, fingerprintsOrdering, _ := getFingerprintsInMemory("selftraintest")
+	var ps = *NewFullParameters()
+	getParameters("selftraintest", &ps, fingerprintsInMemory, fingerprintsOrdering)
+	assert.Equal(t, 0, calculatePriorsSelfTraining("selftraintest", &ps, fingerprintsInMemory, fingerprintsOrdering))
+
+	// the guesses of tracked fingerprints in runs of confident guesses are
+	// mostly right, apart from those taken between rooms
+	settings.Enabled = true
+	assert.Nil(t, setSelfTrainingSettings("selftraintest", settings))
+	tracks := make(map[string]Fingerprint)
+	storage.ForEachFingerprint("selftraintest", "fingerprints-track", false, func(k string, v Fingerprint) bool {
+		tracks["selftraining/"+k] = v
+		return true
+	})
+	pseudo := selfTrainingFingerprints("selftraintest", ps, settings)
+	assert.True(t, len(pseudo) > 20)
+	correct, inRooms := 0, 0
+	for k, v := range pseudo {
+		if len(tracks[k].Location) > 0 {
+			inRooms++
+		}
+		if tracks[k].Location == v.Location {
+			correct++
+		}
+	}
+	assert.True(t, float64(correct)/float64(inRooms) > 0.9)
+
+	learnedMacFreq := ps.Priors["0"].MacFreq
+	assert.Equal(t, 3, len(learnedMacFreq))
+	assert.Equal(t, len(pseudo), calculatePriorsSelfTraining("selftraintest", &ps, fingerprintsInMemory, fingerprintsOrdering))
+	// only the RSSI distributions learn from the tracked fingerprints
+	assert.Equal(t, learnedMacFreq, ps.Priors["0"].MacFreq)
+	assert.Nil(t, optimizePriorsThreaded("selftraintest"))
+
+	// a stricter threshold and longer runs use fewer tracked fingerprints
+	settings.Threshold = 0.95
+	settings.MinStable = 10
+	assert.True(t, len(selfTrainingFingerprints("selftraintest", ps, settings)) < len(pseudo))
+
+	settings.Weight = 0
+	assert.NotNil(t, setSelfTrainingSettings("selftraintest", settings))
+	settings.Weight = 0.3
+	settings.Threshold = 2
+	assert.NotNil(t, setSelfTrainingSettings("selftraintest", settings))
+}
+
+func TestPosteriorConfidence(t *testing.T) {
+	defer useMemoryStore()()
+
+	s := simulateGrid(t, Simulation{
+		Group: "confidencetest",
+		Rooms: []SimulationRoom{
+			{Name: "kitchen", X1: 0, Y1: 0, X2: 10, Y2: 10},
+			{Name: "office", X1: 20, Y1: 0, X2: 30, Y2: 10},
+		},
+	})
+	// the optimizer picks any of the MixIns that classify the grid perfectly,
+	// so fix it to keep the confidences the same from run to run
+	assert.Nil(t, setMixinOverride("confidencetest", 0.5))
+	assert.Nil(t, optimizePriorsThreaded("confidencetest"))
+	ps, err := openParameters("confidencetest")
+	assert.Nil(t, err)
+	noise := float64(0)
+	s.Noise = &noise
+	r := rand.New(rand.NewSource(2))
+	settings := NewSelfTrainingSettings()
+
+	// in the middle of a room the guess is certain, halfway between the rooms it is a toss-up
+	guess, confidence := posteriorConfidence(Fingerprint{Group: "confidencetest", WifiFingerprint: s.scan(r, 5, 5)}, ps)
+	assert.Equal(t, "kitchen", guess)
+	assert.True(t, confidence > 0.99, "confidence is %2.3f", confidence)
+	_, confidence = posteriorConfidence(Fingerprint{Group: "confidencetest", WifiFingerprint: s.scan(r, 15, 5)}, ps)
+	assert.True(t, confidence >= 0.5 && confidence < settings.Threshold, "confidence is %2.3f", confidence)
+
+	// so a user waiting between the rooms is not learned from, however long they stay
+	start := time.Now().Add(-time.Hour)
+	tracks := []Fingerprint{}
+	for i := 0; i < 10; i++ {
+		tracks = append(tracks,
+			Fingerprint{Username: "zack", Timestamp: start.Add(time.Duration(i) * time.Second).UnixNano(), WifiFingerprint: s.scan(r, 15, 5)},
+			Fingerprint{Username: "bob", Timestamp: start.Add(time.Duration(i) * time.Second).UnixNano(), WifiFingerprint: s.scan(r, 5, 5)})
+	}
+	_, err = insertSimulated("confidencetest", "fingerprints-track", tracks)
+	assert.Nil(t, err)
+	pseudo := selfTrainingFingerprints("confidencetest", ps, settings)
+	assert.Equal(t, 10, len(pseudo))
+	for _, fingerprint := range pseudo {
+		assert.Equal(t, "bob", fingerprint.Username)
+		assert.Equal(t, "kitchen", fingerprint.Location)
+	}
+}
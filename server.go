@@ -17,6 +17,7 @@ import (
 	"os/exec"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/contrib/sessions"
 	"github.com/gin-contrib/cors"
@@ -40,7 +41,21 @@ var RuntimeArgs struct {
 	MosquittoPID      string
 	MqttAdminPassword string
 	Dump              string
+	Import            string
+	Export            string
+	Group             string
+	Simulate          string
 	Message           string
+	Store             string
+	Encoding          string
+	Migrate           bool
+	Evaluate          string
+	EvaluateTest      string
+	EvaluateSplit     float64
+	TrashExpiry       time.Duration
+	DriftInterval     time.Duration
+	Check             bool
+	Repair            bool
 	Mqtt              bool
 	MqttExisting      bool
 	Svm               bool
@@ -77,10 +92,24 @@ func main() {
 	flag.StringVar(&RuntimeArgs.MqttAdminPassword, "mqttadminpass", "", "admin to read all messages")
 	flag.StringVar(&RuntimeArgs.MosquittoPID, "mosquitto", "", "mosquitto PID (`pgrep mosquitto`)")
 	flag.StringVar(&RuntimeArgs.Dump, "dump", "", "group to dump to folder")
+	flag.StringVar(&RuntimeArgs.Import, "import", "", "CSV (UJIIndoorLoc-style) or NDJSON file to learn into -group and exit")
+	flag.StringVar(&RuntimeArgs.Export, "export", "", "CSV (UJIIndoorLoc-style) or NDJSON file to write the learned fingerprints of -group to and exit")
+	flag.StringVar(&RuntimeArgs.Group, "group", "", "group for -import and -export")
+	flag.StringVar(&RuntimeArgs.Simulate, "simulate", "", "JSON file of a simulation to generate fingerprints from and exit (see testing/simulation.json)")
 	flag.StringVar(&RuntimeArgs.Message, "message", "", "message to display to all users")
 	flag.StringVar(&RuntimeArgs.SourcePath, "data", "", "path to data folder")
 	flag.StringVar(&RuntimeArgs.RFPort, "rf", "", "port for random forests calculations")
 	flag.StringVar(&RuntimeArgs.FilterMacFile, "filter", "", "JSON file for macs to filter")
+	flag.StringVar(&RuntimeArgs.Store, "store", "bolt", "storage backend (bolt or memory)")
+	flag.StringVar(&RuntimeArgs.Encoding, "encoding", "json", "encoding of new fingerprints (json or binary)")
+	flag.DurationVar(&RuntimeArgs.TrashExpiry, "trash", 7*24*time.Hour, "how long deleted data can be restored for")
+	flag.DurationVar(&RuntimeArgs.DriftInterval, "drift", time.Hour, "how often to check every group for access point drift")
+	flag.BoolVar(&RuntimeArgs.Check, "check", false, "check the data of all groups for problems and exit")
+	flag.BoolVar(&RuntimeArgs.Repair, "repair", false, "with -check, drop bad records and regenerate parameters")
+	flag.BoolVar(&RuntimeArgs.Migrate, "migrate", false, "migrate stored values of all groups to the current format and encoding before starting")
+	flag.StringVar(&RuntimeArgs.Evaluate, "evaluate", "", "group or NDJSON file of learned fingerprints to evaluate the classifiers on and exit")
+	flag.StringVar(&RuntimeArgs.EvaluateTest, "evaltest", "", "with -evaluate, group or NDJSON file to test on instead of holding out fingerprints")
+	flag.Float64Var(&RuntimeArgs.EvaluateSplit, "evalsplit", 0.2, "with -evaluate, fraction of fingerprints held out for testing")
 	flag.CommandLine.Usage = func() {
 		fmt.Println(`find (version ` + VersionNum + ` (` + Build[0:8] + `), built ` + BuildTime + `)
 Example: 'findserver yourserver.com'
@@ -100,6 +129,18 @@ Options:`)
 	}
 	fmt.Println(RuntimeArgs.SourcePath)
 
+	var err error
+	storage, err = newStore(RuntimeArgs.Store)
+	if err != nil {
+		panic(err)
+	}
+	if RuntimeArgs.Encoding != "json" && RuntimeArgs.Encoding != "binary" {
+		panic("unknown encoding '" + RuntimeArgs.Encoding + "', use json or binary")
+	}
+	if RuntimeArgs.DriftInterval <= 0 {
+		panic("-drift must be more than 0")
+	}
+
 	// Check whether all the MQTT variables are passed to initiate the MQTT routines
 	if len(RuntimeArgs.MqttServer) > 0 && len(RuntimeArgs.MqttAdmin) > 0 && len(RuntimeArgs.MosquittoPID) > 0 {
 		RuntimeArgs.Mqtt = true
@@ -141,6 +182,69 @@ Options:`)
 		os.Exit(1)
 	}
 
+	// Check whether we are just importing or exporting a dataset
+	if len(RuntimeArgs.Import) > 0 {
+		result, err := importDataset(RuntimeArgs.Import, strings.ToLower(RuntimeArgs.Group))
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, lineErr := range result.Errors {
+			fmt.Printf("Line %d: %s\n", lineErr.Line, lineErr.Message)
+		}
+		fmt.Printf("Inserted %d of %d fingerprints into %s.\n", result.Inserted, result.Lines, strings.Join(result.Groups, ", "))
+		os.Exit(0)
+	}
+	if len(RuntimeArgs.Export) > 0 {
+		exported, err := exportDataset(RuntimeArgs.Export, strings.ToLower(RuntimeArgs.Group))
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Exported %d fingerprints.\n", exported)
+		os.Exit(0)
+	}
+
+	// Check whether we are just generating fingerprints
+	if len(RuntimeArgs.Simulate) > 0 {
+		simulation, err := loadSimulation(RuntimeArgs.Simulate)
+		if err != nil {
+			log.Fatal(err)
+		}
+		learned, tracked, err := runSimulation(simulation)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Generated %d learn and %d track fingerprints in %s.\n", learned.Inserted, tracked.Inserted, strings.ToLower(simulation.Group))
+		os.Exit(0)
+	}
+
+	// Check whether we are just checking the database
+	if RuntimeArgs.Check {
+		reports, err := checkAllGroups(RuntimeArgs.Repair)
+		if err != nil {
+			log.Fatal(err)
+		}
+		problems := false
+		for _, report := range reports {
+			fmt.Println(report.String())
+			if !report.ok() && !report.Repaired {
+				problems = true
+			}
+		}
+		if problems {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Check whether stored values should be migrated
+	if RuntimeArgs.Migrate {
+		err := migrateAllGroups()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println("Successfully migrated.")
+	}
+
 	// Check if there is a message from the admin
 	if _, err := os.Stat(path.Join(RuntimeArgs.Cwd, "message.txt")); err == nil {
 		messageByte, _ := ioutil.ReadFile(path.Join(RuntimeArgs.Cwd, "message.txt"))
@@ -165,6 +269,16 @@ cp svm-train /usr/local/bin/`)
 		RuntimeArgs.Svm = true
 	}
 
+	// Check whether we are just evaluating the classifiers
+	if len(RuntimeArgs.Evaluate) > 0 {
+		report, err := runEvaluation(RuntimeArgs.Evaluate, RuntimeArgs.EvaluateTest, RuntimeArgs.EvaluateSplit)
+		if err != nil {
+			log.Fatal(err)
+		}
+		report.print(os.Stdout)
+		os.Exit(0)
+	}
+
 	// Setup Gin-Gonic
 	gin.SetMode(gin.ReleaseMode)
 	r := gin.Default()
@@ -203,6 +317,16 @@ cp svm-train /usr/local/bin/`)
 	// Routes for performing fingerprinting (fingerprint.go)
 	r.POST("/learn", learnFingerprintPOST)
 	r.POST("/track", trackFingerprintPOST)
+	r.PUT("/learn/session", putSession)
+	r.GET("/learn/session", getSessionProgress)
+	r.DELETE("/learn/session", deleteSession)
+
+	// Routes for bulk imports (bulk.go)
+	r.POST("/learn/bulk", bulkLearnPOST)
+	r.POST("/track/bulk", bulkTrackPOST)
+
+	// Routes for synthetic fingerprints (simulate.go)
+	r.POST("/simulate", simulatePOST)
 
 	// Routes for MQTT (mqtt.go)
 	r.PUT("/mqtt", putMQTT)
@@ -224,6 +348,38 @@ cp svm-train /usr/local/bin/`)
 	r.PUT("/mixin", putMixinOverride)
 	r.PUT("/cutoff", putCutoffOverride)
 	r.PUT("/database", migrateDatabase)
+	r.POST("/database/merge", mergeDatabasePOST)
+	r.GET("/trash", getTrash)
+	r.PUT("/trash", putTrash)
+	r.DELETE("/trash", deleteTrash)
+	r.GET("/check", getCheck)
+	r.GET("/suggestions", getSuggestions)
+	r.GET("/parameters/snapshots", getParameterSnapshots)
+	r.PUT("/parameters/rollback", putParameterRollback)
+	r.DELETE("/parameters/pin", deleteParameterPin)
+	r.GET("/shadow", getShadowStats)
+	r.PUT("/shadow", putShadow)
+	r.DELETE("/shadow", deleteShadow)
+	r.GET("/mislabeled", getMislabeled)
+	r.PUT("/mislabeled", putMislabeled)
+	r.DELETE("/mislabeled", deleteMislabeled)
+	r.PUT("/tracks/relabel", putRelabelTracks)
+	r.GET("/selftraining", getSelfTraining)
+	r.PUT("/selftraining", putSelfTraining)
+	r.GET("/discover", getDiscoveredPlaces)
+	r.PUT("/discover", putDiscoveredPlace)
+	r.GET("/drift", getDrift)
+	r.PUT("/drift", putDrift)
+	r.GET("/explain", getExplain)
+	r.POST("/explain", explainPOST)
+	r.GET("/sweep", getSweep)
+	r.GET("/userpriors", getUserPriors)
+	r.PUT("/userpriors", putUserPriors)
+	r.GET("/graph", getGraph)
+	r.PUT("/graph", putGraph)
+	r.DELETE("/graph", deleteGraph)
+	r.GET("/route", getRoute)
+	r.GET("/predict", getPrediction)
 	r.GET("/lastfingerprint", apiGetLastFingerprint)
 
 	// clquebec endpoints
@@ -240,6 +396,15 @@ cp svm-train /usr/local/bin/`)
 	// Run automation checker
 	go checkAutomation()
 
+	// Purge expired things from the trash
+	go purgeTrashEvery(time.Hour)
+
+	// Check for access points that were replaced or moved
+	go checkDriftEvery(RuntimeArgs.DriftInterval)
+
+	// Learn where users usually are with the current parameters
+	go refreshUserPriorsEvery(time.Hour)
+
 	// Check whether user is providing certificates
 	if RuntimeArgs.Socket != "" {
 		r.RunUnix(RuntimeArgs.Socket)
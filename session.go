@@ -0,0 +1,285 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// session.go contains guided learning sessions, which follow the fingerprints
+// a user learns at a location until a target count or duration is reached and
+// then summarize their quality. Sessions are only kept in memory, so they
+// are lost on restart, and are forgotten sessionExpiry after their last use.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sessionMinVariability is the average RSSI standard deviation in dB below
+// which the fingerprints of a session were probably all taken at one spot
+const sessionMinVariability = 1.5
+
+// sessionExpiry is how long a session is kept after it closed or, if it is
+// still open, after its last fingerprint
+const sessionExpiry = 24 * time.Hour
+
+// LearningSession follows the fingerprints a user learns at a location
+type LearningSession struct {
+	Group        string          `json:"group"`
+	Username     string          `json:"username"`
+	Location     string          `json:"location"`
+	Target       int             `json:"target"`
+	Duration     float64         `json:"duration"` // seconds, 0 if the session only ends at the target
+	Started      time.Time       `json:"started"`
+	Ended        time.Time       `json:"ended"`
+	Open         bool            `json:"open"`
+	Fingerprints int             `json:"fingerprints"`
+	Remaining    int             `json:"remaining"`
+	APs          int             `json:"aps"` // distinct access points seen so far
+	Summary      *SessionSummary `json:"summary,omitempty"`
+	rssis        map[string][]float64
+	numAPs       []float64
+	updated      time.Time
+}
+
+// SessionSummary describes the quality of the fingerprints of a session
+type SessionSummary struct {
+	Fingerprints    int      `json:"fingerprints"`
+	APs             int      `json:"aps"`
+	ReliableAPs     int      `json:"reliable_aps"` // seen in at least half of the fingerprints
+	MeanAPs         float64  `json:"mean_aps"`     // per fingerprint
+	RSSIVariability float64  `json:"rssi_variability"`
+	Warnings        []string `json:"warnings"`
+	Good            bool     `json:"good"`
+}
+
+// learningSessions holds the latest session of each user, by group and username
+var learningSessions = struct {
+	sync.Mutex
+	m map[string]*LearningSession
+}{m: make(map[string]*LearningSession)}
+
+func sessionKey(group string, username string) string {
+	return group + "/" + username
+}
+
+// startSession starts a session, replacing any previous one of the user, and
+// returns a copy of it.
+func startSession(group string, username string, location string, target int, duration time.Duration) LearningSession {
+	s := &LearningSession{
+		Group:     group,
+		Username:  username,
+		Location:  location,
+		Target:    target,
+		Duration:  duration.Seconds(),
+		Started:   time.Now(),
+		updated:   time.Now(),
+		Open:      true,
+		Remaining: target,
+		rssis:     make(map[string][]float64),
+		numAPs:    []float64{},
+	}
+	learningSessions.Lock()
+	defer learningSessions.Unlock()
+	learningSessions.m[sessionKey(group, username)] = s
+	return *s
+}
+
+// getSession returns a copy of the latest session of a user, closing it if its time is up.
+func getSession(group string, username string) (LearningSession, bool) {
+	learningSessions.Lock()
+	defer learningSessions.Unlock()
+	s, ok := learningSessions.m[sessionKey(group, username)]
+	if !ok {
+		return LearningSession{}, false
+	}
+	if s.Open && s.expired(time.Now()) {
+		s.close()
+	}
+	return *s, true
+}
+
+// closeSession ends the session of a user and returns it with its summary.
+func closeSession(group string, username string) (LearningSession, error) {
+	learningSessions.Lock()
+	defer learningSessions.Unlock()
+	s, ok := learningSessions.m[sessionKey(group, username)]
+	if !ok {
+		return LearningSession{}, fmt.Errorf("%s has no learning session in %s", username, group)
+	}
+	if s.Open {
+		s.close()
+	}
+	return *s, nil
+}
+
+// recordSessionFingerprint counts a learned fingerprint towards the open
+// session of its user, if it is at the location of the session. A fingerprint
+// at another location is not counted, but the session is still returned so
+// that the mismatch can be reported.
+func recordSessionFingerprint(fingerprint Fingerprint) (LearningSession, bool) {
+	learningSessions.Lock()
+	defer learningSessions.Unlock()
+	s, ok := learningSessions.m[sessionKey(fingerprint.Group, fingerprint.Username)]
+	if !ok || !s.Open {
+		return LearningSession{}, false
+	}
+	if s.expired(time.Now()) {
+		s.close()
+		return *s, true
+	}
+	if s.Location != fingerprint.Location {
+		return *s, true
+	}
+	s.updated = time.Now()
+	s.Fingerprints++
+	s.Remaining = s.Target - s.Fingerprints
+	s.numAPs = append(s.numAPs, float64(len(fingerprint.WifiFingerprint)))
+	for _, router := range fingerprint.WifiFingerprint {
+		s.rssis[router.Mac] = append(s.rssis[router.Mac], float64(router.Rssi))
+	}
+	s.APs = len(s.rssis)
+	if s.Remaining <= 0 {
+		s.Remaining = 0
+		s.close()
+	}
+	return *s, true
+}
+
+// purgeSessions forgets the sessions that were last used more than sessionExpiry
+// before now, and returns how many there were.
+func purgeSessions(now time.Time) int {
+	learningSessions.Lock()
+	defer learningSessions.Unlock()
+	purged := 0
+	for key, s := range learningSessions.m {
+		lastUsed := s.updated
+		if !s.Open {
+			lastUsed = s.Ended
+		}
+		if now.Sub(lastUsed) > sessionExpiry {
+			delete(learningSessions.m, key)
+			purged++
+		}
+	}
+	return purged
+}
+
+func (s *LearningSession) expired(now time.Time) bool {
+	return s.Duration > 0 && now.Sub(s.Started).Seconds() >= s.Duration
+}
+
+func (s *LearningSession) close() {
+	s.Open = false
+	s.Ended = time.Now()
+	summary := &SessionSummary{Fingerprints: s.Fingerprints, APs: len(s.rssis), Warnings: []string{}}
+	variabilities := []float64{}
+	for _, rssis := range s.rssis {
+		if 2*len(rssis) >= s.Fingerprints && len(rssis) > 1 {
+			summary.ReliableAPs++
+			variabilities = append(variabilities, standardDeviation64(rssis))
+		}
+	}
+	if len(s.numAPs) > 0 {
+		summary.MeanAPs = average64(s.numAPs)
+	}
+	if len(variabilities) > 0 {
+		summary.RSSIVariability = average64(variabilities)
+	}
+
+	if s.Fingerprints < s.Target {
+		summary.Warnings = append(summary.Warnings, fmt.Sprintf("%d fingerprints short of the target", s.Target-s.Fingerprints))
+	}
+	if summary.ReliableAPs < suggestMinAPs {
+		summary.Warnings = append(summary.Warnings, fmt.Sprintf("only %d access points seen reliably", summary.ReliableAPs))
+	}
+	if len(variabilities) > 0 && summary.RSSIVariability < sessionMinVariability {
+		summary.Warnings = append(summary.Warnings, "signals barely changed, walk around the location while learning")
+	}
+	summary.Good = len(summary.Warnings) == 0
+	s.Summary = summary
+}
+
+// String describes the progress of the session for the response to a learned fingerprint.
+func (s LearningSession) String() string {
+	if s.Open {
+		return strconv.Itoa(s.Remaining) + " more for the session at " + s.Location
+	}
+	if s.Summary.Good {
+		return "session at " + s.Location + " is done"
+	}
+	return "session at " + s.Location + " is done: " + strings.Join(s.Summary.Warnings, ", ")
+}
+
+// putSession usage: curl -X PUT "http://localhost:8003/learn/session?group=X&user=Y&location=Z&target=100&duration=5m"
+func putSession(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	user := strings.TrimSpace(strings.ToLower(c.DefaultQuery("user", "noneasdf")))
+	location := strings.TrimSpace(strings.ToLower(c.DefaultQuery("location", "noneasdf")))
+	if group == "noneasdf" || user == "noneasdf" || location == "noneasdf" {
+		c.JSON(http.StatusOK, gin.H{"message": "Need to provide group, user and location", "success": false})
+		return
+	}
+	target, err := strconv.Atoi(c.DefaultQuery("target", strconv.Itoa(suggestMinFingerprints)))
+	if err != nil || target <= 0 {
+		c.JSON(http.StatusOK, gin.H{"message": "Target must be a positive number of fingerprints", "success": false})
+		return
+	}
+	duration, err := time.ParseDuration(c.DefaultQuery("duration", "0s"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not parse duration: " + err.Error(), "success": false})
+		return
+	}
+	s := startSession(group, user, location, target, duration)
+	c.JSON(http.StatusOK, gin.H{"message": "Started learning " + strconv.Itoa(target) + " fingerprints at " + location + " for " + user, "success": true, "session": s})
+}
+
+// getSessionProgress usage: curl "http://localhost:8003/learn/session?group=X&user=Y"
+func getSessionProgress(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	user := strings.TrimSpace(strings.ToLower(c.DefaultQuery("user", "noneasdf")))
+	s, ok := getSession(group, user)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"message": user + " has no learning session in " + group, "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": s.String(), "success": true, "session": s})
+}
+
+// deleteSession usage: curl -X DELETE "http://localhost:8003/learn/session?group=X&user=Y"
+func deleteSession(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	user := strings.TrimSpace(strings.ToLower(c.DefaultQuery("user", "noneasdf")))
+	s, err := closeSession(group, user)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": s.String(), "success": true, "session": s})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLearningSession(t *testing.T) {
+	defer useMemoryStore()()
+
+	startSession("sessiontest", "zack", "kitchen", 3, 0)
+	for i := 0; i < 4; i++ {
+		message, success := learnFingerprint(Fingerprint{Group: "sessiontest", Username: "zack", Location: "kitchen", Timestamp: int64(i + 1),
+			WifiFingerprint: []Router{{Mac: "aa", Rssi: -40 - 3*i}, {Mac: "bb", Rssi: -60 + 2*i}, {Mac: "cc", Rssi: -70 - 4*i}}})
+		assert.True(t, success)
+		switch i {
+		case 0:
+			assert.True(t, strings.HasSuffix(message, ", 2 more for the session at kitchen"), message)
+		case 2:
+			assert.True(t, strings.HasSuffix(message, ", session at kitchen is done"), message)
+		case 3:
+			assert.False(t, strings.Contains(message, "for the session"), message)
+		}
+	}
+	s, ok := getSession("sessiontest", "zack")
+	assert.True(t, ok)
+	assert.False(t, s.Open)
+	assert.Equal(t, 3, s.Fingerprints)
+	assert.Equal(t, 3, s.Summary.ReliableAPs)
+	assert.True(t, s.Summary.Good)
+
+	// standing still with one access point in view
+	startSession("sessiontest", "zack", "den", 10, time.Hour)
+	message, _ := learnFingerprint(Fingerprint{Group: "sessiontest", Username: "zack", Location: "kitchen", Timestamp: 9, WifiFingerprint: []Router{{Mac: "aa", Rssi: -80}}})
+	assert.True(t, strings.HasSuffix(message, ", not counted for the session at den"), message)
+	learnFingerprint(Fingerprint{Group: "sessiontest", Username: "zack", Location: "den", Timestamp: 10, WifiFingerprint: []Router{{Mac: "aa", Rssi: -80}}})
+	learnFingerprint(Fingerprint{Group: "sessiontest", Username: "zack", Location: "den", Timestamp: 11, WifiFingerprint: []Router{{Mac: "aa", Rssi: -80}}})
+	s, err := closeSession("sessiontest", "zack")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, s.Fingerprints)
+	assert.Equal(t, []string{"8 fingerprints short of the target", "only 1 access points seen reliably", "signals barely changed, walk around the location while learning"}, s.Summary.Warnings)
+
+	startSession("sessiontest", "zack", "den", 10, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	s, _ = getSession("sessiontest", "zack")
+	assert.False(t, s.Open)
+	_, err = closeSession("sessiontest", "nobody")
+	assert.NotNil(t, err)
+
+	// closed sessions are forgotten after a while
+	assert.Equal(t, 0, purgeSessions(time.Now()))
+	assert.Equal(t, 1, purgeSessions(time.Now().Add(sessionExpiry+time.Minute)))
+	_, ok = getSession("sessiontest", "zack")
+	assert.False(t, ok)
+}
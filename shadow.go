@@ -0,0 +1,268 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// shadow.go classifies tracked fingerprints with a candidate snapshot of the
+// parameters alongside the active ones, to compare them before promoting the candidate.
+//
+// To try new learning data, pin the active parameters (see snapshots.go),
+// learn and recalculate, and shadow the newest snapshot.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	registerResources("parameterShadow")
+}
+
+// maxShadowDisagreements is the number of recent disagreements kept per group
+const maxShadowDisagreements = 100
+
+// ShadowDisagreement is a tracked fingerprint that the active and candidate parameters classified differently
+type ShadowDisagreement struct {
+	Time      time.Time `json:"time"`
+	Username  string    `json:"username"`
+	Active    string    `json:"active"`
+	Candidate string    `json:"candidate"`
+	Location  string    `json:"location,omitempty"` // location sent with the fingerprint, if any
+}
+
+// ShadowStats compares the active and candidate parameters of a group on tracked fingerprints.
+// Fingerprints that were sent with a location are used to measure accuracy.
+type ShadowStats struct {
+	Version          int                  `json:"version"`
+	Started          time.Time            `json:"started"`
+	Tracked          int                  `json:"tracked"`
+	Agreed           int                  `json:"agreed"`
+	Labelled         int                  `json:"labelled"`
+	ActiveCorrect    int                  `json:"active_correct"`
+	CandidateCorrect int                  `json:"candidate_correct"`
+	Disagreements    []ShadowDisagreement `json:"disagreements"`
+}
+
+type shadowState struct {
+	ps    FullParameters
+	stats ShadowStats
+}
+
+// shadows holds the shadow of each group, or nil if the group has none
+var shadows = struct {
+	sync.RWMutex
+	m map[string]*shadowState
+}{m: make(map[string]*shadowState)}
+
+// getShadow returns the shadow of group, loading it if the server restarted since it was started.
+func getShadow(group string) *shadowState {
+	shadows.RLock()
+	state, ok := shadows.m[group]
+	shadows.RUnlock()
+	if ok {
+		return state
+	}
+	v, _ := storage.GetResource(group, "parameterShadow")
+	version, err := strconv.Atoi(string(v))
+	if err == nil {
+		state, err = newShadowState(group, version)
+		if err != nil {
+			Warning.Println(err)
+		}
+	}
+	shadows.Lock()
+	shadows.m[group] = state
+	shadows.Unlock()
+	return state
+}
+
+func newShadowState(group string, version int) (*shadowState, error) {
+	v, err := storage.GetResource(group, snapshotKey(version))
+	if err != nil || v == nil {
+		return nil, fmt.Errorf("version %d of the parameters of %s does not exist", version, group)
+	}
+	return &shadowState{
+		ps:    loadParameters(v),
+		stats: ShadowStats{Version: version, Started: time.Now(), Disagreements: []ShadowDisagreement{}},
+	}, nil
+}
+
+// startShadow starts classifying the tracked fingerprints of group with a
+// snapshot of the parameters, the newest one if version is 0.
+func startShadow(group string, version int) (int, error) {
+	if version == 0 {
+		snapshots, _, _ := openParameterSnapshots(group)
+		if len(snapshots) == 0 {
+			return 0, fmt.Errorf("%s has no parameter snapshots", group)
+		}
+		version = snapshots[len(snapshots)-1].Version
+	}
+	state, err := newShadowState(group, version)
+	if err != nil {
+		return version, err
+	}
+	err = storage.PutResources(group, map[string][]byte{"parameterShadow": []byte(strconv.Itoa(version))})
+	if err != nil {
+		return version, err
+	}
+	shadows.Lock()
+	shadows.m[group] = state
+	shadows.Unlock()
+	return version, nil
+}
+
+// stopShadow stops the shadow of group and returns its final stats.
+func stopShadow(group string) (ShadowStats, error) {
+	state := getShadow(group)
+	if state == nil {
+		return ShadowStats{}, fmt.Errorf("%s has no shadow running", group)
+	}
+	shadows.Lock()
+	stats := state.stats
+	shadows.m[group] = nil
+	shadows.Unlock()
+	return stats, storage.DeleteResources(group, []string{"parameterShadow"})
+}
+
+// forgetShadow drops the shadow of group from memory, for when the group is
+// deleted or replaced. It is loaded again from the parameterShadow resource.
+func forgetShadow(group string) {
+	shadows.Lock()
+	delete(shadows.m, group)
+	shadows.Unlock()
+}
+
+// shadowClassify classifies a tracked fingerprint with the candidate parameters of its
+// group, if there are any, and compares it to the location guessed by the active ones
+// before any jump penalty, which only depends on where the user was tracked before.
+// Both use the prior of the user, as tracking does.
+func shadowClassify(fingerprint Fingerprint, label string, activeGuess string) {
+	group := strings.ToLower(fingerprint.Group)
+	state := getShadow(group)
+	if state == nil {
+		return
+	}
+	candidateGuess, _ := calculatePosteriorWithPrior(fingerprint, state.ps, userLocationPrior(fingerprint, state.ps))
+
+	shadows.Lock()
+	defer shadows.Unlock()
+	stats := &state.stats
+	stats.Tracked++
+	if candidateGuess == activeGuess {
+		stats.Agreed++
+	} else {
+		Debug.Printf("Shadow of %s disagrees for %s: %s (active) != %s (version %d)", group, fingerprint.Username, activeGuess, candidateGuess, stats.Version)
+		stats.Disagreements = append(stats.Disagreements, ShadowDisagreement{
+			Time:      time.Now(),
+			Username:  fingerprint.Username,
+			Active:    activeGuess,
+			Candidate: candidateGuess,
+			Location:  label,
+		})
+		if len(stats.Disagreements) > maxShadowDisagreements {
+			stats.Disagreements = stats.Disagreements[1:]
+		}
+	}
+	if len(label) > 0 {
+		stats.Labelled++
+		if activeGuess == label {
+			stats.ActiveCorrect++
+		}
+		if candidateGuess == label {
+			stats.CandidateCorrect++
+		}
+	}
+}
+
+// getShadowStats usage: curl "http://localhost:8003/shadow?group=X"
+func getShadowStats(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "Group does not exist", "success": false})
+		return
+	}
+	state := getShadow(group)
+	if state == nil {
+		c.JSON(http.StatusOK, gin.H{"message": group + " has no shadow running", "success": false})
+		return
+	}
+	shadows.RLock()
+	stats := state.stats
+	stats.Disagreements = append([]ShadowDisagreement{}, stats.Disagreements...)
+	shadows.RUnlock()
+	agreement := 0
+	if stats.Tracked > 0 {
+		agreement = 100 * stats.Agreed / stats.Tracked
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Version " + strconv.Itoa(stats.Version) + " agreed on " + strconv.Itoa(agreement) + "% of " + strconv.Itoa(stats.Tracked) + " tracked fingerprints", "success": true, "shadow": stats})
+}
+
+// putShadow usage: curl -X PUT "http://localhost:8003/shadow?group=X&version=3"
+func putShadow(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "Group does not exist", "success": false})
+		return
+	}
+	version, _ := strconv.Atoi(c.DefaultQuery("version", "0"))
+	version, err := startShadow(group, version)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Shadowing " + group + " with version " + strconv.Itoa(version), "success": true})
+}
+
+// deleteShadow usage: curl -X DELETE "http://localhost:8003/shadow?group=X&promote=true"
+// stops the shadow, and with promote=true makes the candidate the active parameters.
+func deleteShadow(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "Group does not exist", "success": false})
+		return
+	}
+	stats, err := stopShadow(group)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	message := "Stopped shadowing " + group
+	if c.DefaultQuery("promote", "false") == "true" {
+		_, _, pinned := openParameterSnapshots(group)
+		err = rollbackParameters(group, stats.Version, pinned != 0)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false, "shadow": stats})
+			return
+		}
+		message += " and promoted version " + strconv.Itoa(stats.Version)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "success": true, "shadow": stats})
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShadowClassify(t *testing.T) {
+	defer useMemoryStore()()
+
+	fingerprints := []Fingerprint{}
+	for i := 0; i < 10; i++ {
+		fingerprints = append(fingerprints,
+			Fingerprint{Group: "shadowtest", Location: "kitchen", Timestamp: int64(2 * i), WifiFingerprint: []Router{{Mac: "aa", Rssi: -40 - i}, {Mac: "bb", Rssi: -90}}},
+			Fingerprint{Group: "shadowtest", Location: "den", Timestamp: int64(2*i + 1), WifiFingerprint: []Router{{Mac: "aa", Rssi: -90}, {Mac: "bb", Rssi: -40 - i}}})
+	}
+	storage.PutFingerprints("shadowtest", "fingerprints", fingerprints)
+	recalculateGroup("shadowtest")
+	assert.Nil(t, rollbackParameters("shadowtest", 1, true))
+
+	// the candidate learned the kitchen as the office
+	updateFingerprintsWhere("shadowtest", "fingerprints", func(fingerprint *Fingerprint) bool {
+		if fingerprint.Location != "kitchen" {
+			return false
+		}
+		fingerprint.Location = "office"
+		return true
+	})
+	recalculateGroup("shadowtest")
+	_, err := startShadow("shadowtest", 3)
+	assert.NotNil(t, err)
+	version, err := startShadow("shadowtest", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+
+	kitchen := Fingerprint{Group: "shadowtest", Username: "zack", WifiFingerprint: []Router{{Mac: "aa", Rssi: -45}, {Mac: "bb", Rssi: -90}}}
+	den := Fingerprint{Group: "shadowtest", Username: "zack", WifiFingerprint: []Router{{Mac: "aa", Rssi: -90}, {Mac: "bb", Rssi: -45}}}
+	shadowClassify(kitchen, "kitchen", "kitchen")
+	shadowClassify(den, "", "den")
+	stats := getShadow("shadowtest").stats
+	assert.Equal(t, 2, stats.Tracked)
+	assert.Equal(t, 1, stats.Agreed)
+	assert.Equal(t, 1, stats.Labelled)
+	assert.Equal(t, 1, stats.ActiveCorrect)
+	assert.Equal(t, 0, stats.CandidateCorrect)
+	assert.Equal(t, "office", stats.Disagreements[0].Candidate)
+
+	// the shadow is loaded again after a restart
+	forgetShadow("shadowtest")
+	stats, err = stopShadow("shadowtest")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, stats.Version)
+	assert.Equal(t, 0, stats.Tracked)
+	assert.Nil(t, getShadow("shadowtest"))
+	_, err = stopShadow("shadowtest")
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,280 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// simulate.go generates synthetic fingerprints from access point positions,
+// a room layout and a log-distance path loss model with noise, for testing
+// the classifiers and for demos. See testing/simulation.json for an example.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SimulationAP is an access point at a position in meters
+type SimulationAP struct {
+	Mac   string  `json:"mac"`
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Power float64 `json:"power"` // RSSI at 1 meter, -40 if not set
+}
+
+// SimulationRoom is a rectangular location
+type SimulationRoom struct {
+	Name string  `json:"name"`
+	X1   float64 `json:"x1"`
+	Y1   float64 `json:"y1"`
+	X2   float64 `json:"x2"`
+	Y2   float64 `json:"y2"`
+}
+
+// SimulationUser walks through the centers of rooms, taking Steps fingerprints between each of them
+type SimulationUser struct {
+	Name  string   `json:"name"`
+	Path  []string `json:"path"`
+	Steps int      `json:"steps"`
+}
+
+// Limits of a simulation, so that a request can not generate unbounded data
+const (
+	simulateMaxAPs     = 1000
+	simulateMaxRooms   = 1000
+	simulateMaxSamples = 100000 // learned and tracked fingerprints together
+)
+
+// Simulation describes what to generate. Zero values are replaced by defaults,
+// except for the pointers, which only are if they are not set.
+type Simulation struct {
+	Group            string           `json:"group"`
+	APs              []SimulationAP   `json:"aps"`
+	Rooms            []SimulationRoom `json:"rooms"`
+	Users            []SimulationUser `json:"users"`
+	LearnPerRoom     *int             `json:"learn_per_room"`     // 20 if not set (0 only tracks)
+	PathLossExponent float64          `json:"path_loss_exponent"` // 3
+	Noise            *float64         `json:"noise"`              // standard deviation in dB, 4 if not set (0 is noise free)
+	Sensitivity      float64          `json:"sensitivity"`        // weaker signals are not seen, -95
+	Interval         float64          `json:"interval"`           // seconds between fingerprints, 1
+	Seed             int64            `json:"seed"`
+	Classify         bool             `json:"classify"` // track like /track does instead of only storing, for load testing
+}
+
+func (s *Simulation) setDefaults() {
+	if s.LearnPerRoom == nil {
+		learnPerRoom := 20
+		s.LearnPerRoom = &learnPerRoom
+	}
+	if s.PathLossExponent == 0 {
+		s.PathLossExponent = 3
+	}
+	if s.Noise == nil {
+		noise := float64(4)
+		s.Noise = &noise
+	}
+	if s.Sensitivity == 0 {
+		s.Sensitivity = -95
+	}
+	if s.Interval == 0 {
+		s.Interval = 1
+	}
+	for i := range s.APs {
+		if s.APs[i].Power == 0 {
+			s.APs[i].Power = -40
+		}
+	}
+	for i := range s.Users {
+		if s.Users[i].Steps == 0 {
+			s.Users[i].Steps = 10
+		}
+	}
+}
+
+func (s Simulation) validate() error {
+	if len(s.Group) == 0 {
+		return fmt.Errorf("need to define group name")
+	}
+	if len(s.APs) == 0 || len(s.Rooms) == 0 {
+		return fmt.Errorf("need access points and rooms")
+	}
+	if len(s.APs) > simulateMaxAPs || len(s.Rooms) > simulateMaxRooms {
+		return fmt.Errorf("can simulate at most %d access points and %d rooms", simulateMaxAPs, simulateMaxRooms)
+	}
+	if s.LearnPerRoom != nil && *s.LearnPerRoom < 0 {
+		return fmt.Errorf("learn_per_room can not be negative")
+	}
+	s.setDefaults()
+	samples := int64(*s.LearnPerRoom) * int64(len(s.Rooms))
+	for _, user := range s.Users {
+		if len(user.Path) > 1 && user.Steps > 0 {
+			samples += int64(user.Steps) * int64(len(user.Path)-1)
+		}
+		if samples > simulateMaxSamples {
+			break
+		}
+	}
+	if samples > simulateMaxSamples {
+		return fmt.Errorf("can simulate at most %d fingerprints", simulateMaxSamples)
+	}
+	for _, user := range s.Users {
+		for _, name := range user.Path {
+			if s.room(name) == nil {
+				return fmt.Errorf("%s walks through %s, which is not a room", user.Name, name)
+			}
+		}
+	}
+	return nil
+}
+
+func (s Simulation) room(name string) *SimulationRoom {
+	for i := range s.Rooms {
+		if s.Rooms[i].Name == name {
+			return &s.Rooms[i]
+		}
+	}
+	return nil
+}
+
+// roomAt returns the name of the room containing a point, or "" if it is between rooms.
+func (s Simulation) roomAt(x float64, y float64) string {
+	for _, room := range s.Rooms {
+		if x >= math.Min(room.X1, room.X2) && x <= math.Max(room.X1, room.X2) && y >= math.Min(room.Y1, room.Y2) && y <= math.Max(room.Y1, room.Y2) {
+			return room.Name
+		}
+	}
+	return ""
+}
+
+// scan returns the routers seen at a point, with the RSSI following the log-distance path loss model.
+func (s Simulation) scan(r *rand.Rand, x float64, y float64) []Router {
+	routers := []Router{}
+	for _, ap := range s.APs {
+		distance := math.Max(1, math.Hypot(x-ap.X, y-ap.Y))
+		rssi := ap.Power - 10*s.PathLossExponent*math.Log10(distance) + r.NormFloat64()*(*s.Noise)
+		if rssi >= s.Sensitivity {
+			routers = append(routers, Router{Mac: ap.Mac, Rssi: int(math.Floor(rssi + 0.5))})
+		}
+	}
+	return routers
+}
+
+// generate returns the learn fingerprints, taken at random points of each
+// room, and the track fingerprints of the users walking their paths. Track
+// fingerprints are labelled with the room they were taken in. The same
+// simulation always generates the same fingerprints.
+func (s Simulation) generate(start time.Time) ([]Fingerprint, []Fingerprint) {
+	s.setDefaults()
+	r := rand.New(rand.NewSource(s.Seed))
+	now := start
+	tick := func() int64 {
+		now = now.Add(time.Duration(s.Interval * float64(time.Second)))
+		return now.UnixNano()
+	}
+
+	learn := []Fingerprint{}
+	for _, room := range s.Rooms {
+		for i := 0; i < *s.LearnPerRoom; i++ {
+			x := room.X1 + r.Float64()*(room.X2-room.X1)
+			y := room.Y1 + r.Float64()*(room.Y2-room.Y1)
+			learn = append(learn, Fingerprint{Group: s.Group, Username: "simulator", Location: room.Name, Timestamp: tick(), WifiFingerprint: s.scan(r, x, y)})
+		}
+	}
+
+	track := []Fingerprint{}
+	for _, user := range s.Users {
+		for i := 0; i+1 < len(user.Path); i++ {
+			from, to := s.room(user.Path[i]), s.room(user.Path[i+1])
+			x1, y1 := (from.X1+from.X2)/2, (from.Y1+from.Y2)/2
+			x2, y2 := (to.X1+to.X2)/2, (to.Y1+to.Y2)/2
+			for step := 0; step < user.Steps; step++ {
+				f := float64(step) / float64(user.Steps)
+				x, y := x1+f*(x2-x1), y1+f*(y2-y1)
+				track = append(track, Fingerprint{Group: s.Group, Username: user.Name, Location: s.roomAt(x, y), Timestamp: tick(), WifiFingerprint: s.scan(r, x, y)})
+			}
+		}
+	}
+	return learn, track
+}
+
+// runSimulation generates the fingerprints of a simulation into its group.
+func runSimulation(s Simulation) (BulkResult, BulkResult, error) {
+	s.Group = strings.ToLower(s.Group)
+	if err := s.validate(); err != nil {
+		return BulkResult{}, BulkResult{}, err
+	}
+	learn, track := s.generate(time.Now())
+	learned, err := insertSimulated(s.Group, "fingerprints", learn)
+	if err != nil {
+		return learned, BulkResult{}, err
+	}
+	if !s.Classify {
+		tracked, err := insertSimulated(s.Group, "fingerprints-track", track)
+		return learned, tracked, err
+	}
+
+	tracked := BulkResult{Groups: []string{s.Group}, Errors: []BulkLineError{}}
+	for i, fingerprint := range track {
+		tracked.Lines++
+		message, success, _, _, _, _ := trackFingerprint(fingerprint)
+		if success {
+			tracked.Inserted++
+		} else {
+			tracked.Errors = append(tracked.Errors, BulkLineError{Line: i + 1, Message: message})
+		}
+	}
+	return learned, tracked, nil
+}
+
+func insertSimulated(group string, database string, fingerprints []Fingerprint) (BulkResult, error) {
+	inserter := newBulkInserter(database)
+	defer inserter.recalculate()
+	for i, fingerprint := range fingerprints {
+		lineErr := validateBulkFingerprint(&fingerprint, group, database)
+		if err := inserter.add(i+1, fingerprint, lineErr); err != nil {
+			return inserter.result, err
+		}
+	}
+	return inserter.finish()
+}
+
+// loadSimulation reads a simulation from a JSON file, used by the -simulate flag.
+func loadSimulation(file string) (Simulation, error) {
+	var s Simulation
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(b, &s)
+	return s, err
+}
+
+// simulatePOST usage: curl -X POST -d @testing/simulation.json "http://localhost:8003/simulate"
+func simulatePOST(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "POST")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	var s Simulation
+	if err := c.BindJSON(&s); err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not parse simulation: " + err.Error(), "success": false})
+		return
+	}
+	learned, tracked, err := runSimulation(s)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Generated " + strconv.Itoa(learned.Inserted) + " learn and " + strconv.Itoa(tracked.Inserted) + " track fingerprints in " + strings.ToLower(s.Group), "success": true, "learn": learned, "track": tracked})
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSimulation(t *testing.T) {
+	defer useMemoryStore()()
+
+	simulation, err := loadSimulation("testing/simulation.json")
+	assert.Nil(t, err)
+	start := time.Unix(1500000000, 0)
+	learn, track := simulation.generate(start)
+	assert.Equal(t, 4*30, len(learn))
+	assert.Equal(t, 3*10, len(track))
+	assert.Equal(t, "bedroom", track[0].Location)
+	assert.Equal(t, start.Add(time.Second).UnixNano(), learn[0].Timestamp)
+	learn2, _ := simulation.generate(start)
+	assert.Equal(t, learn, learn2)
+
+	// the classifiers should tell the simulated rooms apart
+	train, test := splitEvaluationSet(learn, 0.2)
+	report, err := evaluateFingerprints(train, test)
+	assert.Nil(t, err)
+	assert.True(t, report.Results[0].Accuracy >= 75, "naive bayes accuracy is %2.1f%%", report.Results[0].Accuracy)
+
+	learned, tracked, err := runSimulation(simulation)
+	assert.Nil(t, err)
+	assert.Equal(t, 120, learned.Inserted)
+	assert.Equal(t, 30, tracked.Inserted)
+	assert.Equal(t, []string{"zack"}, getUsers("simulated"))
+
+	// without noise the same point always gives the same scan
+	noise := float64(0)
+	simulation.Noise = &noise
+	r := rand.New(rand.NewSource(1))
+	assert.Equal(t, simulation.scan(r, 3, 3), simulation.scan(r, 3, 3))
+
+	// a simulation can only track
+	trackOnly := simulation
+	trackOnly.Group = "simulatedtracks"
+	none := 0
+	trackOnly.LearnPerRoom = &none
+	learned, tracked, err = runSimulation(trackOnly)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, learned.Lines)
+	assert.Equal(t, 30, tracked.Inserted)
+
+	tooMany := simulation
+	many := simulateMaxSamples
+	tooMany.LearnPerRoom = &many
+	_, _, err = runSimulation(tooMany)
+	assert.NotNil(t, err)
+	tooMany = simulation
+	tooMany.APs = make([]SimulationAP, simulateMaxAPs+1)
+	_, _, err = runSimulation(tooMany)
+	assert.NotNil(t, err)
+
+	simulation.Users[0].Path = append(simulation.Users[0].Path, "garage")
+	_, _, err = runSimulation(simulation)
+	assert.NotNil(t, err)
+}
+
+// simulateGrid runs s with an access point in each corner of a 30 m square,
+// unless it has its own, and returns it with its defaults set so that more
+// fingerprints can be scanned.
+func simulateGrid(t *testing.T, s Simulation) Simulation {
+	if len(s.APs) == 0 {
+		s.APs = []SimulationAP{
+			{Mac: "aa", X: 0, Y: 0}, {Mac: "bb", X: 30, Y: 0}, {Mac: "cc", X: 0, Y: 30}, {Mac: "dd", X: 30, Y: 30},
+		}
+	}
+	if s.Seed == 0 {
+		s.Seed = 1
+	}
+	_, _, err := runSimulation(s)
+	assert.Nil(t, err)
+	s.setDefaults()
+	return s
+}
@@ -0,0 +1,232 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// snapshots.go keeps a history of the parameters of each group, so that a
+// previous model can be rolled back to or pinned.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	registerResources("parameterSnapshots", "parameterActive", "parameterPin", "parameters/")
+}
+
+// maxParameterSnapshots is the number of parameter snapshots kept per group,
+// not counting the active, pinned and shadowed ones
+const maxParameterSnapshots = 10
+
+// snapshotLock serializes changes to the snapshots, as parameters are saved in the background
+var snapshotLock sync.Mutex
+
+// ParameterSnapshot describes a saved version of the parameters of a group
+type ParameterSnapshot struct {
+	Version      int       `json:"version"`
+	Created      time.Time `json:"created"`
+	Fingerprints int       `json:"fingerprints"` // number of fingerprints cross-validated
+	Locations    int       `json:"locations"`
+	Accuracy     float64   `json:"accuracy"` // average cross-validation accuracy of the locations
+	Active       bool      `json:"active"`
+	Pinned       bool      `json:"pinned"`
+}
+
+func snapshotKey(version int) string {
+	return "parameters/" + strconv.Itoa(version)
+}
+
+// newParameterSnapshot summarizes the cross validation results of ps.
+func newParameterSnapshot(version int, ps FullParameters) ParameterSnapshot {
+	snapshot := ParameterSnapshot{Version: version, Created: time.Now(), Locations: len(ps.UniqueLocs)}
+	numAccuracies := 0
+	for n := range ps.Results {
+		for loc, total := range ps.Results[n].TotalLocations {
+			snapshot.Fingerprints += total
+			if total > 0 {
+				snapshot.Accuracy += float64(ps.Results[n].Accuracy[loc])
+				numAccuracies++
+			}
+		}
+	}
+	if numAccuracies > 0 {
+		snapshot.Accuracy = snapshot.Accuracy / float64(numAccuracies)
+	}
+	return snapshot
+}
+
+// openParameterSnapshots returns the snapshots of group, oldest first, with
+// the versions of the active and pinned snapshots (0 if there is none).
+func openParameterSnapshots(group string) ([]ParameterSnapshot, int, int) {
+	snapshots := []ParameterSnapshot{}
+	resources, _ := storage.ListResources(group)
+	json.Unmarshal(resources["parameterSnapshots"], &snapshots)
+	active, _ := strconv.Atoi(string(resources["parameterActive"]))
+	pinned, _ := strconv.Atoi(string(resources["parameterPin"]))
+	for i := range snapshots {
+		snapshots[i].Active = snapshots[i].Version == active
+		snapshots[i].Pinned = snapshots[i].Version == pinned
+	}
+	return snapshots, active, pinned
+}
+
+// saveParameterSnapshot saves ps as a new snapshot and makes it active, unless
+// another snapshot is pinned. The active parameters are the fullParameters resource.
+func saveParameterSnapshot(group string, ps FullParameters) error {
+	snapshotLock.Lock()
+	defer snapshotLock.Unlock()
+
+	snapshots, active, pinned := openParameterSnapshots(group)
+	version := 1
+	if len(snapshots) > 0 {
+		version = snapshots[len(snapshots)-1].Version + 1
+	}
+	snapshots = append(snapshots, newParameterSnapshot(version, ps))
+
+	dumped := dumpParameters(ps)
+	resources := map[string][]byte{snapshotKey(version): dumped}
+	if pinned == 0 {
+		active = version
+		resources["fullParameters"] = dumped
+		resources["parameterActive"] = []byte(strconv.Itoa(version))
+	}
+
+	// drop the oldest snapshots that are not in use
+	shadowed, _ := storage.GetResource(group, "parameterShadow")
+	toDelete := []string{}
+	kept := []ParameterSnapshot{}
+	numOld := len(snapshots) - maxParameterSnapshots
+	for _, snapshot := range snapshots {
+		if numOld > 0 && snapshot.Version != active && snapshot.Version != pinned && strconv.Itoa(snapshot.Version) != string(shadowed) {
+			toDelete = append(toDelete, snapshotKey(snapshot.Version))
+			numOld--
+			continue
+		}
+		kept = append(kept, snapshot)
+	}
+	resources["parameterSnapshots"], _ = json.Marshal(kept)
+
+	err := storage.PutResources(group, resources)
+	if err != nil {
+		return err
+	}
+	if pinned == 0 {
+		setPsCache(group, ps)
+	}
+	return storage.DeleteResources(group, toDelete)
+}
+
+// rollbackParameters makes a previous snapshot the active parameters. If pin
+// is set it stays active when the parameters are recalculated. The SVM and
+// random forests models are not versioned and are left as they are.
+func rollbackParameters(group string, version int, pin bool) error {
+	snapshotLock.Lock()
+	defer snapshotLock.Unlock()
+
+	v, err := storage.GetResource(group, snapshotKey(version))
+	if err != nil || v == nil {
+		return fmt.Errorf("version %d of the parameters of %s does not exist", version, group)
+	}
+	resources := map[string][]byte{
+		"fullParameters":  v,
+		"parameterActive": []byte(strconv.Itoa(version)),
+	}
+	if pin {
+		resources["parameterPin"] = []byte(strconv.Itoa(version))
+	}
+	err = storage.PutResources(group, resources)
+	if err != nil {
+		return err
+	}
+	setPsCache(group, loadParameters(v))
+	go resetCache("userPositionCache")
+	return nil
+}
+
+// unpinParameters lets recalculations replace the active parameters again,
+// and makes the newest snapshot active.
+func unpinParameters(group string) error {
+	snapshots, _, _ := openParameterSnapshots(group)
+	err := storage.DeleteResources(group, []string{"parameterPin"})
+	if err != nil || len(snapshots) == 0 {
+		return err
+	}
+	return rollbackParameters(group, snapshots[len(snapshots)-1].Version, false)
+}
+
+// getParameterSnapshots usage: curl "http://localhost:8003/parameters/snapshots?group=X"
+func getParameterSnapshots(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "Group does not exist", "success": false})
+		return
+	}
+	snapshots, active, pinned := openParameterSnapshots(group)
+	c.JSON(http.StatusOK, gin.H{"message": "Found " + strconv.Itoa(len(snapshots)) + " snapshots", "success": true, "snapshots": snapshots, "active": active, "pinned": pinned})
+}
+
+// putParameterRollback usage: curl -X PUT "http://localhost:8003/parameters/rollback?group=X&version=3&pin=true"
+func putParameterRollback(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	version, err := strconv.Atoi(c.DefaultQuery("version", "none"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Need to provide version", "success": false})
+		return
+	}
+	pin := c.DefaultQuery("pin", "false") == "true"
+	err = rollbackParameters(group, version, pin)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	message := "Rolled back " + group + " to version " + strconv.Itoa(version)
+	if pin {
+		message += " and pinned it"
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "success": true})
+}
+
+// deleteParameterPin usage: curl -X DELETE "http://localhost:8003/parameters/pin?group=X"
+func deleteParameterPin(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "Group does not exist", "success": false})
+		return
+	}
+	err := unpinParameters(group)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Unpinned the parameters of " + group, "success": true})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParameterSnapshots(t *testing.T) {
+	defer useMemoryStore()()
+
+	for i := 1; i <= maxParameterSnapshots+2; i++ {
+		ps := *NewFullParameters()
+		ps.UniqueLocs = make([]string, i)
+		ps.Results["0"] = ResultsParameters{TotalLocations: map[string]int{"kitchen": 10}, Accuracy: map[string]int{"kitchen": 10 * i}}
+		assert.Nil(t, saveParameters("snapshottest", ps))
+	}
+	snapshots, active, pinned := openParameterSnapshots("snapshottest")
+	assert.Equal(t, maxParameterSnapshots, len(snapshots))
+	assert.Equal(t, 3, snapshots[0].Version)
+	assert.Equal(t, maxParameterSnapshots+2, active)
+	assert.Equal(t, 0, pinned)
+	assert.Equal(t, 10, snapshots[0].Fingerprints)
+	assert.Equal(t, 30.0, snapshots[0].Accuracy)
+	v, _ := storage.GetResource("snapshottest", snapshotKey(1))
+	assert.Nil(t, v)
+
+	assert.NotNil(t, rollbackParameters("snapshottest", 1, false))
+	assert.Nil(t, rollbackParameters("snapshottest", 5, true))
+	ps, _ := openParameters("snapshottest")
+	assert.Equal(t, 5, len(ps.UniqueLocs))
+
+	// a pinned snapshot stays active and is not dropped
+	for i := 0; i < maxParameterSnapshots; i++ {
+		saveParameters("snapshottest", *NewFullParameters())
+	}
+	snapshots, active, pinned = openParameterSnapshots("snapshottest")
+	assert.Equal(t, 5, active)
+	assert.Equal(t, 5, pinned)
+	assert.Equal(t, 5, snapshots[0].Version)
+	assert.True(t, snapshots[0].Pinned)
+	v, _ = storage.GetResource("snapshottest", "fullParameters")
+	assert.Equal(t, 5, len(loadParameters(v).UniqueLocs))
+
+	assert.Nil(t, unpinParameters("snapshottest"))
+	_, active, pinned = openParameterSnapshots("snapshottest")
+	assert.Equal(t, snapshots[len(snapshots)-1].Version, active)
+	assert.Equal(t, 0, pinned)
+}
@@ -0,0 +1,164 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// store.go contains the interface that all persistence goes through.
+// The implementations are in storeBolt.go and storeMemory.go.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Store is a storage backend for the fingerprints, parameters and metadata of
+// each group, as well as the credentials that are shared by the server.
+//
+// Fingerprints are kept in named buckets of a group, "fingerprints" for learning
+// and "fingerprints-track" for tracking, and are keyed by their timestamp in
+// nanoseconds. Resources are the other per-group values such as parameters.
+// Callbacks passed to a Store must not call back into it.
+type Store interface {
+	// GroupExists returns whether anything has been stored for group.
+	GroupExists(group string) bool
+	// ListGroups returns the names of all groups.
+	ListGroups() ([]string, error)
+	// DeleteGroup removes group and everything stored in it.
+	DeleteGroup(group string) error
+	// RenameGroup moves everything stored in from to the new group to, which must not exist.
+	RenameGroup(from string, to string) error
+	// ListTrash returns the names of the groups in the trash, see trash.go.
+	// They are not included in ListGroups.
+	ListTrash() ([]string, error)
+
+	// PutFingerprints inserts new fingerprints into a bucket and returns how
+	// many were inserted, skipping ones that were already inserted.
+	PutFingerprints(group string, bucket string, fingerprints []Fingerprint) (int, error)
+	// ForEachFingerprint calls fn for each fingerprint in a bucket in order of
+	// key (or reverse order) until fn returns false.
+	ForEachFingerprint(group string, bucket string, reverse bool, fn func(key string, fingerprint Fingerprint) bool) error
+	// UpdateFingerprints stores fingerprints in a bucket under the given keys.
+	UpdateFingerprints(group string, bucket string, fingerprints map[string]Fingerprint) error
+	// DeleteFingerprints removes the given keys from a bucket.
+	DeleteFingerprints(group string, bucket string, keys []string) error
+	// ForEachRecord calls fn with each key and stored value of a bucket, as
+	// they are encoded, until fn returns false.
+	ForEachRecord(group string, bucket string, fn func(key string, value []byte) bool) error
+	// PutRecords stores already encoded values in a bucket.
+	PutRecords(group string, bucket string, records map[string][]byte) error
+
+	// GetResource returns a resource of group, or nil if it is not set.
+	GetResource(group string, key string) ([]byte, error)
+	// ListResources returns all of the resources of group.
+	ListResources(group string) (map[string][]byte, error)
+	// PutResources sets resources of group together.
+	PutResources(group string, resources map[string][]byte) error
+	// DeleteResources removes resources of group.
+	DeleteResources(group string, keys []string) error
+
+	// GetCredential returns the MQTT password of group.
+	GetCredential(group string) (string, error)
+	// ListCredentials returns the MQTT passwords of every group.
+	ListCredentials() (map[string]string, error)
+	// PutCredential sets the MQTT password of group.
+	PutCredential(group string, password string) error
+}
+
+// storage is the Store used by the server, set by the -store flag
+var storage Store = boltStore{}
+
+// newStore returns the Store with the given name.
+func newStore(name string) (Store, error) {
+	switch name {
+	case "", "bolt":
+		return boltStore{}, nil
+	case "memory":
+		return newMemoryStore(), nil
+	}
+	return nil, fmt.Errorf("unknown store '%s', use bolt or memory", name)
+}
+
+// forEachStoredFingerprint calls fn for each fingerprint in a bucket in order
+// of key until fn returns false, as stored: unlike ForEachFingerprint it does
+// not apply the -filter whitelist, so fingerprints that are written back, to
+// this group or another, keep all of their MACs. Records that can't be decoded
+// are skipped.
+func forEachStoredFingerprint(group string, bucket string, fn func(key string, fingerprint Fingerprint) bool) error {
+	dictionary, _ := storage.GetResource(group, "macDictionary")
+	codec := newFingerprintCodec(dictionary)
+	return storage.ForEachRecord(group, bucket, func(k string, v []byte) bool {
+		fingerprint, err := codec.decode(v)
+		if err != nil {
+			Warning.Println("Could not decode " + group + "/" + bucket + "/" + k + ": " + err.Error())
+			return true
+		}
+		return fn(k, fingerprint)
+	})
+}
+
+// keyValueBucket is the part of a bucket needed to insert fingerprints, so the
+// rules for keys are shared between Store implementations.
+type keyValueBucket interface {
+	Get(key []byte) []byte
+	Put(key []byte, value []byte) error
+}
+
+// insertFingerprints puts fingerprints into bucket, using ids as the index of
+// client-supplied fingerprint IDs, and returns the number inserted. The caller
+// saves the dictionary of codec if it changed.
+func insertFingerprints(bucket keyValueBucket, ids keyValueBucket, codec *fingerprintCodec, fingerprints []Fingerprint) (int, error) {
+	inserted := 0
+	for _, res := range fingerprints {
+		if len(res.ID) > 0 {
+			if k := ids.Get([]byte(res.ID)); k != nil && bucket.Get(k) != nil {
+				continue
+			}
+		}
+		if res.Timestamp == 0 {
+			res.Timestamp = time.Now().UnixNano()
+		}
+		dumped := codec.dump(res)
+		key, isNew := fingerprintKey(bucket, res.Timestamp, dumped)
+		if !isNew {
+			continue
+		}
+		err := bucket.Put(key, dumped)
+		if err != nil {
+			return inserted, fmt.Errorf("could add to bucket: %s", err)
+		}
+		if len(res.ID) > 0 {
+			err = ids.Put([]byte(res.ID), key)
+			if err != nil {
+				return inserted, fmt.Errorf("could add to bucket: %s", err)
+			}
+		}
+		inserted++
+	}
+	return inserted, nil
+}
+
+// fingerprintKey returns the key to store a fingerprint under. Keys are the
+// timestamp in nanoseconds, moved forward a nanosecond at a time until a free
+// one is found so that they stay unique and time-ordered. If an identical
+// fingerprint is already stored at one of those keys, its key is returned with false.
+func fingerprintKey(bucket keyValueBucket, timestamp int64, dumped []byte) ([]byte, bool) {
+	for ts := timestamp; ; ts++ {
+		key := []byte(strconv.FormatInt(ts, 10))
+		v := bucket.Get(key)
+		if v == nil {
+			return key, true
+		}
+		if bytes.Equal(v, dumped) {
+			return key, false
+		}
+	}
+}
+
+// keyTimestamp returns the timestamp in nanoseconds that a fingerprint key represents.
+func keyTimestamp(key string) int64 {
+	timestampUnixNano, _ := strconv.ParseInt(key, 10, 64)
+	return timestampUnixNano
+}
@@ -0,0 +1,362 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// storeBolt.go implements Store with a bolt database for each group.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltStore keeps each group in RuntimeArgs.SourcePath/group.db and the
+// credentials in RuntimeArgs.Cwd/global.db
+type boltStore struct{}
+
+func (s boltStore) groupPath(group string) string {
+	return path.Join(RuntimeArgs.SourcePath, group+".db")
+}
+
+// view runs fn in a read-only transaction, without creating the database of group.
+func (s boltStore) view(group string, fn func(tx *bolt.Tx) error) error {
+	if !exists(s.groupPath(group)) {
+		return fmt.Errorf("group %s does not exist", group)
+	}
+	db, err := bolt.Open(s.groupPath(group), 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+func (s boltStore) update(group string, fn func(tx *bolt.Tx) error) error {
+	if err := os.MkdirAll(path.Dir(s.groupPath(group)), 0755); err != nil {
+		return err
+	}
+	db, err := bolt.Open(s.groupPath(group), 0600, nil)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(fn)
+}
+
+func (s boltStore) GroupExists(group string) bool {
+	if _, err := os.Stat(s.groupPath(group)); os.IsNotExist(err) {
+		return false
+	}
+	return true
+}
+
+func (s boltStore) ListGroups() ([]string, error) {
+	files, err := filepath.Glob(path.Join(RuntimeArgs.SourcePath, "*.db"))
+	if err != nil {
+		return nil, err
+	}
+	groups := []string{}
+	for _, file := range files {
+		groups = append(groups, strings.TrimSuffix(path.Base(file), ".db"))
+	}
+	return groups, nil
+}
+
+func (s boltStore) DeleteGroup(group string) error {
+	return os.Remove(s.groupPath(group))
+}
+
+func (s boltStore) RenameGroup(from string, to string) error {
+	if s.GroupExists(to) {
+		return fmt.Errorf("group %s already exists", to)
+	}
+	if err := os.MkdirAll(path.Dir(s.groupPath(to)), 0755); err != nil {
+		return err
+	}
+	return os.Rename(s.groupPath(from), s.groupPath(to))
+}
+
+// ListTrash lists the databases in RuntimeArgs.SourcePath/trash
+func (s boltStore) ListTrash() ([]string, error) {
+	files, err := filepath.Glob(path.Join(RuntimeArgs.SourcePath, trashPrefix+"*.db"))
+	if err != nil {
+		return nil, err
+	}
+	groups := []string{}
+	for _, file := range files {
+		groups = append(groups, trashPrefix+strings.TrimSuffix(path.Base(file), ".db"))
+	}
+	return groups, nil
+}
+
+func (s boltStore) PutFingerprints(group string, bucket string, fingerprints []Fingerprint) (int, error) {
+	inserted := 0
+	err := s.update(group, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		ids, err := tx.CreateBucketIfNotExists([]byte(bucket + "-ids"))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		codec := s.codec(tx)
+		inserted, err = insertFingerprints(b, ids, codec, fingerprints)
+		if err != nil {
+			return err
+		}
+		return s.saveCodec(tx, codec)
+	})
+	if err != nil {
+		inserted = 0
+	}
+	return inserted, err
+}
+
+func (s boltStore) ForEachFingerprint(group string, bucket string, reverse bool, fn func(key string, fingerprint Fingerprint) bool) error {
+	return s.view(group, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("no %s bucket in %s", bucket, group)
+		}
+		codec := s.codec(tx)
+		c := b.Cursor()
+		if reverse {
+			for k, v := c.Last(); k != nil; k, v = c.Prev() {
+				if !fn(string(k), codec.load(v)) {
+					break
+				}
+			}
+		} else {
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if !fn(string(k), codec.load(v)) {
+					break
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (s boltStore) UpdateFingerprints(group string, bucket string, fingerprints map[string]Fingerprint) error {
+	return s.update(group, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		codec := s.codec(tx)
+		for k, v := range fingerprints {
+			err = b.Put([]byte(k), codec.dump(v))
+			if err != nil {
+				return fmt.Errorf("could add to bucket: %s", err)
+			}
+		}
+		return s.saveCodec(tx, codec)
+	})
+}
+
+// codec returns the fingerprintCodec with the MAC dictionary of the group.
+func (s boltStore) codec(tx *bolt.Tx) *fingerprintCodec {
+	if b := tx.Bucket([]byte("resources")); b != nil {
+		return newFingerprintCodec(b.Get([]byte("macDictionary")))
+	}
+	return newFingerprintCodec(nil)
+}
+
+// saveCodec saves the MAC dictionary if fingerprints added to it.
+func (s boltStore) saveCodec(tx *bolt.Tx, codec *fingerprintCodec) error {
+	if !codec.dictionary.changed {
+		return nil
+	}
+	b, err := tx.CreateBucketIfNotExists([]byte("resources"))
+	if err != nil {
+		return fmt.Errorf("create bucket: %s", err)
+	}
+	return b.Put([]byte("macDictionary"), codec.dictionary.bytes())
+}
+
+func (s boltStore) DeleteFingerprints(group string, bucket string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.update(group, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return nil
+		}
+		for _, k := range keys {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s boltStore) ForEachRecord(group string, bucket string, fn func(key string, value []byte) bool) error {
+	return s.view(group, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bucket))
+		if b == nil {
+			return fmt.Errorf("no %s bucket in %s", bucket, group)
+		}
+		c := b.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if !fn(string(k), v) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (s boltStore) PutRecords(group string, bucket string, records map[string][]byte) error {
+	return s.update(group, func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		for k, v := range records {
+			err = b.Put([]byte(k), v)
+			if err != nil {
+				return fmt.Errorf("could add to bucket: %s", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s boltStore) GetResource(group string, key string) ([]byte, error) {
+	var value []byte
+	err := s.view(group, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("resources"))
+		if b == nil {
+			return fmt.Errorf("Resources dont exist")
+		}
+		if v := b.Get([]byte(key)); v != nil {
+			value = append([]byte{}, v...)
+		}
+		return nil
+	})
+	return value, err
+}
+
+func (s boltStore) ListResources(group string) (map[string][]byte, error) {
+	resources := make(map[string][]byte)
+	err := s.view(group, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("resources"))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			if v != nil {
+				resources[string(k)] = append([]byte{}, v...)
+			}
+			return nil
+		})
+	})
+	return resources, err
+}
+
+func (s boltStore) PutResources(group string, resources map[string][]byte) error {
+	return s.update(group, func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("resources"))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		for k, v := range resources {
+			err = bucket.Put([]byte(k), v)
+			if err != nil {
+				return fmt.Errorf("could add to bucket: %s", err)
+			}
+		}
+		return nil
+	})
+}
+
+func (s boltStore) DeleteResources(group string, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	return s.update(group, func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("resources"))
+		if b == nil {
+			return nil
+		}
+		for _, k := range keys {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s boltStore) openGlobal() (*bolt.DB, error) {
+	return bolt.Open(path.Join(RuntimeArgs.Cwd, "global.db"), 0600, nil)
+}
+
+func (s boltStore) GetCredential(group string) (string, error) {
+	password := ""
+	db, err := s.openGlobal()
+	if err != nil {
+		return password, err
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("mqtt"))
+		if b == nil {
+			return nil
+		}
+		password = string(b.Get([]byte(group)))
+		return nil
+	})
+	return password, err
+}
+
+func (s boltStore) ListCredentials() (map[string]string, error) {
+	credentials := make(map[string]string)
+	db, err := s.openGlobal()
+	if err != nil {
+		return credentials, err
+	}
+	defer db.Close()
+
+	err = db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte("mqtt"))
+		if b == nil {
+			return nil
+		}
+		return b.ForEach(func(k, v []byte) error {
+			credentials[string(k)] = string(v)
+			return nil
+		})
+	})
+	return credentials, err
+}
+
+func (s boltStore) PutCredential(group string, password string) error {
+	db, err := s.openGlobal()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte("mqtt"))
+		if err != nil {
+			return fmt.Errorf("create bucket: %s", err)
+		}
+		err = bucket.Put([]byte(group), []byte(password))
+		if err != nil {
+			return fmt.Errorf("could add to bucket: %s", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,302 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// storeMemory.go implements Store in memory, for tests and throwaway servers.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// memoryStore keeps everything in maps that are lost when the server stops
+type memoryStore struct {
+	sync.RWMutex
+	groups      map[string]*memoryGroup
+	credentials map[string]string
+}
+
+type memoryGroup struct {
+	buckets   map[string]memoryBucket
+	resources map[string][]byte
+}
+
+// memoryBucket holds fingerprints as they would be dumped into bolt
+type memoryBucket map[string][]byte
+
+func (b memoryBucket) Get(key []byte) []byte {
+	return b[string(key)]
+}
+
+func (b memoryBucket) Put(key []byte, value []byte) error {
+	b[string(key)] = value
+	return nil
+}
+
+// newMemoryStore returns an empty memoryStore
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		groups:      make(map[string]*memoryGroup),
+		credentials: make(map[string]string),
+	}
+}
+
+// group returns the group, creating it if needed. The lock must be held.
+func (s *memoryStore) group(group string) *memoryGroup {
+	if _, ok := s.groups[group]; !ok {
+		s.groups[group] = &memoryGroup{
+			buckets:   make(map[string]memoryBucket),
+			resources: make(map[string][]byte),
+		}
+	}
+	return s.groups[group]
+}
+
+// bucket returns the bucket of a group, creating it if needed. The lock must be held.
+func (s *memoryStore) bucket(group string, bucket string) memoryBucket {
+	g := s.group(group)
+	if _, ok := g.buckets[bucket]; !ok {
+		g.buckets[bucket] = make(memoryBucket)
+	}
+	return g.buckets[bucket]
+}
+
+func (s *memoryStore) GroupExists(group string) bool {
+	s.RLock()
+	defer s.RUnlock()
+	_, ok := s.groups[group]
+	return ok
+}
+
+func (s *memoryStore) ListGroups() ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	groups := []string{}
+	for group := range s.groups {
+		if !strings.HasPrefix(group, trashPrefix) {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+func (s *memoryStore) ListTrash() ([]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	groups := []string{}
+	for group := range s.groups {
+		if strings.HasPrefix(group, trashPrefix) {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+	return groups, nil
+}
+
+func (s *memoryStore) RenameGroup(from string, to string) error {
+	s.Lock()
+	defer s.Unlock()
+	g, ok := s.groups[from]
+	if !ok {
+		return fmt.Errorf("group %s does not exist", from)
+	}
+	if _, ok := s.groups[to]; ok {
+		return fmt.Errorf("group %s already exists", to)
+	}
+	s.groups[to] = g
+	delete(s.groups, from)
+	return nil
+}
+
+func (s *memoryStore) DeleteGroup(group string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.groups[group]; !ok {
+		return fmt.Errorf("group %s does not exist", group)
+	}
+	delete(s.groups, group)
+	return nil
+}
+
+func (s *memoryStore) PutFingerprints(group string, bucket string, fingerprints []Fingerprint) (int, error) {
+	s.Lock()
+	defer s.Unlock()
+	codec := s.codec(group)
+	inserted, err := insertFingerprints(s.bucket(group, bucket), s.bucket(group, bucket+"-ids"), codec, fingerprints)
+	s.saveCodec(group, codec)
+	return inserted, err
+}
+
+func (s *memoryStore) ForEachFingerprint(group string, bucket string, reverse bool, fn func(key string, fingerprint Fingerprint) bool) error {
+	s.RLock()
+	defer s.RUnlock()
+	g, ok := s.groups[group]
+	if !ok {
+		return fmt.Errorf("group %s does not exist", group)
+	}
+	b, ok := g.buckets[bucket]
+	if !ok {
+		return fmt.Errorf("no %s bucket in %s", bucket, group)
+	}
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	if reverse {
+		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
+	} else {
+		sort.Strings(keys)
+	}
+	codec := newFingerprintCodec(g.resources["macDictionary"])
+	for _, k := range keys {
+		if !fn(k, codec.load(b[k])) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) UpdateFingerprints(group string, bucket string, fingerprints map[string]Fingerprint) error {
+	s.Lock()
+	defer s.Unlock()
+	b := s.bucket(group, bucket)
+	codec := s.codec(group)
+	for k, v := range fingerprints {
+		b[k] = codec.dump(v)
+	}
+	s.saveCodec(group, codec)
+	return nil
+}
+
+// codec returns the fingerprintCodec with the MAC dictionary of the group. The lock must be held.
+func (s *memoryStore) codec(group string) *fingerprintCodec {
+	return newFingerprintCodec(s.group(group).resources["macDictionary"])
+}
+
+// saveCodec saves the MAC dictionary if fingerprints added to it. The lock must be held.
+func (s *memoryStore) saveCodec(group string, codec *fingerprintCodec) {
+	if codec.dictionary.changed {
+		s.group(group).resources["macDictionary"] = codec.dictionary.bytes()
+	}
+}
+
+func (s *memoryStore) DeleteFingerprints(group string, bucket string, keys []string) error {
+	s.Lock()
+	defer s.Unlock()
+	if g, ok := s.groups[group]; ok {
+		for _, k := range keys {
+			delete(g.buckets[bucket], k)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) ForEachRecord(group string, bucket string, fn func(key string, value []byte) bool) error {
+	s.RLock()
+	defer s.RUnlock()
+	g, ok := s.groups[group]
+	if !ok {
+		return fmt.Errorf("group %s does not exist", group)
+	}
+	b, ok := g.buckets[bucket]
+	if !ok {
+		return fmt.Errorf("no %s bucket in %s", bucket, group)
+	}
+	keys := make([]string, 0, len(b))
+	for k := range b {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if !fn(k, b[k]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) PutRecords(group string, bucket string, records map[string][]byte) error {
+	s.Lock()
+	defer s.Unlock()
+	b := s.bucket(group, bucket)
+	for k, v := range records {
+		b[k] = append([]byte{}, v...)
+	}
+	return nil
+}
+
+func (s *memoryStore) GetResource(group string, key string) ([]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	g, ok := s.groups[group]
+	if !ok {
+		return nil, fmt.Errorf("group %s does not exist", group)
+	}
+	if v, ok := g.resources[key]; ok {
+		return append([]byte{}, v...), nil
+	}
+	return nil, nil
+}
+
+func (s *memoryStore) ListResources(group string) (map[string][]byte, error) {
+	s.RLock()
+	defer s.RUnlock()
+	resources := make(map[string][]byte)
+	g, ok := s.groups[group]
+	if !ok {
+		return resources, fmt.Errorf("group %s does not exist", group)
+	}
+	for k, v := range g.resources {
+		resources[k] = append([]byte{}, v...)
+	}
+	return resources, nil
+}
+
+func (s *memoryStore) PutResources(group string, resources map[string][]byte) error {
+	s.Lock()
+	defer s.Unlock()
+	g := s.group(group)
+	for k, v := range resources {
+		g.resources[k] = append([]byte{}, v...)
+	}
+	return nil
+}
+
+func (s *memoryStore) DeleteResources(group string, keys []string) error {
+	s.Lock()
+	defer s.Unlock()
+	if g, ok := s.groups[group]; ok {
+		for _, k := range keys {
+			delete(g.resources, k)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) GetCredential(group string) (string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.credentials[group], nil
+}
+
+func (s *memoryStore) ListCredentials() (map[string]string, error) {
+	s.RLock()
+	defer s.RUnlock()
+	credentials := make(map[string]string)
+	for k, v := range s.credentials {
+		credentials[k] = v
+	}
+	return credentials, nil
+}
+
+func (s *memoryStore) PutCredential(group string, password string) error {
+	s.Lock()
+	defer s.Unlock()
+	s.credentials[group] = password
+	return nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s, err := newStore("memory")
+	assert.Nil(t, err)
+	assert.False(t, s.GroupExists("memtest"))
+
+	fingerprints := []Fingerprint{
+		{Group: "memtest", Location: "kitchen", Timestamp: 100, WifiFingerprint: []Router{{Mac: "aa", Rssi: -40}}},
+		{Group: "memtest", Location: "bedroom", Timestamp: 100, WifiFingerprint: []Router{{Mac: "aa", Rssi: -70}}},
+		{Group: "memtest", Location: "kitchen", Timestamp: 100, WifiFingerprint: []Router{{Mac: "aa", Rssi: -40}}},
+	}
+	inserted, err := s.PutFingerprints("memtest", "fingerprints", fingerprints)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, inserted)
+	assert.True(t, s.GroupExists("memtest"))
+
+	keys := []string{}
+	s.ForEachFingerprint("memtest", "fingerprints", true, func(k string, v Fingerprint) bool {
+		keys = append(keys, k)
+		return true
+	})
+	assert.Equal(t, []string{"101", "100"}, keys)
+
+	s.UpdateFingerprints("memtest", "fingerprints", map[string]Fingerprint{"101": {Location: "office"}})
+	s.DeleteFingerprints("memtest", "fingerprints", []string{"100"})
+	locations := []string{}
+	s.ForEachFingerprint("memtest", "fingerprints", false, func(k string, v Fingerprint) bool {
+		locations = append(locations, v.Location)
+		return true
+	})
+	assert.Equal(t, []string{"office"}, locations)
+	assert.NotNil(t, s.ForEachFingerprint("memtest", "fingerprints-track", false, func(k string, v Fingerprint) bool { return true }))
+
+	s.PutResources("memtest", map[string][]byte{"svmData": []byte("data")})
+	v, err := s.GetResource("memtest", "svmData")
+	assert.Nil(t, err)
+	assert.Equal(t, "data", string(v))
+	v, _ = s.GetResource("memtest", "macs")
+	assert.Nil(t, v)
+
+	s.PutCredential("memtest", "secret")
+	password, _ := s.GetCredential("memtest")
+	assert.Equal(t, "secret", password)
+
+	assert.Nil(t, s.DeleteGroup("memtest"))
+	assert.False(t, s.GroupExists("memtest"))
+
+	_, err = newStore("sqlite")
+	assert.NotNil(t, err)
+}
+
+// useMemoryStore replaces the storage with an empty memory store, until the
+// returned function puts it back and clears the caches filled from it.
+func useMemoryStore() func() {
+	defaultStorage := storage
+	storage = newMemoryStore()
+	return func() {
+		storage = defaultStorage
+		resetCache("psCache")
+		resetCache("userPriorsCache")
+		resetCache("locationGraphCache")
+	}
+}
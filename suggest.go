@@ -0,0 +1,168 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// suggest.go recommends where to learn more fingerprints, from the cross
+// validation results, the number of fingerprints and the access points seen at each location.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// suggestMinFingerprints is the number of fingerprints a location should have at least
+	suggestMinFingerprints = 50
+	// suggestMinAccuracy is the cross validation accuracy below which a location needs more fingerprints
+	suggestMinAccuracy = 80
+	// suggestMinAPs is the number of access points that should be seen reliably at a location
+	suggestMinAPs = 3
+	// suggestMinConfusion is the fraction of guesses that makes a pair of locations confused
+	suggestMinConfusion = 0.1
+)
+
+// LocationSuggestion recommends learning more at a location
+type LocationSuggestion struct {
+	Location     string   `json:"location"`
+	Fingerprints int      `json:"fingerprints"`
+	Accuracy     int      `json:"accuracy"`
+	ReliableAPs  int      `json:"reliable_aps"` // access points seen in at least half of the fingerprints
+	ConfusedWith []string `json:"confused_with"`
+	More         int      `json:"more"` // suggested number of fingerprints to add
+	Reasons      []string `json:"reasons"`
+	priority     float64
+}
+
+// ConfusedLocations are two locations that are often guessed as each other
+type ConfusedLocations struct {
+	Locations []string `json:"locations"`
+	Guessed   int      `json:"guessed"` // times either was guessed as the other
+	Tested    int      `json:"tested"`
+	Rate      float64  `json:"rate"`
+}
+
+// suggestLearning returns the locations of group that need more fingerprints,
+// most urgent first, and the pairs of locations that are confused.
+func suggestLearning(group string) ([]LocationSuggestion, []ConfusedLocations) {
+	ps, _ := openParameters(group)
+	counts := make(map[string]int)
+	storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v Fingerprint) bool {
+		counts[v.Location]++
+		return true
+	})
+
+	pairs := []ConfusedLocations{}
+	confusedWith := make(map[string][]string)
+	for n := range ps.Results {
+		results := ps.Results[n]
+		locs := []string{}
+		for loc := range ps.NetworkLocs[n] {
+			locs = append(locs, loc)
+		}
+		sort.Strings(locs)
+		for i, a := range locs {
+			for _, b := range locs[i+1:] {
+				pair := ConfusedLocations{
+					Locations: []string{a, b},
+					Guessed:   results.Guess[a][b] + results.Guess[b][a],
+					Tested:    results.TotalLocations[a] + results.TotalLocations[b],
+				}
+				if pair.Tested == 0 {
+					continue
+				}
+				pair.Rate = float64(pair.Guessed) / float64(pair.Tested)
+				if pair.Rate >= suggestMinConfusion {
+					pairs = append(pairs, pair)
+					confusedWith[a] = append(confusedWith[a], b)
+					confusedWith[b] = append(confusedWith[b], a)
+				}
+			}
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Rate > pairs[j].Rate })
+
+	suggestions := []LocationSuggestion{}
+	for n := range ps.NetworkLocs {
+		for loc := range ps.NetworkLocs[n] {
+			s := LocationSuggestion{
+				Location:     loc,
+				Fingerprints: counts[loc],
+				Accuracy:     ps.Results[n].Accuracy[loc],
+				ConfusedWith: confusedWith[loc],
+				Reasons:      []string{},
+			}
+			if s.ConfusedWith == nil {
+				s.ConfusedWith = []string{}
+			}
+			for _, count := range ps.MacCountByLoc[loc] {
+				if 2*count >= s.Fingerprints {
+					s.ReliableAPs++
+				}
+			}
+
+			if s.Fingerprints < suggestMinFingerprints {
+				s.More = suggestMinFingerprints - s.Fingerprints
+				s.Reasons = append(s.Reasons, fmt.Sprintf("only %d fingerprints", s.Fingerprints))
+				s.priority += float64(s.More) / suggestMinFingerprints * 50
+			}
+			if ps.Results[n].TotalLocations[loc] > 0 && s.Accuracy < suggestMinAccuracy {
+				s.Reasons = append(s.Reasons, fmt.Sprintf("accuracy is %d%%", s.Accuracy))
+				s.priority += float64(suggestMinAccuracy - s.Accuracy)
+				// learning half again as much usually helps more than learning the minimum
+				if more := s.Fingerprints / 2; more > s.More {
+					s.More = more
+				}
+			}
+			if len(s.ConfusedWith) > 0 {
+				sort.Strings(s.ConfusedWith)
+				s.Reasons = append(s.Reasons, "confused with "+strings.Join(s.ConfusedWith, ", "))
+			}
+			if s.ReliableAPs < suggestMinAPs {
+				s.Reasons = append(s.Reasons, fmt.Sprintf("only %d access points seen reliably, learn all around the location", s.ReliableAPs))
+				s.priority += 10
+			}
+			if s.priority > 0 || len(s.ConfusedWith) > 0 {
+				suggestions = append(suggestions, s)
+			}
+		}
+	}
+	sort.Slice(suggestions, func(i, j int) bool {
+		if suggestions[i].priority == suggestions[j].priority {
+			return suggestions[i].Location < suggestions[j].Location
+		}
+		return suggestions[i].priority > suggestions[j].priority
+	})
+	return suggestions, pairs
+}
+
+// getSuggestions usage: curl "http://localhost:8003/suggestions?group=X"
+func getSuggestions(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	suggestions, confused := suggestLearning(group)
+	message := "All locations have enough fingerprints"
+	if len(suggestions) > 0 {
+		message = "Learn " + strconv.Itoa(suggestions[0].More) + " more fingerprints at " + suggestions[0].Location + " first"
+		if suggestions[0].More == 0 {
+			message = "Check " + suggestions[0].Location + " first: " + strings.Join(suggestions[0].Reasons, ", ")
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"message": message, "success": true, "suggestions": suggestions, "confused": confused})
+}
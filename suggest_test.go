@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuggestLearning(t *testing.T) {
+	defer useMemoryStore()()
+
+	learnPerRoom := 60
+	// the kitchen and pantry are next to each other, the bedroom is far away
+	simulateGrid(t, Simulation{
+		Group: "suggesttest",
+		Rooms: []SimulationRoom{
+			{Name: "kitchen", X1: 14, Y1: 14, X2: 15, Y2: 15},
+			{Name: "pantry", X1: 15, Y1: 14, X2: 16, Y2: 15},
+			{Name: "bedroom", X1: 0, Y1: 0, X2: 3, Y2: 3},
+		},
+		LearnPerRoom: &learnPerRoom,
+	})
+
+	suggestions, confused := suggestLearning("suggesttest")
+	assert.Equal(t, 1, len(confused))
+	assert.Equal(t, []string{"kitchen", "pantry"}, confused[0].Locations)
+	assert.Equal(t, 2, len(suggestions))
+	for _, s := range suggestions {
+		assert.NotEqual(t, "bedroom", s.Location)
+		assert.Equal(t, 60, s.Fingerprints)
+		assert.Equal(t, 4, s.ReliableAPs)
+		assert.Equal(t, 30, s.More)
+		assert.Equal(t, 1, len(s.ConfusedWith))
+	}
+}
+
+func TestSuggestConfusedButAccurate(t *testing.T) {
+	defer useMemoryStore()()
+
+	// enough fingerprints and access points and accurate, but guessed as each other 15% of the time
+	ps := *NewFullParameters()
+	ps.NetworkLocs["0"] = map[string]bool{"kitchen": true, "pantry": true}
+	fingerprints := []Fingerprint{}
+	for _, loc := range []string{"kitchen", "pantry"} {
+		ps.MacCountByLoc[loc] = map[string]int{"aa": 60, "bb": 60, "cc": 60}
+		for i := 0; i < 60; i++ {
+			fingerprints = append(fingerprints, Fingerprint{Group: "suggesttest", Location: loc, Timestamp: int64(len(fingerprints) + 1), WifiFingerprint: []Router{{Mac: "aa", Rssi: -50}}})
+		}
+	}
+	_, err := storage.PutFingerprints("suggesttest", "fingerprints", fingerprints)
+	assert.Nil(t, err)
+	ps.Results["0"] = ResultsParameters{
+		Accuracy:       map[string]int{"kitchen": 85, "pantry": 85},
+		TotalLocations: map[string]int{"kitchen": 100, "pantry": 100},
+		Guess:          map[string]map[string]int{"kitchen": {"pantry": 15}, "pantry": {"kitchen": 15}},
+	}
+	assert.Nil(t, saveParameters("suggesttest", ps))
+
+	suggestions, confused := suggestLearning("suggesttest")
+	assert.Equal(t, 1, len(confused))
+	assert.Equal(t, 2, len(suggestions))
+	for _, s := range suggestions {
+		assert.Equal(t, []string{"confused with " + map[string]string{"kitchen": "pantry", "pantry": "kitchen"}[s.Location]}, s.Reasons)
+	}
+}
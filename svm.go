@@ -13,10 +13,12 @@ import (
 	"strconv"
 	"strings"
 	"time"
-
-	"github.com/boltdb/bolt"
 )
 
+func init() {
+	registerResources("svmData", "macsFromID", "locationsFromID", "macs", "locations")
+}
+
 // # sudo apt-get install g++
 // # wget http://www.csie.ntu.edu.tw/~cjlin/cgi-bin/libsvm.cgi?+http://www.csie.ntu.edu.tw/~cjlin/libsvm+tar.gz
 // # tar -xvf libsvm-3.18.tar.gz
@@ -45,108 +47,42 @@ func dumpFingerprintsSVM(group string) error {
 	macI := 1
 	locationI := 1
 
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0755, nil)
-	if err != nil {
-		return err
-	}
-	defer db.Close()
-
-	db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("fingerprints"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			v2 := loadFingerprint(v)
-			for _, fingerprint := range v2.WifiFingerprint {
-				if _, ok := macs[fingerprint.Mac]; !ok {
-					macs[fingerprint.Mac] = macI
-					macsFromID[strconv.Itoa(macI)] = fingerprint.Mac
-					macI++
-				}
-			}
-			if _, ok := locations[v2.Location]; !ok {
-				locations[v2.Location] = locationI
-				locationsFromID[strconv.Itoa(locationI)] = v2.Location
-				locationI++
+	svmData := ""
+	err := storage.ForEachFingerprint(group, "fingerprints", false, func(k string, v2 Fingerprint) bool {
+		for _, fingerprint := range v2.WifiFingerprint {
+			if _, ok := macs[fingerprint.Mac]; !ok {
+				macs[fingerprint.Mac] = macI
+				macsFromID[strconv.Itoa(macI)] = fingerprint.Mac
+				macI++
 			}
 		}
-		return nil
-	})
-
-	svmData := ""
-	err = db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte("fingerprints"))
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			v2 := loadFingerprint(v)
-			svmData = svmData + makeSVMLine(v2, macs, locations)
+		if _, ok := locations[v2.Location]; !ok {
+			locations[v2.Location] = locationI
+			locationsFromID[strconv.Itoa(locationI)] = v2.Location
+			locationI++
 		}
-		return nil
+		svmData = svmData + makeSVMLine(v2, macs, locations)
+		return true
 	})
 	if err != nil {
-		panic(err)
-	}
-
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte("resources"))
-		if err != nil {
-			return fmt.Errorf("create bucket: %s", err)
-		}
-		err = bucket.Put([]byte("svmData"), []byte(svmData))
-		if err != nil {
-			return fmt.Errorf("could add to bucket: %s", err)
-		}
-
-		s, _ := json.Marshal(macsFromID)
-		err = bucket.Put([]byte("macsFromID"), s)
-		if err != nil {
-			return fmt.Errorf("could add to bucket: %s", err)
-		}
-
-		s, _ = json.Marshal(locationsFromID)
-		err = bucket.Put([]byte("locationsFromID"), s)
-		if err != nil {
-			return fmt.Errorf("could add to bucket: %s", err)
-		}
-
-		s, _ = json.Marshal(macs)
-		err = bucket.Put([]byte("macs"), s)
-		if err != nil {
-			return fmt.Errorf("could add to bucket: %s", err)
-		}
-
-		s, _ = json.Marshal(locations)
-		err = bucket.Put([]byte("locations"), s)
-		if err != nil {
-			return fmt.Errorf("could add to bucket: %s", err)
-		}
-
 		return err
-	})
+	}
 
-	return err
+	resources := map[string][]byte{"svmData": []byte(svmData)}
+	resources["macsFromID"], _ = json.Marshal(macsFromID)
+	resources["locationsFromID"], _ = json.Marshal(locationsFromID)
+	resources["macs"], _ = json.Marshal(macs)
+	resources["locations"], _ = json.Marshal(locations)
+	return storage.PutResources(group, resources)
 }
 
 func calculateSVM(group string) error {
 	defer timeTrack(time.Now(), "TIMEING")
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, group+".db"), 0755, nil)
+	v, err := storage.GetResource(group, "svmData")
 	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-	svmData := ""
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("resources"))
-		if b == nil {
-			return fmt.Errorf("Resources dont exist")
-		}
-		v := b.Get([]byte("svmData"))
-		svmData = string(v)
 		return err
-	})
-	if err != nil {
-		panic(err)
 	}
+	svmData := string(v)
 	if len(svmData) == 0 {
 		return fmt.Errorf("No data")
 	}
@@ -265,32 +201,18 @@ func classify(jsonFingerprint Fingerprint) (string, map[string]float64) {
 	if _, err := os.Stat(path.Join(RuntimeArgs.SourcePath, strings.ToLower(jsonFingerprint.Group)+".model")); os.IsNotExist(err) {
 		return "", make(map[string]float64)
 	}
-	db, err := bolt.Open(path.Join(RuntimeArgs.SourcePath, jsonFingerprint.Group+".db"), 0755, nil)
-	if err != nil {
-		panic(err)
-	}
-	defer db.Close()
-
 	var locations map[string]int
 	var macs map[string]int
 	var locationsFromID map[string]string
-	err = db.View(func(tx *bolt.Tx) error {
-		// Assume bucket exists and has keys
-		b := tx.Bucket([]byte("resources"))
-		if b == nil {
-			return fmt.Errorf("Resources dont exist")
-		}
-		v := b.Get([]byte("locations"))
-		json.Unmarshal(v, &locations)
-		v = b.Get([]byte("locationsFromID"))
-		json.Unmarshal(v, &locationsFromID)
-		v = b.Get([]byte("macs"))
-		json.Unmarshal(v, &macs)
-		return err
-	})
+	v, err := storage.GetResource(jsonFingerprint.Group, "locations")
 	if err != nil {
-		panic(err)
+		return "", make(map[string]float64)
 	}
+	json.Unmarshal(v, &locations)
+	v, _ = storage.GetResource(jsonFingerprint.Group, "locationsFromID")
+	json.Unmarshal(v, &locationsFromID)
+	v, _ = storage.GetResource(jsonFingerprint.Group, "macs")
+	json.Unmarshal(v, &macs)
 
 	svmData := makeSVMLine(jsonFingerprint, macs, locations)
 	if len(svmData) < 5 {
@@ -0,0 +1,217 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// sweep.go cross-validates a group over ranges of the MixIn, the variability
+// cutoff and the RSSI smoothing without changing its parameters, so overrides
+// can be chosen from the accuracy they give.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sweepMaxPoints is the most combinations of hyperparameters a sweep can try
+const sweepMaxPoints = 1000
+
+// SweepPoint is the cross validation of one combination of hyperparameters
+type SweepPoint struct {
+	MixIn     float64        `json:"mixin"`
+	Cutoff    float64        `json:"cutoff"`
+	Smoothing int            `json:"smoothing"` // number of PdfType terms used to smooth the RSSI distributions
+	Average   float64        `json:"average"`
+	Accuracy  map[string]int `json:"accuracy"` // percent of each location classified correctly
+}
+
+// SweepNetwork is the sweep of one network of a group
+type SweepNetwork struct {
+	Network string       `json:"network"`
+	MixIn   float64      `json:"current_mixin"`
+	Cutoff  float64      `json:"current_cutoff"`
+	Best    SweepPoint   `json:"best"`
+	Points  []SweepPoint `json:"points"`
+}
+
+// sweepParameters calculates, for each network of group (or only network if it
+// is not empty), the accuracy of every combination of mixins, cutoffs and
+// smoothings on the fingerprints that are held out for cross validation, like
+// crossValidation does. Self-trained fingerprints are not used.
+func sweepParameters(group string, network string, mixins []float64, cutoffs []float64, smoothings []int) ([]SweepNetwork, error) {
+	if len(mixins)*len(cutoffs)*len(smoothings) > sweepMaxPoints {
+		return nil, fmt.Errorf("too many combinations, at most %d can be tried", sweepMaxPoints)
+	}
+	for _, smoothing := range smoothings {
+		if smoothing < 1 || smoothing > len(PdfType) {
+			return nil, fmt.Errorf("smoothing must be between 1 and %d", len(PdfType))
+		}
+	}
+	fingerprintsInMemory, fingerprintsOrdering, err := getFingerprintsInMemory(group)
+	if err != nil {
+		return nil, err
+	}
+	var ps = *NewFullParameters()
+	getParameters(group, &ps, fingerprintsInMemory, fingerprintsOrdering)
+	if _, ok := ps.NetworkLocs[network]; len(network) > 0 && !ok {
+		return nil, fmt.Errorf("network %s does not exist", network)
+	}
+	current, _ := openParameters(group)
+
+	sweeps := make(map[string]*SweepNetwork)
+	for n := range ps.NetworkLocs {
+		if len(network) > 0 && n != network {
+			continue
+		}
+		sweeps[n] = &SweepNetwork{Network: n, Points: []SweepPoint{}}
+		if priors, ok := current.Priors[n]; ok {
+			sweeps[n].MixIn = priors.Special["MixIn"]
+			sweeps[n].Cutoff = priors.Special["VarabilityCutoff"]
+		}
+	}
+
+	heldOut := func(it float64) bool { return math.Mod(it, FoldCrossValidation) == 0 }
+	for _, smoothing := range smoothings {
+		calculatePriorsSmoothed(&ps, fingerprintsInMemory, fingerprintsOrdering, heldOut, nil, PdfType[:smoothing])
+		for _, cutoff := range cutoffs {
+			//                 network      id      loc    value
+			PBayes1 := make(map[string]map[string]map[string]float64)
+			PBayes2 := make(map[string]map[string]map[string]float64)
+			for n := range sweeps {
+				PBayes1[n] = make(map[string]map[string]float64)
+				PBayes2[n] = make(map[string]map[string]float64)
+				for i, id := range fingerprintsOrdering {
+					fingerprint := fingerprintsInMemory[id]
+					if !heldOut(float64(i)) || len(fingerprint.WifiFingerprint) == 0 || !ps.NetworkLocs[n][fingerprint.Location] {
+						continue
+					}
+					PBayes1[n][id], PBayes2[n][id] = calculatePosteriorThreadSafe(fingerprint, ps, cutoff)
+				}
+			}
+
+			for n, sweep := range sweeps {
+				for _, mixin := range mixins {
+					correct := make(map[string]int)
+					total := make(map[string]int)
+					for id := range PBayes1[n] {
+						locationTrue := fingerprintsInMemory[id].Location
+						total[locationTrue]++
+						if sweepGuess(ps.NetworkLocs[n], PBayes1[n][id], PBayes2[n][id], mixin) == locationTrue {
+							correct[locationTrue]++
+						}
+					}
+					point := SweepPoint{MixIn: mixin, Cutoff: cutoff, Smoothing: smoothing, Accuracy: make(map[string]int)}
+					for loc := range ps.NetworkLocs[n] {
+						if total[loc] > 0 {
+							point.Accuracy[loc] = 100 * correct[loc] / total[loc]
+							point.Average += float64(point.Accuracy[loc])
+						}
+					}
+					point.Average = point.Average / float64(len(ps.NetworkLocs[n]))
+					sweep.Points = append(sweep.Points, point)
+				}
+			}
+		}
+	}
+
+	result := []SweepNetwork{}
+	for _, sweep := range sweeps {
+		for _, point := range sweep.Points {
+			if point.Average > sweep.Best.Average || sweep.Best.Accuracy == nil {
+				sweep.Best = point
+			}
+		}
+		result = append(result, *sweep)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Network < result[j].Network })
+	return result, nil
+}
+
+// sweepGuess mixes the two terms of calculatePosteriorThreadSafe and returns the best location
+func sweepGuess(locs map[string]bool, bayes1 map[string]float64, bayes2 map[string]float64, mixin float64) string {
+	if len(locs) == 1 {
+		for loc := range locs {
+			return loc
+		}
+	}
+	bestLocation := ""
+	maxVal := float64(-100)
+	for loc := range bayes1 {
+		if mix := mixin*bayes1[loc] + (1-mixin)*bayes2[loc]; mix > maxVal {
+			maxVal = mix
+			bestLocation = loc
+		}
+	}
+	return bestLocation
+}
+
+func parseSweepFloats(s string, defaults []float64) ([]float64, error) {
+	if len(s) == 0 {
+		return defaults, nil
+	}
+	vals := []float64{}
+	for _, v := range strings.Split(s, ",") {
+		val, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil {
+			return nil, err
+		}
+		if val < 0 || val > 1 {
+			return nil, fmt.Errorf("%v is not between 0 and 1", val)
+		}
+		vals = append(vals, val)
+	}
+	return vals, nil
+}
+
+// getSweep usage: curl "http://localhost:8003/sweep?group=X&network=0&mixins=0.1,0.5,0.9&cutoffs=0,0.05&smoothing=2,6"
+// Every list is optional. The parameters of the group are not changed.
+func getSweep(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	mixins, err := parseSweepFloats(c.Query("mixins"), []float64{0, 0.1, 0.2, 0.3, 0.4, 0.5, 0.6, 0.7, 0.8, 0.9, 1})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not parse mixins: " + err.Error(), "success": false})
+		return
+	}
+	cutoffs, err := parseSweepFloats(c.Query("cutoffs"), []float64{0, 0.005, 0.01, 0.05, 0.1, 0.2})
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not parse cutoffs: " + err.Error(), "success": false})
+		return
+	}
+	smoothings := []int{len(PdfType)}
+	if v := c.Query("smoothing"); len(v) > 0 {
+		smoothings = []int{}
+		for _, s := range strings.Split(v, ",") {
+			smoothing, err := strconv.Atoi(strings.TrimSpace(s))
+			if err != nil {
+				c.JSON(http.StatusOK, gin.H{"message": "Could not parse smoothing: " + err.Error(), "success": false})
+				return
+			}
+			smoothings = append(smoothings, smoothing)
+		}
+	}
+
+	sweeps, err := sweepParameters(group, strings.TrimSpace(c.Query("network")), mixins, cutoffs, smoothings)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	message := "Tried " + strconv.Itoa(len(mixins)*len(cutoffs)*len(smoothings)) + " combinations on " + strconv.Itoa(len(sweeps)) + " networks"
+	c.JSON(http.StatusOK, gin.H{"message": message, "success": true, "networks": sweeps})
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSweepParameters(t *testing.T) {
+	defer useMemoryStore()()
+
+	simulateGrid(t, Simulation{
+		Group: "sweeptest",
+		Rooms: []SimulationRoom{
+			{Name: "kitchen", X1: 0, Y1: 0, X2: 6, Y2: 6},
+			{Name: "bedroom", X1: 24, Y1: 24, X2: 30, Y2: 30},
+			{Name: "office", X1: 24, Y1: 0, X2: 30, Y2: 6},
+		},
+	})
+	before, err := openParameters("sweeptest")
+	assert.Nil(t, err)
+	n := "0"
+	mixin := before.Priors[n].Special["MixIn"]
+	cutoff := before.Priors[n].Special["VarabilityCutoff"]
+
+	sweeps, err := sweepParameters("sweeptest", "", []float64{0, mixin, 1}, []float64{0, cutoff}, []int{2, len(PdfType)})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(sweeps))
+	sweep := sweeps[0]
+	assert.Equal(t, n, sweep.Network)
+	assert.Equal(t, mixin, sweep.MixIn)
+	assert.Equal(t, cutoff, sweep.Cutoff)
+	assert.Equal(t, 12, len(sweep.Points))
+	for _, point := range sweep.Points {
+		assert.Equal(t, 3, len(point.Accuracy))
+		assert.True(t, point.Average <= sweep.Best.Average)
+		// the current parameters give the accuracy that the group reports
+		if point.MixIn == mixin && point.Cutoff == cutoff && point.Smoothing == len(PdfType) {
+			assert.Equal(t, before.Results[n].Accuracy, point.Accuracy)
+		}
+	}
+
+	// the parameters of the group are unchanged
+	after, err := openParameters("sweeptest")
+	assert.Nil(t, err)
+	assert.Equal(t, mixin, after.Priors[n].Special["MixIn"])
+	assert.Equal(t, before.Results[n].Accuracy, after.Results[n].Accuracy)
+
+	_, err = sweepParameters("sweeptest", "nonetwork", []float64{0.5}, []float64{0}, []int{1})
+	assert.NotNil(t, err)
+	_, err = sweepParameters("sweeptest", n, []float64{0.5}, []float64{0}, []int{len(PdfType) + 1})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,243 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// trash.go keeps deleted groups and fingerprints for a while so they can be restored.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	registerResources("trashEntry")
+}
+
+// trashPrefix starts the names of the groups that hold deleted data. Each
+// deletion is kept in its own group, which is purged once it expires.
+const trashPrefix = "trash/"
+
+// TrashEntry describes something that was deleted
+type TrashEntry struct {
+	ID           string    `json:"id"`
+	Group        string    `json:"group"`
+	Description  string    `json:"description"`
+	WholeGroup   bool      `json:"whole_group"`
+	Fingerprints int       `json:"fingerprints"`
+	Deleted      time.Time `json:"deleted"`
+	Expires      time.Time `json:"expires"`
+}
+
+func newTrashEntry(group string, description string) TrashEntry {
+	now := time.Now()
+	return TrashEntry{
+		ID:          strconv.FormatInt(now.UnixNano(), 10) + strings.ToLower(RandStringBytesMaskImprSrc(4)),
+		Group:       group,
+		Description: description,
+		Deleted:     now,
+		Expires:     now.Add(RuntimeArgs.TrashExpiry),
+	}
+}
+
+func saveTrashEntry(entry TrashEntry) error {
+	jsonByte, _ := json.Marshal(entry)
+	return storage.PutResources(trashPrefix+entry.ID, map[string][]byte{"trashEntry": jsonByte})
+}
+
+// openTrashEntry only accepts ids like the ones newTrashEntry makes, so that
+// trashPrefix+id can not name a group outside of the trash.
+func openTrashEntry(id string) (TrashEntry, error) {
+	var entry TrashEntry
+	if len(id) == 0 || strings.ContainsAny(id, "/\\") || strings.Contains(id, "..") || strings.ContainsRune(id, filepath.Separator) {
+		return entry, fmt.Errorf("%s is not a valid trash id", id)
+	}
+	v, err := storage.GetResource(trashPrefix+id, "trashEntry")
+	if err != nil {
+		return entry, fmt.Errorf("%s is not in the trash", id)
+	}
+	err = json.Unmarshal(v, &entry)
+	return entry, err
+}
+
+// trashGroup moves a whole group to the trash.
+func trashGroup(group string) (TrashEntry, error) {
+	entry := newTrashEntry(group, "group "+group)
+	entry.WholeGroup = true
+	for _, bucket := range []string{"fingerprints", "fingerprints-track"} {
+		storage.ForEachFingerprint(group, bucket, false, func(k string, v Fingerprint) bool {
+			entry.Fingerprints++
+			return true
+		})
+	}
+	err := storage.RenameGroup(group, trashPrefix+entry.ID)
+	if err != nil {
+		return entry, err
+	}
+	forgetShadow(group)
+	return entry, saveTrashEntry(entry)
+}
+
+// trashFingerprintsWhere moves the fingerprints of a bucket that fn matches to
+// the trash, as they are stored, so that restoring them gives back what was
+// deleted. Nothing is added to the trash if nothing matches.
+func trashFingerprintsWhere(group string, bucket string, description string, fn func(fingerprint Fingerprint) bool) (TrashEntry, error) {
+	return trashFingerprintKeysWhere(group, bucket, description, func(key string, fingerprint Fingerprint) bool {
+		return fn(fingerprint)
+	})
+}
+
+// trashFingerprintKeysWhere is trashFingerprintsWhere for fn that also need the key of the fingerprint.
+func trashFingerprintKeysWhere(group string, bucket string, description string, fn func(key string, fingerprint Fingerprint) bool) (TrashEntry, error) {
+	entry := newTrashEntry(group, description)
+	toTrash := make(map[string]Fingerprint)
+	forEachStoredFingerprint(group, bucket, func(k string, v Fingerprint) bool {
+		if fn(k, v) {
+			toTrash[k] = v
+		}
+		return true
+	})
+	entry.Fingerprints = len(toTrash)
+	if len(toTrash) == 0 {
+		return entry, nil
+	}
+	err := storage.UpdateFingerprints(trashPrefix+entry.ID, bucket, toTrash)
+	if err != nil {
+		return entry, err
+	}
+	err = saveTrashEntry(entry)
+	if err != nil {
+		return entry, err
+	}
+	keys := []string{}
+	for k := range toTrash {
+		keys = append(keys, k)
+	}
+	return entry, storage.DeleteFingerprints(group, bucket, keys)
+}
+
+// listTrash returns what is in the trash for group, or for every group if it is empty, newest first.
+func listTrash(group string) []TrashEntry {
+	entries := []TrashEntry{}
+	names, _ := storage.ListTrash()
+	for _, name := range names {
+		entry, err := openTrashEntry(strings.TrimPrefix(name, trashPrefix))
+		if err != nil {
+			continue
+		}
+		if len(group) == 0 || entry.Group == group {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Deleted.After(entries[j].Deleted) })
+	return entries
+}
+
+// restoreTrash puts deleted data back. A deleted group is restored as it was
+// unless it has been created again, in which case the deleted fingerprints
+// are merged into it like deleted locations and users are.
+func restoreTrash(id string) (TrashEntry, error) {
+	entry, err := openTrashEntry(id)
+	if err != nil {
+		return entry, err
+	}
+	if entry.WholeGroup && !storage.GroupExists(entry.Group) {
+		err = storage.RenameGroup(trashPrefix+id, entry.Group)
+		if err != nil {
+			return entry, err
+		}
+		// the entry would otherwise be kept as a resource of the group
+		storage.DeleteResources(entry.Group, []string{"trashEntry"})
+		forgetShadow(entry.Group)
+		recalculateGroup(entry.Group)
+		return entry, nil
+	}
+	_, err = mergeGroups(MergeRequest{From: trashPrefix + id, To: entry.Group})
+	if err != nil {
+		return entry, err
+	}
+	return entry, storage.DeleteGroup(trashPrefix + id)
+}
+
+// purgeTrash permanently deletes everything in the trash that expired before now.
+func purgeTrash(now time.Time) int {
+	purged := 0
+	for _, entry := range listTrash("") {
+		if entry.Expires.Before(now) {
+			if err := storage.DeleteGroup(trashPrefix + entry.ID); err == nil {
+				purged++
+			}
+		}
+	}
+	return purged
+}
+
+func purgeTrashEvery(interval time.Duration) {
+	for {
+		if purged := purgeTrash(time.Now()); purged > 0 {
+			Debug.Printf("Purged %d things from the trash", purged)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// getTrash usage: curl "http://localhost:8003/trash?group=X"
+func getTrash(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "")))
+	entries := listTrash(group)
+	c.JSON(http.StatusOK, gin.H{"message": "Found " + strconv.Itoa(len(entries)) + " things in the trash", "success": true, "trash": entries})
+}
+
+// putTrash usage: curl -X PUT "http://localhost:8003/trash?id=X" restores X
+func putTrash(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	id := c.DefaultQuery("id", "noneasdf")
+	entry, err := restoreTrash(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	go resetCache("userCache")
+	go resetCache("userPositionCache")
+	c.JSON(http.StatusOK, gin.H{"message": "Restored " + entry.Description + " in " + entry.Group, "success": true})
+}
+
+// deleteTrash usage: curl -X DELETE "http://localhost:8003/trash?id=X" permanently deletes X
+func deleteTrash(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "DELETE, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	id := c.DefaultQuery("id", "noneasdf")
+	if _, err := openTrashEntry(id); err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	storage.DeleteGroup(trashPrefix + id)
+	c.JSON(http.StatusOK, gin.H{"message": "Permanently deleted " + id, "success": true})
+}
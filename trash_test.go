@@ -0,0 +1,78 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrash(t *testing.T) {
+	defer useMemoryStore()()
+	defer func() { RuntimeArgs.TrashExpiry = 0 }()
+	RuntimeArgs.TrashExpiry = time.Hour
+
+	storage.PutFingerprints("trashtest", "fingerprints", []Fingerprint{
+		{Group: "trashtest", Location: "kitchen", Timestamp: 1, WifiFingerprint: []Router{{Mac: "aa", Rssi: -40}}},
+		{Group: "trashtest", Location: "den", Timestamp: 2, WifiFingerprint: []Router{{Mac: "aa", Rssi: -70}}},
+	})
+
+	entry, err := trashFingerprintsWhere("trashtest", "fingerprints", "location den", func(fingerprint Fingerprint) bool {
+		return fingerprint.Location == "den"
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, entry.Fingerprints)
+	assert.Equal(t, []string{"kitchen"}, getUniqueLocations("trashtest"))
+	groups, _ := storage.ListGroups()
+	assert.Equal(t, []string{"trashtest"}, groups)
+	assert.Equal(t, 1, len(listTrash("trashtest")))
+	assert.Equal(t, 0, len(listTrash("othergroup")))
+
+	_, err = restoreTrash(entry.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"kitchen", "den"}, getUniqueLocations("trashtest"))
+	assert.Equal(t, 0, len(listTrash("")))
+
+	// the -filter whitelist does not change what is restored
+	office := []Router{{Mac: "aa", Rssi: -40}, {Mac: "bb", Rssi: -60}}
+	storage.PutFingerprints("trashtest", "fingerprints", []Fingerprint{{Group: "trashtest", Location: "office", Timestamp: 3, WifiFingerprint: office}})
+	RuntimeArgs.Filtering = true
+	RuntimeArgs.FilterMacs = map[string]bool{"bb": true}
+	entry, err = trashFingerprintsWhere("trashtest", "fingerprints", "location office", func(fingerprint Fingerprint) bool {
+		return fingerprint.Location == "office"
+	})
+	assert.Nil(t, err)
+	_, err = restoreTrash(entry.ID)
+	RuntimeArgs.Filtering = false
+	RuntimeArgs.FilterMacs = nil
+	assert.Nil(t, err)
+	restored := []Router{}
+	storage.ForEachFingerprint("trashtest", "fingerprints", false, func(k string, v Fingerprint) bool {
+		if v.Location == "office" {
+			restored = v.WifiFingerprint
+		}
+		return true
+	})
+	assert.Equal(t, office, restored)
+
+	entry, err = trashGroup("trashtest")
+	assert.Nil(t, err)
+	assert.Equal(t, 3, entry.Fingerprints)
+	assert.False(t, storage.GroupExists("trashtest"))
+	assert.Equal(t, 0, purgeTrash(time.Now()))
+	_, err = restoreTrash(entry.ID)
+	assert.Nil(t, err)
+	assert.True(t, storage.GroupExists("trashtest"))
+
+	trashGroup("trashtest")
+	assert.Equal(t, 1, purgeTrash(time.Now().Add(2*time.Hour)))
+	assert.Equal(t, 0, len(listTrash("")))
+	_, err = restoreTrash(entry.ID)
+	assert.NotNil(t, err)
+
+	// ids can not reach groups outside of the trash
+	for _, id := range []string{"", "../trashtest", "x/../../trashtest", "..", "a\\b"} {
+		_, err = openTrashEntry(id)
+		assert.NotNil(t, err)
+	}
+}
@@ -0,0 +1,312 @@
+// Copyright 2015-2016 Zack Scholl. All rights reserved.
+// Use of this source code is governed by a AGPL
+// license that can be found in the LICENSE file.
+
+// userpriors.go learns how often each user is at each location, and optionally
+// at each hour of the day, from their tracking history. Tracking blends it with
+// the uniform prior, so that the office desk is favoured during work hours.
+// Every other classification, and the optimizer, uses the uniform prior. The
+// history is learned again in the background, as classifying the tracked
+// fingerprints is too slow to do when the priors are recalculated.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	registerResources("userPriorSettings", "userPriors")
+}
+
+// classifyTracksMaxFingerprints is the most tracked fingerprints of a user classifyTracks classifies
+const classifyTracksMaxFingerprints = 10000
+
+// userPriorsHourWeight is how many tracked fingerprints the prior of a user
+// counts for when smoothing the prior of an hour towards it
+const userPriorsHourWeight = 5
+
+// UserPriorSettings is how much a group trusts the tracking history of its users
+type UserPriorSettings struct {
+	Blend     float64 `json:"blend"`       // 0 uses the uniform prior, 1 only the history
+	TimeOfDay bool    `json:"time_of_day"` // use the history of the hour of the fingerprint
+	Days      float64 `json:"days"`        // how far back tracked fingerprints are used
+}
+
+// NewUserPriorSettings returns the settings of a group that has not changed them
+func NewUserPriorSettings() UserPriorSettings {
+	return UserPriorSettings{Blend: 0, Days: 30}
+}
+
+// UserLocationHistory counts where a user was classified
+type UserLocationHistory struct {
+	Counts map[string]int     `json:"counts"`
+	Hourly [24]map[string]int `json:"hourly"`
+}
+
+// UserPriors are the settings of a group and the history of its users
+type UserPriors struct {
+	Calculated time.Time                       `json:"calculated"`
+	Settings   UserPriorSettings               `json:"settings"`
+	Users      map[string]*UserLocationHistory `json:"users"`
+}
+
+func getUserPriorSettings(group string) UserPriorSettings {
+	settings := NewUserPriorSettings()
+	v, err := storage.GetResource(group, "userPriorSettings")
+	if err == nil && len(v) > 0 {
+		json.Unmarshal(v, &settings)
+	}
+	return settings
+}
+
+func setUserPriorSettings(group string, settings UserPriorSettings) error {
+	if settings.Blend < 0 || settings.Blend > 1 {
+		return fmt.Errorf("blend must be between 0 and 1")
+	}
+	if settings.Days <= 0 {
+		return fmt.Errorf("days must be more than 0")
+	}
+	jsonByte, _ := json.Marshal(settings)
+	err := storage.PutResources(group, map[string][]byte{"userPriorSettings": jsonByte})
+	deleteUserPriorsCache(group)
+	return err
+}
+
+// classifiedTrack is where a tracked fingerprint was classified
+type classifiedTrack struct {
+	Timestamp int64
+	Location  string
+}
+
+// classifyTracks classifies the tracked fingerprints of group from the last
+// days with ps and the uniform prior, and returns them by user, oldest first.
+// Only the fingerprints of user are classified, unless it is empty.
+func classifyTracks(group string, ps FullParameters, days float64, user string) map[string][]classifiedTrack {
+	tracks := make(map[string][]classifiedTrack)
+	since := time.Now().Add(-time.Duration(days * float64(24*time.Hour))).UnixNano()
+	classified := make(map[string]int)
+	storage.ForEachFingerprint(group, "fingerprints-track", true, func(k string, v Fingerprint) bool {
+		if v.Timestamp < since {
+			return false
+		}
+		if len(v.WifiFingerprint) == 0 || len(v.Username) == 0 || (len(user) > 0 && v.Username != user) {
+			return true
+		}
+		if classified[v.Username] >= classifyTracksMaxFingerprints {
+			return true
+		}
+		classified[v.Username]++
+		v.Group = group
+		if location, _ := calculatePosterior(v, ps); len(location) > 0 {
+			tracks[v.Username] = append(tracks[v.Username], classifiedTrack{Timestamp: v.Timestamp, Location: location})
+		}
+		return true
+	})
+	for user := range tracks {
+		sort.Slice(tracks[user], func(i, j int) bool { return tracks[user][i].Timestamp < tracks[user][j].Timestamp })
+	}
+	return tracks
+}
+
+// calculateUserPriors counts where each user of group was classified, with ps
+// and the uniform prior, in the tracked fingerprints of the last settings.Days.
+func calculateUserPriors(group string, ps FullParameters, settings UserPriorSettings) UserPriors {
+	priors := UserPriors{Calculated: time.Now(), Settings: settings, Users: make(map[string]*UserLocationHistory)}
+	for user, tracks := range classifyTracks(group, ps, settings.Days, "") {
+		history := &UserLocationHistory{Counts: make(map[string]int)}
+		for hour := range history.Hourly {
+			history.Hourly[hour] = make(map[string]int)
+		}
+		for _, track := range tracks {
+			history.Counts[track.Location]++
+			history.Hourly[time.Unix(0, track.Timestamp).Hour()][track.Location]++
+		}
+		priors.Users[user] = history
+	}
+	return priors
+}
+
+// refreshUserPriors learns the history of the users of group again with its
+// current parameters, if the group blends it in.
+func refreshUserPriors(group string) error {
+	settings := getUserPriorSettings(group)
+	if settings.Blend == 0 {
+		return nil
+	}
+	ps, err := openParameters(group)
+	if err != nil {
+		return err
+	}
+	return saveUserPriors(group, calculateUserPriors(group, ps, settings))
+}
+
+func refreshUserPriorsEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		groups, err := storage.ListGroups()
+		if err != nil {
+			Warning.Println(err)
+			continue
+		}
+		for _, group := range groups {
+			if err := refreshUserPriors(group); err != nil {
+				Warning.Println(err)
+			}
+		}
+	}
+}
+
+func saveUserPriors(group string, priors UserPriors) error {
+	jsonByte, _ := json.Marshal(priors)
+	err := storage.PutResources(group, map[string][]byte{"userPriors": jsonByte})
+	deleteUserPriorsCache(group)
+	return err
+}
+
+// openUserPriors returns the user priors of group with its current settings.
+func openUserPriors(group string) UserPriors {
+	if cached, ok := getUserPriorsCache(group); ok {
+		return cached
+	}
+	priors := UserPriors{Users: make(map[string]*UserLocationHistory)}
+	v, err := storage.GetResource(group, "userPriors")
+	if err == nil && len(v) > 0 {
+		json.Unmarshal(v, &priors)
+	}
+	priors.Settings = getUserPriorSettings(group)
+	setUserPriorsCache(group, priors)
+	return priors
+}
+
+// userLocationPrior returns the prior of each location of the network of res
+// in ps for the user and time of res, or nil if the group uses the uniform
+// prior or knows nothing of the user. Only tracking and its shadow use it.
+func userLocationPrior(res Fingerprint, ps FullParameters) map[string]float64 {
+	macs := []string{}
+	for _, router := range res.WifiFingerprint {
+		macs = append(macs, router.Mac)
+	}
+	n, _ := hasNetwork(ps.NetworkMacs, macs)
+	locs := ps.NetworkLocs[n]
+	if len(res.Username) == 0 || len(locs) == 0 {
+		return nil
+	}
+	priors := openUserPriors(strings.ToLower(res.Group))
+	history, ok := priors.Users[strings.ToLower(res.Username)]
+	if priors.Settings.Blend == 0 || !ok {
+		return nil
+	}
+
+	timestamp := res.Timestamp
+	if timestamp == 0 {
+		timestamp = time.Now().UnixNano()
+	}
+	hourly := history.Hourly[time.Unix(0, timestamp).Hour()]
+	total, hourTotal := 0, 0
+	for loc := range locs {
+		total += history.Counts[loc]
+		hourTotal += hourly[loc]
+	}
+
+	uniform := 1.0 / float64(len(locs))
+	prior := make(map[string]float64, len(locs))
+	for loc := range locs {
+		// add-one smoothing, so no location is ever ruled out
+		p := float64(history.Counts[loc]+1) / float64(total+len(locs))
+		if priors.Settings.TimeOfDay {
+			p = (float64(hourly[loc]) + userPriorsHourWeight*p) / float64(hourTotal+userPriorsHourWeight)
+		}
+		prior[loc] = (1-priors.Settings.Blend)*uniform + priors.Settings.Blend*p
+	}
+	return prior
+}
+
+// getUserPriors usage: curl "http://localhost:8003/userpriors?group=X&user=Y"
+// Without a user, the settings and the users that have a history are returned.
+func getUserPriors(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	user := strings.TrimSpace(strings.ToLower(c.DefaultQuery("user", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	priors := openUserPriors(group)
+	if user == "noneasdf" {
+		users := []string{}
+		for u := range priors.Users {
+			users = append(users, u)
+		}
+		sort.Strings(users)
+		c.JSON(http.StatusOK, gin.H{"message": "Found history for " + strconv.Itoa(len(users)) + " users", "success": true, "settings": priors.Settings, "users": users, "calculated": priors.Calculated})
+		return
+	}
+	history, ok := priors.Users[user]
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{"message": "No tracking history for " + user, "success": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Found history for " + user, "success": true, "settings": priors.Settings, "history": history, "calculated": priors.Calculated})
+}
+
+// putUserPriors usage: curl -X PUT "http://localhost:8003/userpriors?group=X&blend=0.5&time_of_day=true&days=30"
+// Settings that are not given are kept, and the history is learned again.
+func putUserPriors(c *gin.Context) {
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
+	c.Writer.Header().Set("Access-Control-Max-Age", "86400")
+	c.Writer.Header().Set("Access-Control-Allow-Methods", "PUT, OPTIONS")
+	c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, X-Max")
+	c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
+
+	group := strings.TrimSpace(strings.ToLower(c.DefaultQuery("group", "noneasdf")))
+	if !groupExists(group) {
+		c.JSON(http.StatusOK, gin.H{"message": "You should insert a fingerprint first, see documentation", "success": false})
+		return
+	}
+	settings := getUserPriorSettings(group)
+	var err error
+	for key, value := range map[string]*float64{"blend": &settings.Blend, "days": &settings.Days} {
+		if v := c.Query(key); len(v) > 0 && err == nil {
+			*value, err = strconv.ParseFloat(v, 64)
+		}
+	}
+	if v := c.Query("time_of_day"); len(v) > 0 && err == nil {
+		settings.TimeOfDay, err = strconv.ParseBool(v)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not parse settings: " + err.Error(), "success": false})
+		return
+	}
+	if err = setUserPriorSettings(group, settings); err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	ps, err := openParameters(group)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Could not open parameters for " + group + ", try /calculate", "success": false})
+		return
+	}
+	priors := calculateUserPriors(group, ps, settings)
+	if err = saveUserPriors(group, priors); err != nil {
+		c.JSON(http.StatusOK, gin.H{"message": err.Error(), "success": false})
+		return
+	}
+	resetCache("userPositionCache")
+	c.JSON(http.StatusOK, gin.H{"message": "Saved user prior settings and learned the history of " + strconv.Itoa(len(priors.Users)) + " users", "success": true, "settings": settings})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserPriors(t *testing.T) {
+	defer useMemoryStore()()
+
+	s := simulateGrid(t, Simulation{
+		Group: "userpriorstest",
+		Rooms: []SimulationRoom{
+			{Name: "kitchen", X1: 0, Y1: 0, X2: 6, Y2: 6},
+			{Name: "office", X1: 24, Y1: 0, X2: 30, Y2: 6},
+		},
+	})
+
+	// zack works in the office in the morning and cooks in the evening
+	r := rand.New(rand.NewSource(2))
+	morning := time.Now().Truncate(time.Hour).Add(-14 * time.Hour)
+	evening := morning.Add(12 * time.Hour)
+	tracks := []Fingerprint{}
+	for i := 0; i < 30; i++ {
+		tracks = append(tracks, Fingerprint{Username: "zack", Timestamp: morning.Add(time.Duration(i) * time.Minute).UnixNano(), WifiFingerprint: s.scan(r, 25+r.Float64()*4, 1+r.Float64()*4)})
+	}
+	for i := 0; i < 10; i++ {
+		tracks = append(tracks, Fingerprint{Username: "zack", Timestamp: evening.Add(time.Duration(i) * time.Minute).UnixNano(), WifiFingerprint: s.scan(r, 1+r.Float64()*4, 1+r.Float64()*4)})
+	}
+	_, err := insertSimulated("userpriorstest", "fingerprints-track", tracks)
+	assert.Nil(t, err)
+
+	// the uniform prior is used until the group blends in the history
+	ps, err := openParameters("userpriorstest")
+	assert.Nil(t, err)
+	between := Fingerprint{Group: "userpriorstest", Username: "zack", Timestamp: morning.UnixNano(), WifiFingerprint: s.scan(r, 15, 3)}
+	assert.Nil(t, userLocationPrior(between, ps))
+
+	settings := NewUserPriorSettings()
+	settings.Blend = 0.8
+	settings.TimeOfDay = true
+	assert.Nil(t, setUserPriorSettings("userpriorstest", settings))
+	// recalculating leaves learning the history to the background
+	assert.Nil(t, optimizePriorsThreaded("userpriorstest"))
+	assert.Empty(t, openUserPriors("userpriorstest").Users)
+	assert.Nil(t, refreshUserPriors("userpriorstest"))
+	priors := openUserPriors("userpriorstest")
+	assert.Equal(t, map[string]int{"office": 30, "kitchen": 10}, priors.Users["zack"].Counts)
+	assert.Equal(t, 30, priors.Users["zack"].Hourly[morning.Hour()]["office"])
+
+	ps, err = openParameters("userpriorstest")
+	assert.Nil(t, err)
+	inTheMorning := userLocationPrior(between, ps)
+	assert.InDelta(t, 1, inTheMorning["kitchen"]+inTheMorning["office"], 1e-9)
+	assert.True(t, inTheMorning["office"] > 0.8)
+	between.Timestamp = evening.UnixNano()
+	inTheEvening := userLocationPrior(between, ps)
+	assert.True(t, inTheEvening["kitchen"] > inTheEvening["office"])
+
+	// the history moves the posterior towards where the user usually is,
+	// when it is given explicitly as tracking does
+	between.Timestamp = morning.UnixNano()
+	_, withHistory := calculatePosteriorWithPrior(between, ps, userLocationPrior(between, ps))
+	_, without := calculatePosterior(between, ps)
+	assert.True(t, withHistory["office"]-withHistory["kitchen"] > without["office"]-without["kitchen"])
+
+	settings.Blend = 2
+	assert.NotNil(t, setUserPriorSettings("userpriorstest", settings))
+}